@@ -0,0 +1,90 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/BrunoTeixeira1996/gmah/internal/config"
+	"github.com/BrunoTeixeira1996/gmah/internal/export"
+	"github.com/BrunoTeixeira1996/gmah/internal/listing"
+	"github.com/BrunoTeixeira1996/gmah/internal/store"
+)
+
+// Runs gmah export, which writes the listings of the catalog to a file or to stdout
+// it opens the catalog read only so it can run next to the daemon
+func exportCommand(argv []string) error {
+	fs := flag.NewFlagSet("export", flag.ContinueOnError)
+	var formatFlag = fs.String("format", "csv", "csv, jsonl or xlsx")
+	var fromFlag = fs.String("from", "", "-from=YYYY-MM-DD, listings seen since that day")
+	var toFlag = fs.String("to", "", "-to=YYYY-MM-DD, listings seen until that day")
+	var searchFlag = fs.String("search", "", "-search='name of a saved search of the config'")
+	var nearFlag = fs.String("near", "", "-near='name of a point of interest of the config', used with -km")
+	var kmFlag = fs.String("km", "", "-km=10, listings at most that far from -near")
+	var sortFlag = fs.String("sort", "", "newest, price, price_per_m2 or km:<point of interest>")
+	var outFlag = fs.String("o", "", "-o='/path/listings.csv' (defaults to stdout)")
+	var dataFlag = fs.String("data", "", "-data='/path/data/' (defaults to /perm/home/gmah/data on gokrazy and ./data otherwise)")
+	var gokrazyFlag = fs.Bool("gokrazy", false, "use this if you are using gokrazy")
+	var configFlag = fs.String("config", "", "-config='/path/config.json'")
+	if err := fs.Parse(argv); err != nil {
+		return err
+	}
+
+	format, err := export.ParseFormat(*formatFlag)
+	if err != nil {
+		return err
+	}
+	cfg, err := config.Load(*configFlag)
+	if err != nil {
+		return err
+	}
+	filter, err := export.NewFilter(*fromFlag, *toFlag, *searchFlag, cfg.SavedSearches, time.Local)
+	if err != nil {
+		return err
+	}
+	if filter.Within, err = export.NewWithin(*nearFlag, *kmFlag, cfg.POIs); err != nil {
+		return err
+	}
+
+	dataDir := *dataFlag
+	if dataDir == "" {
+		dataDir = defaultDataDir(*gokrazyFlag)
+	}
+	st, err := store.Open(dataDir)
+	if err != nil {
+		return fmt.Errorf("Error while opening the data directory: %w", err)
+	}
+	catalog, err := listing.ReadCatalog(st)
+	if err != nil {
+		return fmt.Errorf("Error while loading the listings catalog: %w", err)
+	}
+	records := filter.Select(catalog.Records())
+	if err := export.Sort(records, *sortFlag, cfg.POIs); err != nil {
+		return err
+	}
+
+	var out io.Writer = os.Stdout
+	if *outFlag != "" {
+		f, err := os.Create(*outFlag)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		out = f
+	}
+
+	w := bufio.NewWriter(out)
+	if err := export.Write(w, format, records, cfg.POIs); err != nil {
+		return err
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	if *outFlag != "" {
+		fmt.Fprintf(os.Stderr, "Exported %d listings to %s\n", len(records), *outFlag)
+	}
+	return nil
+}
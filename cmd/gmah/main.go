@@ -1,20 +1,38 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"flag"
 	"fmt"
-	"log"
+	"log/slog"
 	"net/http"
 	"os"
-	"strconv"
+	"os/signal"
+	"path/filepath"
+	"sort"
+	"syscall"
 	"time"
 
+	"github.com/BrunoTeixeira1996/gmah/internal/auth"
+	"github.com/BrunoTeixeira1996/gmah/internal/config"
 	"github.com/BrunoTeixeira1996/gmah/internal/email"
+	"github.com/BrunoTeixeira1996/gmah/internal/extraction"
+	"github.com/BrunoTeixeira1996/gmah/internal/geo"
 	"github.com/BrunoTeixeira1996/gmah/internal/handles"
+	"github.com/BrunoTeixeira1996/gmah/internal/health"
+	"github.com/BrunoTeixeira1996/gmah/internal/listing"
+	"github.com/BrunoTeixeira1996/gmah/internal/logging"
+	"github.com/BrunoTeixeira1996/gmah/internal/mapview"
+	"github.com/BrunoTeixeira1996/gmah/internal/metrics"
+	"github.com/BrunoTeixeira1996/gmah/internal/photos"
+	"github.com/BrunoTeixeira1996/gmah/internal/report"
 	"github.com/BrunoTeixeira1996/gmah/internal/requests"
+	"github.com/BrunoTeixeira1996/gmah/internal/runner"
+	"github.com/BrunoTeixeira1996/gmah/internal/search"
 	"github.com/BrunoTeixeira1996/gmah/internal/serve"
-
-	cp "github.com/otiai10/copy"
+	"github.com/BrunoTeixeira1996/gmah/internal/server"
+	"github.com/BrunoTeixeira1996/gmah/internal/store"
 )
 
 var supportedWebsites = []string{
@@ -25,54 +43,245 @@ var supportedWebsites = []string{
 	"CasaYes",
 }
 
-// Handles GET to check demand
-func demandHandle(args Args, newMessages int) http.HandlerFunc {
-	return func(w http.ResponseWriter, r *http.Request) {
-		if r.Method != "GET" {
-			http.Error(w, "NOT GET!", http.StatusBadRequest)
-			return
+// Outcome of the last IMAP login, reported by /readyz
+var imapLogin health.Attempt
+
+// How long a run that was told to stop has to return before the store is closed under it
+const runStopTimeout = 10 * time.Second
+
+// Evaluates the saved searches against the listings of this run and notifies their channels
+func notifySavedSearches(logger *slog.Logger, runID string, cfg config.Config, outbox *requests.Outbox, listings []listing.Listing, baseURL string) {
+	for _, m := range search.Evaluate(cfg.SavedSearches, listings) {
+		logger.Info("Saved search matched", "search", m.Search.Name, "listings", len(m.Listings))
+
+		// Instant searches get one alert per listing, digest ones get a single alert
+		batches := [][]listing.Listing{m.Listings}
+		if m.Search.Mode == search.Instant {
+			batches = nil
+			for _, l := range m.Listings {
+				batches = append(batches, []listing.Listing{l})
+			}
+		}
+
+		n := outbox.RunNotifier(runID, m.Search.Channels...)
+		for _, batch := range batches {
+			if err := requests.NotifySavedSearch(n, m.Search.Name, string(m.Search.Mode), batch, baseURL); err != nil {
+				logger.Error("Error while notifying saved search", "search", m.Search.Name, "err", err)
+			}
+		}
+	}
+}
+
+// Sends the weekly report on Sundays and the monthly report on the last day of the month
+// it is called after the daily run so the reports have the listings of the last day
+func notifyReports(now time.Time, cfg config.Config, outbox *requests.Outbox, catalog *listing.Catalog, baseURL string) {
+	var periods []report.Period
+	if now.Weekday() == time.Sunday {
+		periods = append(periods, report.Week(now))
+	}
+	if now.AddDate(0, 0, 1).Day() == 1 {
+		periods = append(periods, report.Month(now))
+	}
+
+	n := outbox.Notifier(cfg.Notify...)
+	for _, p := range periods {
+		r := report.Build(catalog.Records(), p, now)
+		slog.Info("Sending report", "kind", p.Kind, "period", p.ID, "new", r.New)
+		link := baseURL + "/reports/" + string(p.Kind) + "/" + p.ID
+		if err := requests.NotifyReport(n, string(p.Kind), p.ID, r.Summary(), link); err != nil {
+			slog.Error("Error while notifying report", "kind", p.Kind, "period", p.ID, "err", err)
+		}
+	}
+}
+
+// Function that returns a parse error for every email where the portal parser found nothing
+func parseErrors(emails []email.EmailTemplate) []requests.PayloadError {
+	var errs []requests.PayloadError
+	for _, e := range emails {
+		if e.Link == "" {
+			errs = append(errs, requests.PayloadError{Stage: requests.StageParse, Portal: e.From, Message: fmt.Sprintf("no link found in %q", e.Subject)})
+		}
+		if e.Snippet == "" {
+			errs = append(errs, requests.PayloadError{Stage: requests.StageParse, Portal: e.From, Message: fmt.Sprintf("no snippet found in %q", e.Subject)})
 		}
-		log.Println("Running demand handle")
-		run(args.Debug, args.Email, args.Password, args.Dump, &newMessages, args.Gokrazy)
 	}
+	return errs
 }
 
-func run(isDebug bool, emailFlag string, passwordFlag string, dump string, newMessages *int, isGokrazy bool) {
+// Function that returns the parser warnings of every email as "portal: subject: warning"
+func parseWarnings(emails []email.EmailTemplate) []string {
+	var warnings []string
+	for _, e := range emails {
+		for _, w := range e.Warnings {
+			warnings = append(warnings, fmt.Sprintf("%s: %s: %s", e.From, e.Subject, w))
+		}
+	}
+	return warnings
+}
+
+// Function that returns when the last daily run should have happened
+func lastCronSlot(now time.Time) time.Time {
+	slot := time.Date(now.Year(), now.Month(), now.Day(), 23, 59, 0, 0, now.Location())
+	if now.Before(slot) {
+		slot = slot.AddDate(0, 0, -1)
+	}
+	return slot
+}
+
+// Performs a lookup, it must only be called by the run coordinator
+// a run stopped through ctx leaves the messages unseen and its new listings pending, the next run notifies them
+func run(ctx context.Context, r runner.Run, args Args, outbox *requests.Outbox, catalog *listing.Catalog, tracker *extraction.Tracker, thumbnails *photos.Cache, gazetteer *geo.Gazetteer) (runner.Result, error) {
 	var (
-		emails []email.EmailTemplate
-		err    error
+		emails      []email.EmailTemplate
+		uids        []uint32
+		err         error
+		runErrs     []requests.PayloadError
+		newMessages int
 	)
 
-	log.Printf("Executing cronjob %s ...\n", time.Now().String())
+	isDebug := args.Debug
+	logger := slog.With("run_id", r.ID)
+	notifier := outbox.RunNotifier(r.ID, args.Config.Notify...)
+
+	fetchStart := time.Now()
+	if emails, uids, err = email.ReadEmails(logger, isDebug, args.Email, args.Password, &newMessages); err != nil {
+		logger.Error("Error while reading the emails", "err", err)
+		runErrs = append(runErrs, requests.PayloadError{Stage: requests.StageIMAP, Message: err.Error()})
+		metrics.IMAPFetchDuration.Observe(time.Since(fetchStart).Seconds(), "failed")
+	} else {
+		metrics.IMAPFetchDuration.Observe(time.Since(fetchStart).Seconds(), "done")
+	}
+	// A server that can't be reached says nothing about the credentials
+	if !errors.Is(err, email.ErrDial) {
+		if errors.Is(err, email.ErrLogin) {
+			imapLogin.Set(err)
+		} else {
+			imapLogin.Set(nil)
+		}
+	}
+	metrics.Messages.Add(float64(newMessages))
+	logger.Info("Read emails", "messages", newMessages, "emails", len(emails), "duration", time.Since(fetchStart))
+
+	// Photos that can't be downloaded leave the listing without thumbnail
+	var photoURLs []string
+	for _, e := range emails {
+		photoURLs = append(photoURLs, e.Photo)
+	}
+	thumbs := thumbnails.GetAll(ctx, logger, photoURLs)
+	for i := range emails {
+		emails[i].Thumbnail = thumbs[emails[i].Photo]
+	}
+
+	// The medians come from the listings known before this run
+	prices := args.Config.Market.Build(catalog.Records(), time.Now())
+
+	// Every email with a link or a snippet is a listing, the place comes from the gazetteer and the photo hash
+	// finds the same house on other portals and when it is announced again
+	var listings []listing.Listing
+	for i := range emails {
+		if emails[i].Link == "" && emails[i].Snippet == "" {
+			continue
+		}
+		l := listing.FromEmail(emails[i])
+		l.Locate(gazetteer)
+		args.Config.Scoring.Apply(&l)
+		prices.Apply(&l)
+		if l.Thumbnail != "" {
+			if h, err := thumbnails.Hash(l.Photo); err != nil {
+				logger.Warn("Error while hashing the photo", "url", l.Photo, "err", err)
+			} else {
+				l.PhotoHash = h.String()
+			}
+		}
+		listings = append(listings, l)
 
-	if emails, err = email.ReadEmails(isDebug, emailFlag, passwordFlag, newMessages); err != nil {
-		log.Println("Error while performing the read emails inside the cronjob: ", err.Error())
+		// The daily page shows how the price compares to the area
+		emails[i].Score, emails[i].ScoreDetail = l.Score, l.ScoreExplanation()
+		if l.Market != nil {
+			emails[i].Market = l.Market.String()
+		}
+	}
+	// The best listings go first, the same order on the daily page and in the notifications
+	if args.Config.Scoring.Enabled() {
+		sort.SliceStable(emails, func(i, j int) bool { return emails[i].Score > emails[j].Score })
+		sort.SliceStable(listings, func(i, j int) bool { return listings[i].Score > listings[j].Score })
 	}
 
-	log.Println("ReadEmails output err:", err)
+	parseErrs := parseErrors(emails)
+	runErrs = append(runErrs, parseErrs...)
 
-	if err = serve.CreateHTMLFile(emails, dump, isGokrazy); err != nil {
-		log.Println("Error while creating html file: ", err.Error())
+	if err = serve.CreateHTMLFile(logger, emails, args.Dump); err != nil {
+		logger.Error("Error while creating html file", "err", err)
+		runErrs = append(runErrs, requests.PayloadError{Stage: requests.StageRender, Message: err.Error()})
 	}
 
-	log.Println("CreateHTMLFile output err:", err)
+	// Keeps the per portal success rates and the messages the parsers failed on
+	breakages, samples, err := tracker.Record(emails, time.Now())
+	if err != nil {
+		logger.Error("Error while recording the extraction stats", "err", err)
+	}
 
-	newMessagesStr := strconv.Itoa(*newMessages)
-	log.Printf("Got %s new messages\n", newMessagesStr)
+	if err := ctx.Err(); err != nil {
+		return runner.Result{Errors: runErrs}, fmt.Errorf("run stopped before saving the listings: %w", err)
+	}
 
-	// Notifies telegram
+	fresh, duplicates, err := catalog.Observe(r.ID, listings, time.Now())
+	if err != nil {
+		logger.Error("Error while saving the listings", "err", err)
+	}
+
+	summary := requests.RunSummary{
+		Messages:   newMessages,
+		Listings:   len(listings),
+		New:        len(fresh),
+		Duplicates: len(duplicates),
+		Portals:    map[string]int{},
+		// Parse errors alone don't fail the run, the other listings are still there
+		Failed: len(runErrs) > len(parseErrs),
+	}
+	for _, l := range listings {
+		summary.Portals[l.Portal]++
+		metrics.Listings.Inc(l.Portal)
+	}
+	logger.Info("Extracted listings", "listings", len(listings), "new", len(fresh), "duplicates", len(duplicates))
+
+	if err := ctx.Err(); err != nil {
+		return runner.Result{Summary: summary, Errors: runErrs}, fmt.Errorf("run stopped before notifying: %w", err)
+	}
+
+	// Notifies the configured channels, debug runs leave the listings pending for the next real run
 	if !isDebug {
-		if err := requests.NotifyTelegramBot(newMessagesStr, isGokrazy, nil); err != nil {
-			log.Println("Error while notifying telegram bot: " + err.Error())
+		if err := requests.NotifyNewDay(notifier, summary, listings, args.BaseURL, runErrs); err != nil {
+			logger.Error("Error while notifying", "err", err)
 		}
-	}
 
-	// Clean newMessages pointer
-	*newMessages = 0
+		// Saved searches only alert about listings that were never seen before
+		notifySavedSearches(logger, r.ID, args.Config, outbox, fresh, args.BaseURL)
 
-	log.Println("NotifyTelegramBot output err:", err)
+		for _, b := range breakages {
+			logger.Warn("Parser looks broken", "portal", b.Portal, "missing", b.Missing)
+			if err := requests.NotifyParserBreakage(notifier, b.Portal, b.Messages, b.Missing, b.Rate, r.ID, args.BaseURL); err != nil {
+				logger.Error("Error while notifying parser breakage", "err", err)
+			}
+		}
+
+		// The notifications are in the outbox, running again from here would repeat them
+		if err := catalog.Notified(r.ID); err != nil {
+			logger.Error("Error while marking the listings as notified", "err", err)
+		}
+	}
 
-	log.Printf("Finished cronjob %s\n", time.Now().String())
+	// Only now the messages are done with, a run stopped before this reads them again
+	if err := email.MarkSeen(logger, isDebug, args.Email, args.Password, uids); err != nil {
+		logger.Error("Error while marking the messages as seen", "err", err)
+		runErrs = append(runErrs, requests.PayloadError{Stage: requests.StageIMAP, Message: err.Error()})
+	}
+
+	result := runner.Result{Summary: summary, Errors: runErrs, Warnings: parseWarnings(emails), Samples: samples}
+	if summary.Failed {
+		return result, fmt.Errorf("run failed with %d errors", len(runErrs))
+	}
+	return result, nil
 }
 
 type Args struct {
@@ -80,7 +289,19 @@ type Args struct {
 	Password string
 	Gokrazy  bool
 	Dump     string
+	Data     string
 	Debug    bool
+	Config   config.Config
+	// BaseURL is the address of the server in the links of the notifications
+	BaseURL string
+}
+
+// Function that returns where the data directory is when -data is not set
+func defaultDataDir(isGokrazy bool) string {
+	if isGokrazy {
+		return "/perm/home/gmah/data"
+	}
+	return "data"
 }
 
 func gatherFlags() (Args, error) {
@@ -88,29 +309,43 @@ func gatherFlags() (Args, error) {
 	var passwordFlag = flag.String("password", "", "-password='yourpassword'")
 	var gokrazyFlag = flag.Bool("gokrazy", false, "use this if you are using gokrazy")
 	var dumpFlag = flag.String("dump", "", "-dump='/path/html/'")
+	var dataFlag = flag.String("data", "", "-data='/path/data/' (defaults to /perm/home/gmah/data on gokrazy and ./data otherwise)")
 	var debugFlag = flag.Bool("debug", false, "use this to ignore cronjob")
+	var configFlag = flag.String("config", "", "-config='/path/config.json'")
 	flag.Parse()
 
 	if *emailFlag == "" || *passwordFlag == "" {
 		return Args{}, fmt.Errorf("Please provide the email and password")
 	}
 
+	cfg, err := config.Load(*configFlag)
+	if err != nil {
+		return Args{}, err
+	}
+
 	args := Args{
 		Email:    *emailFlag,
 		Password: *passwordFlag,
 		Gokrazy:  *gokrazyFlag,
 		Dump:     *dumpFlag,
+		Data:     *dataFlag,
 		Debug:    *debugFlag,
+		Config:   cfg,
+		BaseURL:  cfg.Server.BaseURL(server.LocalHost()),
+	}
+
+	if args.Data == "" {
+		args.Data = defaultDataDir(*gokrazyFlag)
 	}
 
 	if *gokrazyFlag {
-		log.Println("OK lets do this on gokrazy then ...")
-		// copy required folders to /pem
-		errTemplate := cp.Copy("/etc/gmah/serve_template.html", "/perm/home/gmah/serve_template.html")
-		errHtml := cp.Copy("/etc/gmah/html", "/perm/home/gmah/html")
+		slog.Info("OK lets do this on gokrazy then ...")
+	}
 
-		if errTemplate != nil || errHtml != nil {
-			return Args{}, fmt.Errorf("Error while copying files and folders to gokrazy perm (template:%v),(html:%v)", errTemplate, errHtml)
+	// The templates are embedded, only the pages need a place in /perm
+	if args.Dump != "" {
+		if err := os.MkdirAll(args.Dump, 0755); err != nil {
+			return Args{}, fmt.Errorf("Error while creating the dump directory %s: %w", args.Dump, err)
 		}
 	}
 
@@ -122,67 +357,290 @@ func main() {
 		args Args
 		err  error
 	)
+	// Subcommands that do not start the server
+	if len(os.Args) > 1 {
+		var command func() error
+		switch os.Args[1] {
+		case "export":
+			command = func() error { return exportCommand(os.Args[2:]) }
+		case "passwd":
+			command = passwdCommand
+		}
+		if command != nil {
+			if err := command(); err != nil {
+				slog.Error(err.Error())
+				os.Exit(1)
+			}
+			return
+		}
+	}
+
 	if args, err = gatherFlags(); err != nil {
-		log.Println(err)
+		slog.Error(err.Error())
+		os.Exit(1)
+	}
+
+	// Logs go to stderr and to the buffer shown at /debug/logs
+	logRing := logging.NewRing(args.Config.LogBuffer)
+	level, _ := logging.ParseLevel(args.Config.LogLevel)
+	logger, err := logging.New(os.Stderr, level, args.Config.LogFormat, logRing)
+	if err != nil {
+		slog.Error("Error while setting up the logs", "err", err)
+		os.Exit(1)
+	}
+	slog.SetDefault(logger)
+
+	// Broken custom templates are found now instead of at 23:59
+	templates, err := serve.Load(args.Config.TemplateDir)
+	if err != nil {
+		slog.Error("Error while loading the templates", "err", err)
+		os.Exit(1)
+	}
+	serve.Use(templates)
+	slog.Info("Loaded templates", "source", templates.Source)
+
+	slog.Info("Links in the notifications point to the server", "base_url", args.BaseURL)
+
+	// SIGTERM from gokrazy updates and Ctrl+C stop gmah cleanly
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGTERM, os.Interrupt)
+	defer stop()
+
+	st, err := store.Open(args.Data)
+	if err != nil {
+		slog.Error("Error while opening the data directory", "err", err)
+		os.Exit(1)
+	}
+
+	outbox, err := requests.NewOutbox(st, args.Config.Channels())
+	if err != nil {
+		slog.Error("Error while loading the outbox", "err", err)
 		os.Exit(1)
 	}
+	stopOutbox := make(chan struct{})
+	go outbox.Run(30*time.Second, stopOutbox)
 
-	var newMessages int
+	catalog, err := listing.OpenCatalog(st)
+	if err != nil {
+		slog.Error("Error while loading the listings", "err", err)
+		os.Exit(1)
+	}
+
+	gazetteer, err := geo.Load(st)
+	if err != nil {
+		slog.Error("Error while loading the gazetteer", "err", err)
+		os.Exit(1)
+	}
+	// Until the gazetteer is generated from the CAOP, see the README, most districts have no municipalities
+	if unmapped := gazetteer.Unmapped(); len(unmapped) > 0 {
+		slog.Warn("The gazetteer only finds the district of the listings in some districts", "districts", unmapped)
+	}
+	market := func(records []listing.Record) func(*listing.Listing) {
+		return args.Config.Market.Build(records, time.Now()).Apply
+	}
+	if err := catalog.Refresh(gazetteer, args.Config.Scoring.Apply, market); err != nil {
+		slog.Error("Error while locating, scoring and comparing the listings", "err", err)
+	}
+
+	// The outlines of the config replace the bundled ones, the map works without them and just shows the names
+	outlines, err := mapview.LoadOutlines(args.Config.Map.Outlines)
+	if err != nil {
+		slog.Error("Error while loading the map outlines", "err", err)
+	}
+
+	tracker, err := extraction.Open(st)
+	if err != nil {
+		slog.Error("Error while loading the extraction stats", "err", err)
+		os.Exit(1)
+	}
+
+	thumbnails := photos.NewCache(st, &http.Client{Timeout: photos.Timeout}, email.PhotoHosts())
+
+	// Every run goes through the coordinator so cron and /demand never overlap
+	// runCtx is only cancelled when a run takes longer than the shutdown timeout
+	runCtx, cancelRun := context.WithCancel(context.Background())
+	defer cancelRun()
+	retention := time.Duration(args.Config.RunRetentionDays) * 24 * time.Hour
+	coordinator, err := runner.New(func(r runner.Run) (runner.Result, error) {
+		return run(runCtx, r, args, outbox, catalog, tracker, thumbnails, gazetteer)
+	}, st, retention)
+	if err != nil {
+		slog.Error("Error while loading the run history", "err", err)
+		os.Exit(1)
+	}
+	stopLoop, loopDone := make(chan struct{}), make(chan struct{})
+	go func() {
+		coordinator.Loop(stopLoop)
+		close(loopDone)
+	}()
+
+	// Catches up on the daily run if gmah was down when it should have happened
+	if last, ok := coordinator.Last(runner.TriggerCron, runner.TriggerStartup); ok && !args.Debug {
+		if last.QueuedAt.Before(lastCronSlot(time.Now())) {
+			slog.Info("Catching up on the daily run", "last_run_id", last.ID, "slot", lastCronSlot(time.Now()))
+			coordinator.Enqueue(runner.TriggerStartup)
+		}
+	}
+
+	ready := &health.Checker{}
+	ready.Add("store", func() (string, error) {
+		return st.Dir(), st.Check()
+	})
+	ready.Add("template", func() (string, error) {
+		templates := serve.Current()
+		return templates.Source, templates.Check()
+	})
+	ready.Add("runs", func() (string, error) {
+		failures := coordinator.ConsecutiveFailures()
+		if failures >= args.Config.ReadyMaxFailures {
+			return "", fmt.Errorf("last %d runs failed", failures)
+		}
+		return fmt.Sprintf("%d failed in a row", failures), nil
+	})
+	ready.Add("imap", imapLogin.Check)
+
+	// Pages and reads need any credential, runs, lookups and edits need a token or a login
+	authn := auth.New(args.Config.Auth)
+	authn.Secure = args.Config.Server.Secure()
+	if !authn.Enabled() {
+		slog.Warn("Authentication is disabled, anyone in the network can use the server")
+	}
+	read := func(h http.Handler) http.HandlerFunc { return authn.Require(auth.Read, h) }
+	write := func(h http.Handler) http.HandlerFunc { return authn.Require(auth.Write, h) }
 
 	mux := http.NewServeMux()
 	fs := http.FileServer(http.Dir(args.Dump))
-	mux.Handle("/dump/", http.StripPrefix("/dump/", fs))
-	mux.HandleFunc("/", handles.IndexHandle)
-	mux.HandleFunc("/demand", demandHandle(args, newMessages))
-	mux.HandleFunc("/lpspecific", handles.LookUpSpecificHandle)
-	go http.ListenAndServe(":9090", mux)
+	mux.Handle("/dump/", read(http.StripPrefix("/dump/", fs)))
+	mux.HandleFunc("/", read(http.HandlerFunc(handles.IndexHandle)))
+	mux.Handle("/static/", http.StripPrefix("/static/", serve.Static()))
+	mux.HandleFunc("/login", handles.LoginHandle(authn))
+	mux.HandleFunc("/logout", handles.LogoutHandle(authn))
+	mux.HandleFunc("/demand", write(handles.DemandHandle(coordinator, authn)))
+	mux.HandleFunc("/runs", read(handles.RunsPageHandle(coordinator)))
+	mux.HandleFunc("/runs/", read(handles.RunsPageHandle(coordinator)))
+	mux.HandleFunc("/api/v1/runs", read(handles.RunHandle(coordinator)))
+	mux.HandleFunc("/api/v1/runs/", read(handles.RunHandle(coordinator)))
+	mux.HandleFunc("/lpspecific", write(handles.LookUpSpecificHandle(outbox.Notifier(args.Config.Notify...), args.Dump, args.BaseURL)))
+	mux.HandleFunc("/days", read(handles.DaysPageHandle(args.Dump)))
+	mux.HandleFunc("/api/v1/outbox", read(handles.OutboxHandle(outbox)))
+	mux.HandleFunc("/api/v1/outbox/", write(handles.OutboxDeleteHandle(outbox)))
+	mux.HandleFunc("/healthz", handles.HealthzHandle)
+	mux.HandleFunc("/readyz", handles.ReadyzHandle(ready))
+	mux.HandleFunc("/metrics", metrics.Default.Handler())
+	mux.HandleFunc("/debug/logs", write(handles.DebugLogsHandle(logRing)))
+	mux.HandleFunc("/parsers", read(handles.ParsersPageHandle(tracker)))
+	mux.HandleFunc("/api/v1/parsers", read(handles.ParsersHandle(tracker)))
+	mux.HandleFunc("/reports", read(handles.ReportsPageHandle(catalog)))
+	mux.HandleFunc("/reports/", read(handles.ReportsPageHandle(catalog)))
+	mux.HandleFunc("/api/v1/reports/", read(handles.ReportHandle(catalog)))
+	mux.HandleFunc("/api/v1/shortlist", write(handles.ShortlistHandle(catalog)))
+	mux.HandleFunc("/map", read(handles.MapPageHandle(catalog, args.Config.SavedSearches, args.Config.POIs, gazetteer, args.Config.Map, outlines)))
+	mux.HandleFunc("/api/v1/export", read(handles.ExportHandle(catalog, args.Config.SavedSearches, args.Config.POIs)))
+	samples := http.FileServer(http.Dir(filepath.Join(st.Dir(), extraction.SamplesDir)))
+	mux.Handle("/samples/", read(http.StripPrefix("/samples/", samples)))
+	thumbnailFiles := http.FileServer(http.Dir(filepath.Join(st.Dir(), photos.Dir)))
+	mux.Handle("/thumbnails/", read(http.StripPrefix("/thumbnails/", thumbnailFiles)))
+
+	// A port in use stops gmah now instead of leaving it running without a server
+	srv, err := server.Listen(args.Config.Server, mux, st.Dir())
+	if err != nil {
+		slog.Error("Error while starting the server", "listen", args.Config.Server.Listen, "err", err)
+		os.Exit(1)
+	}
+	serverErr := make(chan error, 2)
+	srv.Serve(serverErr)
+	go func() {
+		err := <-serverErr
+		slog.Error("The server stopped", "err", err)
+		os.Exit(1)
+	}()
 
-	log.Println("Listening on port 9090")
-	log.Println("Supported Websites:", supportedWebsites)
+	slog.Info("Listening", "addrs", srv.Addrs(), "tls", args.Config.Server.TLS, "websites", supportedWebsites)
 
-	// If its debug mode then run and ignore cronjob
+	// If its debug mode then run once and stop
 	if args.Debug {
-		run(args.Debug, args.Email, args.Password, args.Dump, &newMessages, args.Gokrazy)
-		return
+		r, _ := coordinator.Enqueue(runner.TriggerDebug)
+		go func() {
+			coordinator.Wait(r.ID)
+			stop()
+		}()
+	} else {
+		go cron(ctx, coordinator, args, outbox, catalog)
 	}
 
-	// Starts cronjon
-	runCh := make(chan struct{})
-	go func() {
-		// Run forever, trigger a run at 23:59 every day.
+	<-ctx.Done()
+	slog.Info("Stopping", "timeout", args.Config.ShutdownTimeout())
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), args.Config.ShutdownTimeout())
+	defer cancel()
+
+	// The run in progress finishes, the queued ones are marked as interrupted on the next start
+	close(stopLoop)
+	select {
+	case <-loopDone:
+	case <-shutdownCtx.Done():
+		slog.Warn("Stopping in the middle of a run, its messages are read again on the next start")
+		// The run stops at its next step, the store is only closed after it
+		cancelRun()
+		select {
+		case <-loopDone:
+		case <-time.After(runStopTimeout):
+			slog.Warn("The run did not stop, what it writes from now on is dropped", "timeout", runStopTimeout)
+		}
+	}
+
+	close(stopOutbox)
+	if left := outbox.Flush(shutdownCtx); left > 0 {
+		slog.Warn("Notifications left in the outbox, they are sent on the next start", "pending", left)
+	}
+
+	if err := srv.Shutdown(shutdownCtx); err != nil {
+		slog.Warn("Error while stopping the server", "err", err)
+	}
+
+	st.Close()
+	slog.Info("Stopped")
+}
+
+// Runs the daily run at 23:59 every day and the reports after it until ctx is done
+func cron(ctx context.Context, coordinator *runner.Coordinator, args Args, outbox *requests.Outbox, catalog *listing.Catalog) {
+	for {
+		now := time.Now()
+		runToday := now.Hour() < 23 || (now.Hour() == 23 && now.Minute() < 59)
+		today := now.Day()
+		slog.Debug("Scheduling the daily run", "now", now, "run_today", runToday)
+
 		for {
-			now := time.Now()
-			runToday := now.Hour() < 23 || (now.Hour() == 23 && now.Minute() < 59)
-			today := now.Day()
-			log.Printf("now = %v, runToday = %v", now, runToday)
-
-			for {
-				if time.Now().Day() != today {
-					log.Println("Day changed, re-evaluate whether to run today")
-					break
-				}
+			if time.Now().Day() != today {
+				slog.Debug("Day changed, re-evaluate whether to run today")
+				break
+			}
 
-				// Calculate the next scheduled time (23:59)
-				nextRun := time.Date(now.Year(), now.Month(), now.Day(), 23, 59, 0, 0, now.Location())
+			// Calculate the next scheduled time (23:59)
+			nextRun := time.Date(now.Year(), now.Month(), now.Day(), 23, 59, 0, 0, now.Location())
 
-				// If we are already past 23:59 today, schedule for tomorrow
-				if now.After(nextRun) {
-					nextRun = nextRun.Add(24 * time.Hour)
-				}
+			// If we are already past 23:59 today, schedule for tomorrow
+			if now.After(nextRun) {
+				nextRun = nextRun.Add(24 * time.Hour)
+			}
 
-				// Sleep until the next run time
-				time.Sleep(time.Until(nextRun))
+			// Sleep until the next run time
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(time.Until(nextRun)):
+			}
 
-				// Check if it's time to run the job
-				if time.Now().Hour() == 23 && time.Now().Minute() == 59 && runToday {
-					runToday = false
-					runCh <- struct{}{}
+			// Check if it's time to run the job
+			if time.Now().Hour() == 23 && time.Now().Minute() == 59 && runToday {
+				runToday = false
+				r, deduped := coordinator.Enqueue(runner.TriggerCron)
+				if deduped {
+					slog.Info("Cron joined the already queued run", "run_id", r.ID)
+				}
+				coordinator.Wait(r.ID)
+				if ctx.Err() == nil {
+					notifyReports(time.Now(), args.Config, outbox, catalog, args.BaseURL)
 				}
 			}
 		}
-	}()
-
-	for range runCh {
-		run(args.Debug, args.Email, args.Password, args.Dump, &newMessages, args.Gokrazy)
 	}
 }
@@ -0,0 +1,257 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Level is what a caller is allowed to do
+type Level int
+
+const (
+	// Read can see the pages and the read only API
+	Read Level = iota + 1
+	// Write can also ask for runs and lookups and change the listings
+	Write
+)
+
+// Cookies set by gmah
+const (
+	SessionCookie = "gmah_session"
+	ShareCookie   = "gmah_share"
+)
+
+// Token is a static bearer token for the API and the bot, it can write
+type Token struct {
+	Name  string `json:"name"`
+	Token string `json:"token"`
+}
+
+// User logs in to the web UI, the password is a bcrypt hash (gmah passwd prints one)
+type User struct {
+	Username     string `json:"username"`
+	PasswordHash string `json:"password_hash"`
+}
+
+// Config is the auth section of the config, without tokens and users everything is open
+type Config struct {
+	Tokens []Token `json:"tokens"`
+	Users  []User  `json:"users"`
+	// ShareToken gives read only access with ?share=<token>
+	ShareToken string `json:"share_token"`
+	// SessionHours is how long a login lasts, defaults to 168 (a week)
+	SessionHours int `json:"session_hours"`
+}
+
+// MinTokenLength keeps the tokens from being guessed
+const MinTokenLength = 16
+
+// Enabled reports whether the server asks for credentials
+func (c Config) Enabled() bool {
+	return len(c.Tokens) > 0 || len(c.Users) > 0 || c.ShareToken != ""
+}
+
+// Validate checks the tokens and the password hashes
+func (c Config) Validate() error {
+	names := map[string]bool{}
+	for _, t := range c.Tokens {
+		if t.Name == "" {
+			return fmt.Errorf("auth token without name")
+		}
+		if names[t.Name] {
+			return fmt.Errorf("auth token %q is defined more than once", t.Name)
+		}
+		names[t.Name] = true
+		if len(t.Token) < MinTokenLength {
+			return fmt.Errorf("auth token %q must have at least %d characters", t.Name, MinTokenLength)
+		}
+	}
+
+	users := map[string]bool{}
+	for _, u := range c.Users {
+		if u.Username == "" {
+			return fmt.Errorf("auth user without username")
+		}
+		if users[u.Username] {
+			return fmt.Errorf("auth user %q is defined more than once", u.Username)
+		}
+		users[u.Username] = true
+		if _, err := bcrypt.Cost([]byte(u.PasswordHash)); err != nil {
+			return fmt.Errorf("auth user %q has an invalid password_hash: %w", u.Username, err)
+		}
+	}
+
+	if c.ShareToken != "" && len(c.ShareToken) < MinTokenLength {
+		return fmt.Errorf("share_token must have at least %d characters", MinTokenLength)
+	}
+	if c.SessionHours < 0 {
+		return fmt.Errorf("session_hours can not be negative")
+	}
+	return nil
+}
+
+// Caller is who made a request
+type Caller struct {
+	Name  string
+	Level Level
+}
+
+// ErrInvalidCredentials is returned when the username or the password are wrong
+var ErrInvalidCredentials = errors.New("invalid username or password")
+
+type session struct {
+	username string
+	expires  time.Time
+}
+
+// Auth checks the credentials of the requests and keeps the sessions of the web UI
+// sessions are kept in memory, a restart logs everyone out
+type Auth struct {
+	cfg     Config
+	ttl     time.Duration
+	dummy   []byte
+	mu      sync.Mutex
+	session map[string]session
+	// Secure marks the cookies as HTTPS only
+	Secure bool
+}
+
+// New returns the auth of the config
+func New(cfg Config) *Auth {
+	hours := cfg.SessionHours
+	if hours == 0 {
+		hours = 7 * 24
+	}
+	// Unknown users are compared against this hash so they take as long as known ones
+	dummy, _ := bcrypt.GenerateFromPassword([]byte("gmah"), bcrypt.DefaultCost)
+	return &Auth{cfg: cfg, ttl: time.Duration(hours) * time.Hour, dummy: dummy, session: map[string]session{}}
+}
+
+// Enabled reports whether the server asks for credentials
+func (a *Auth) Enabled() bool {
+	return a.cfg.Enabled()
+}
+
+// Function that compares a secret in constant time, an empty want never matches
+func equal(got, want string) bool {
+	return want != "" && subtle.ConstantTimeCompare([]byte(got), []byte(want)) == 1
+}
+
+// Login checks the password of the user and returns a new session id
+func (a *Auth) Login(username, password string, now time.Time) (string, error) {
+	hash := a.dummy
+	found := false
+	for _, u := range a.cfg.Users {
+		if u.Username == username {
+			hash = []byte(u.PasswordHash)
+			found = true
+		}
+	}
+	if err := bcrypt.CompareHashAndPassword(hash, []byte(password)); err != nil || !found {
+		return "", ErrInvalidCredentials
+	}
+
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	id := hex.EncodeToString(b)
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	for k, s := range a.session {
+		if now.After(s.expires) {
+			delete(a.session, k)
+		}
+	}
+	a.session[id] = session{username: username, expires: now.Add(a.ttl)}
+	return id, nil
+}
+
+// Logout forgets the session
+func (a *Auth) Logout(id string) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	delete(a.session, id)
+}
+
+// SessionCookie returns the cookie that keeps a session, an empty id removes it
+func (a *Auth) SessionCookie(id string) *http.Cookie {
+	c := &http.Cookie{Name: SessionCookie, Value: id, Path: "/", HttpOnly: true, Secure: a.Secure, SameSite: http.SameSiteLaxMode, MaxAge: int(a.ttl.Seconds())}
+	if id == "" {
+		c.MaxAge = -1
+	}
+	return c
+}
+
+// Caller returns who made the request: a bearer token, a logged in user or the share token
+func (a *Auth) Caller(r *http.Request, now time.Time) (Caller, bool) {
+	if bearer, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer "); ok {
+		for _, t := range a.cfg.Tokens {
+			if equal(bearer, t.Token) {
+				return Caller{Name: t.Name, Level: Write}, true
+			}
+		}
+	}
+
+	if c, err := r.Cookie(SessionCookie); err == nil {
+		a.mu.Lock()
+		s, ok := a.session[c.Value]
+		a.mu.Unlock()
+		if ok && now.Before(s.expires) {
+			return Caller{Name: s.username, Level: Write}, true
+		}
+	}
+
+	share := r.URL.Query().Get("share")
+	if c, err := r.Cookie(ShareCookie); err == nil && share == "" {
+		share = c.Value
+	}
+	if equal(share, a.cfg.ShareToken) {
+		return Caller{Name: "share", Level: Read}, true
+	}
+
+	return Caller{}, false
+}
+
+// Require only lets through the callers with at least level
+// browsers without credentials are sent to the login page, the API gets a 401
+func (a *Auth) Require(level Level, next http.Handler) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !a.Enabled() {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		caller, ok := a.Caller(r, time.Now())
+		if !ok {
+			if r.Method == "GET" && strings.Contains(r.Header.Get("Accept"), "text/html") && len(a.cfg.Users) > 0 {
+				http.Redirect(w, r, "/login?next="+url.QueryEscape(r.URL.RequestURI()), http.StatusSeeOther)
+				return
+			}
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		if caller.Level < level {
+			http.Error(w, "forbidden", http.StatusForbidden)
+			return
+		}
+
+		// The share link keeps working while browsing the other pages
+		if share := r.URL.Query().Get("share"); share != "" && caller.Level == Read {
+			http.SetCookie(w, &http.Cookie{Name: ShareCookie, Value: share, Path: "/", HttpOnly: true, Secure: a.Secure, SameSite: http.SameSiteLaxMode})
+		}
+		next.ServeHTTP(w, r)
+	}
+}
@@ -0,0 +1,96 @@
+package auth
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Test the Require function
+func TestRequire(t *testing.T) {
+	hash, err := bcrypt.GenerateFromPassword([]byte("hunter22"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg := Config{
+		Tokens:     []Token{{Name: "bot", Token: "bot-token-0123456789"}},
+		Users:      []User{{Username: "admin", PasswordHash: string(hash)}},
+		ShareToken: "share-token-0123456789",
+	}
+	if err := cfg.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	a := New(cfg)
+
+	if _, err := a.Login("admin", "wrong", time.Now()); err != ErrInvalidCredentials {
+		t.Fatalf("expected invalid credentials, got %v", err)
+	}
+	if _, err := a.Login("nobody", "hunter22", time.Now()); err != ErrInvalidCredentials {
+		t.Fatalf("expected invalid credentials, got %v", err)
+	}
+	session, err := a.Login("admin", "hunter22", time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+
+	tests := []struct {
+		name       string
+		level      Level
+		url        string
+		header     string
+		cookie     *http.Cookie
+		html       bool
+		wantStatus int
+	}{
+		{"no credentials", Read, "/runs", "", nil, false, http.StatusUnauthorized},
+		{"browser without credentials", Read, "/runs", "", nil, true, http.StatusSeeOther},
+		{"bearer token", Write, "/demand", "Bearer bot-token-0123456789", nil, false, http.StatusOK},
+		{"wrong bearer token", Read, "/runs", "Bearer nope", nil, false, http.StatusUnauthorized},
+		{"session", Write, "/demand", "", &http.Cookie{Name: SessionCookie, Value: session}, false, http.StatusOK},
+		{"unknown session", Read, "/runs", "", &http.Cookie{Name: SessionCookie, Value: "nope"}, false, http.StatusUnauthorized},
+		{"share link reads", Read, "/runs?share=share-token-0123456789", "", nil, false, http.StatusOK},
+		{"share cookie reads", Read, "/runs", "", &http.Cookie{Name: ShareCookie, Value: "share-token-0123456789"}, false, http.StatusOK},
+		{"share link can not write", Write, "/demand?share=share-token-0123456789", "", nil, false, http.StatusForbidden},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest("GET", tt.url, nil)
+			if tt.header != "" {
+				r.Header.Set("Authorization", tt.header)
+			}
+			if tt.cookie != nil {
+				r.AddCookie(tt.cookie)
+			}
+			if tt.html {
+				r.Header.Set("Accept", "text/html")
+			}
+			w := httptest.NewRecorder()
+			a.Require(tt.level, ok)(w, r)
+			if w.Code != tt.wantStatus {
+				t.Errorf("expected status %d, got %d", tt.wantStatus, w.Code)
+			}
+		})
+	}
+
+	a.Logout(session)
+	r := httptest.NewRequest("GET", "/runs", nil)
+	r.AddCookie(&http.Cookie{Name: SessionCookie, Value: session})
+	if _, ok := a.Caller(r, time.Now()); ok {
+		t.Error("expected the session to end after logging out")
+	}
+}
+
+// Test the Require function without any credential configured
+func TestRequireDisabled(t *testing.T) {
+	w := httptest.NewRecorder()
+	New(Config{}).Require(Write, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))(w, httptest.NewRequest("POST", "/demand", nil))
+	if w.Code != http.StatusOK {
+		t.Errorf("expected status 200, got %d", w.Code)
+	}
+}
@@ -0,0 +1,191 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/BrunoTeixeira1996/gmah/internal/auth"
+	"github.com/BrunoTeixeira1996/gmah/internal/geo"
+	"github.com/BrunoTeixeira1996/gmah/internal/logging"
+	"github.com/BrunoTeixeira1996/gmah/internal/mapview"
+	"github.com/BrunoTeixeira1996/gmah/internal/market"
+	"github.com/BrunoTeixeira1996/gmah/internal/requests"
+	"github.com/BrunoTeixeira1996/gmah/internal/scoring"
+	"github.com/BrunoTeixeira1996/gmah/internal/search"
+	"github.com/BrunoTeixeira1996/gmah/internal/server"
+)
+
+// DefaultChannel is the notifier used when nothing else is configured
+const DefaultChannel = "telegram"
+
+// Config is the optional JSON file passed with -config
+type Config struct {
+	Notifiers []requests.NotifierConfig `json:"notifiers"`
+	// Notify are the notifiers that get the daily and lookup messages
+	Notify        []string             `json:"notify"`
+	SavedSearches []search.SavedSearch `json:"saved_searches"`
+	// POIs are the places the distance of every listing is measured to, like work or school
+	POIs []geo.POI `json:"points_of_interest"`
+	// Scoring ranks the listings, without it they all have the same weight
+	Scoring scoring.Config `json:"scoring"`
+	// Market has the window and the sample size of the price per m² medians every listing is compared to
+	Market market.Config `json:"market"`
+	// RunRetentionDays is how long the run history is kept, defaults to 90
+	RunRetentionDays int `json:"run_retention_days"`
+	// ReadyMaxFailures is how many runs in a row can fail before /readyz reports not ready, defaults to 3
+	ReadyMaxFailures int `json:"ready_max_failures"`
+	// LogLevel is debug, info, warn or error, defaults to info
+	LogLevel string `json:"log_level"`
+	// LogFormat is text or json, defaults to text
+	LogFormat string `json:"log_format"`
+	// LogBuffer is how many log lines are kept for /debug/logs, defaults to 1000
+	LogBuffer int `json:"log_buffer"`
+	// TemplateDir overrides the embedded templates/*.html and static/* with the files it has
+	TemplateDir string `json:"template_dir"`
+	// Auth protects the web UI and the API, without it everything is open
+	Auth auth.Config `json:"auth"`
+	// Server has the listen addresses and TLS, it listens on :9090 in plain HTTP by default
+	Server server.Config `json:"server"`
+	// Map has the tile server and the outlines of the /map page, without them it is plain SVG
+	Map mapview.Config `json:"map"`
+	// ShutdownTimeoutSeconds is how long gmah waits for the run, the notifications and the requests when stopping, defaults to 30
+	ShutdownTimeoutSeconds int `json:"shutdown_timeout_seconds"`
+
+	channels map[string]requests.Notifier
+}
+
+// Load reads and validates the config file
+// an empty path returns the default config
+func Load(path string) (Config, error) {
+	cfg := Config{}
+
+	if path != "" {
+		b, err := os.ReadFile(path)
+		if err != nil {
+			return Config{}, err
+		}
+		if err := json.Unmarshal(b, &cfg); err != nil {
+			return Config{}, fmt.Errorf("Error while parsing config %s: %w", path, err)
+		}
+	}
+
+	if cfg.RunRetentionDays == 0 {
+		cfg.RunRetentionDays = 90
+	}
+	if cfg.ReadyMaxFailures == 0 {
+		cfg.ReadyMaxFailures = 3
+	}
+	if cfg.ShutdownTimeoutSeconds == 0 {
+		cfg.ShutdownTimeoutSeconds = 30
+	}
+	if cfg.LogBuffer == 0 {
+		cfg.LogBuffer = 1000
+	}
+	if _, err := logging.ParseLevel(cfg.LogLevel); err != nil {
+		return Config{}, err
+	}
+	if cfg.LogFormat != "" && cfg.LogFormat != "text" && cfg.LogFormat != "json" {
+		return Config{}, fmt.Errorf("unknown log format %q", cfg.LogFormat)
+	}
+
+	cfg.Server.Defaults()
+	if err := cfg.Server.Validate(); err != nil {
+		return Config{}, err
+	}
+	if err := cfg.Auth.Validate(); err != nil {
+		return Config{}, err
+	}
+	if err := cfg.Map.Validate(); err != nil {
+		return Config{}, err
+	}
+	cfg.Market.Defaults()
+	if err := cfg.Market.Validate(); err != nil {
+		return Config{}, err
+	}
+
+	// Keep the old behaviour of talking to the relay in the LAN
+	if len(cfg.Notifiers) == 0 {
+		cfg.Notifiers = []requests.NotifierConfig{{Name: DefaultChannel, Type: "relay", URL: "http://192.168.30.21:8000/gmah"}}
+	}
+	if len(cfg.Notify) == 0 {
+		cfg.Notify = []string{cfg.Notifiers[0].Name}
+	}
+
+	cfg.channels = map[string]requests.Notifier{}
+	for _, nc := range cfg.Notifiers {
+		n, err := requests.New(nc)
+		if err != nil {
+			return Config{}, err
+		}
+		if _, ok := cfg.channels[nc.Name]; ok {
+			return Config{}, fmt.Errorf("notifier %q is defined more than once", nc.Name)
+		}
+		cfg.channels[nc.Name] = n
+	}
+
+	for _, c := range cfg.Notify {
+		if _, ok := cfg.channels[c]; !ok {
+			return Config{}, fmt.Errorf("notify uses unknown notifier %q", c)
+		}
+	}
+
+	pois := map[string]bool{}
+	for _, p := range cfg.POIs {
+		if err := p.Validate(); err != nil {
+			return Config{}, err
+		}
+		if pois[geo.Normalize(p.Name)] {
+			return Config{}, fmt.Errorf("point of interest %q is defined more than once", p.Name)
+		}
+		pois[geo.Normalize(p.Name)] = true
+	}
+
+	if err := cfg.Scoring.Resolve(cfg.POIs); err != nil {
+		return Config{}, err
+	}
+
+	names := map[string]bool{}
+	for i, s := range cfg.SavedSearches {
+		if s.Mode == "" {
+			s.Mode = search.Digest
+		}
+		if len(s.Channels) == 0 {
+			s.Channels = cfg.Notify
+		}
+		if err := s.Validate(); err != nil {
+			return Config{}, err
+		}
+		if names[s.Name] {
+			return Config{}, fmt.Errorf("saved search %q is defined more than once", s.Name)
+		}
+		names[s.Name] = true
+		for _, c := range s.Channels {
+			if _, ok := cfg.channels[c]; !ok {
+				return Config{}, fmt.Errorf("saved search %q uses unknown notifier %q", s.Name, c)
+			}
+		}
+		if s.MinScore != 0 && !cfg.Scoring.Enabled() {
+			return Config{}, fmt.Errorf("saved search %q has min_score but there is no scoring", s.Name)
+		}
+		for j := range s.Within {
+			if err := s.Within[j].Resolve(cfg.POIs); err != nil {
+				return Config{}, fmt.Errorf("saved search %q: %w", s.Name, err)
+			}
+		}
+		cfg.SavedSearches[i] = s
+	}
+
+	return cfg, nil
+}
+
+// ShutdownTimeout returns how long gmah waits when stopping
+func (c Config) ShutdownTimeout() time.Duration {
+	return time.Duration(c.ShutdownTimeoutSeconds) * time.Second
+}
+
+// Channels returns every configured notifier by name
+func (c Config) Channels() map[string]requests.Notifier {
+	return c.channels
+}
@@ -0,0 +1,131 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// Function that writes the config to a file and loads it
+func load(t *testing.T, config string) (Config, error) {
+	path := filepath.Join(t.TempDir(), "config.json")
+	if err := os.WriteFile(path, []byte(config), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return Load(path)
+}
+
+// Test the defaults of the Load function
+func TestLoadDefaults(t *testing.T) {
+	cfg, err := load(t, `{"saved_searches": [{"name": "T3 Aveiro", "typologies": ["T3"]}]}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A saved search sends a digest to the default notifier
+	if s := cfg.SavedSearches[0]; s.Mode != "digest" || strings.Join(s.Channels, ",") != DefaultChannel {
+		t.Errorf("expected a digest to %s, got %s to %v", DefaultChannel, s.Mode, s.Channels)
+	}
+	if cfg.RunRetentionDays != 90 {
+		t.Errorf("expected 90 days of runs, got %d", cfg.RunRetentionDays)
+	}
+	if cfg.ReadyMaxFailures != 3 {
+		t.Errorf("expected 3 failed runs before not ready, got %d", cfg.ReadyMaxFailures)
+	}
+	if cfg.LogBuffer != 1000 {
+		t.Errorf("expected 1000 log lines, got %d", cfg.LogBuffer)
+	}
+	if cfg.Server.Listen != ":9090" {
+		t.Errorf("expected to listen on :9090, got %+v", cfg.Server)
+	}
+	if cfg.ShutdownTimeoutSeconds != 30 {
+		t.Errorf("expected 30 seconds to shut down, got %d", cfg.ShutdownTimeoutSeconds)
+	}
+	if cfg.Market.Days != 180 || cfg.Market.MinSample != 3 {
+		t.Errorf("unexpected market %+v", cfg.Market)
+	}
+}
+
+// Test the notifier defaults of the Load function
+func TestLoadNotifiers(t *testing.T) {
+	cfg, err := load(t, `{
+		"notifiers": [{"name": "phone", "type": "ntfy", "topic": "gmah"}, {"name": "bot", "type": "relay", "url": "http://localhost:8000/gmah"}],
+		"saved_searches": [{"name": "T3 Aveiro", "typologies": ["T3"]}]
+	}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The first notifier gets the daily messages and the saved searches alert the same ones
+	if strings.Join(cfg.Notify, ",") != "phone" {
+		t.Errorf("expected notify to be phone, got %v", cfg.Notify)
+	}
+	if s := cfg.SavedSearches[0]; strings.Join(s.Channels, ",") != "phone" {
+		t.Errorf("expected the alerts to go to phone, got %v", s.Channels)
+	}
+	if len(cfg.Channels()) != 2 {
+		t.Errorf("expected 2 channels, got %d", len(cfg.Channels()))
+	}
+
+	// Without a file the relay in the LAN is the only notifier
+	cfg, err = Load("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(cfg.Notifiers) != 1 || cfg.Notifiers[0].Name != DefaultChannel || cfg.Notifiers[0].Type != "relay" {
+		t.Errorf("expected the default relay, got %+v", cfg.Notifiers)
+	}
+}
+
+// Test the validation of the Load function
+func TestLoad(t *testing.T) {
+	tests := []struct {
+		name    string
+		config  string
+		wantErr string
+	}{
+		{"valid", `{"saved_searches": [{"name": "T3 Aveiro", "typologies": ["T3"], "min_price": 100000, "max_price": 250000}]}`, ""},
+		{"malformed", `{"notify": [}`, "Error while parsing config"},
+		{"search without name", `{"saved_searches": [{"typologies": ["T3"]}]}`, "saved search without name"},
+		{"search mode", `{"saved_searches": [{"name": "T3", "mode": "hourly"}]}`, "invalid mode"},
+		{"search prices", `{"saved_searches": [{"name": "T3", "min_price": 300000, "max_price": 200000}]}`, "min_price bigger than max_price"},
+		{"search twice", `{"saved_searches": [{"name": "T3"}, {"name": "T3"}]}`, "more than once"},
+		{"notifier without name", `{"notifiers": [{"type": "relay", "url": "http://localhost"}]}`, "notifier without name"},
+		{"notifier without url", `{"notifiers": [{"name": "bot", "type": "relay"}]}`, "needs url"},
+		{"notifier type", `{"notifiers": [{"name": "bot", "type": "pigeon"}]}`, "unknown type"},
+		{"notifier twice", `{"notifiers": [{"name": "bot", "type": "relay", "url": "http://a"}, {"name": "bot", "type": "relay", "url": "http://b"}]}`, "more than once"},
+		{"notify unknown", `{"notify": ["phone"]}`, "notify uses unknown notifier"},
+		{"search channel", `{"saved_searches": [{"name": "T3", "channels": ["phone"]}]}`, "uses unknown notifier"},
+		{"log level", `{"log_level": "verbose"}`, "verbose"},
+		{"log format", `{"log_format": "xml"}`, "unknown log format"},
+		{"auth token", `{"auth": {"tokens": [{"name": "ci", "token": "short"}]}}`, "must have at least"},
+		{"server listen", `{"server": {"listen": "9090"}}`, "server listen"},
+		{"server tls", `{"server": {"tls": "always"}}`, "unknown server tls"},
+		{"map tile url", `{"map": {"tile_url": "https://tile.example.com/{z}/{x}.png"}}`, "map tile_url"},
+		{"valid points of interest", `{"points_of_interest": [{"name": "Work", "lat": 40.63, "lon": -8.66}], "saved_searches": [{"name": "near work", "within": [{"poi": "Work", "km": 5}]}]}`, ""},
+		{"point of interest", `{"points_of_interest": [{"name": "Work", "lat": 95, "lon": -8.66}]}`, "invalid coordinates"},
+		{"point of interest twice", `{"points_of_interest": [{"name": "Work", "lat": 40.63, "lon": -8.66}, {"name": "work", "lat": 40.64, "lon": -8.65}]}`, "more than once"},
+		{"search within", `{"saved_searches": [{"name": "T3", "within": [{"poi": "Work", "km": 5}]}]}`, "unknown point of interest"},
+		{"valid scoring", `{"points_of_interest": [{"name": "Work", "lat": 40.63, "lon": -8.66}], "scoring": {"typologies": {"T3": 10}, "distances": [{"poi": "work", "km": 5, "weight": 20}]}, "saved_searches": [{"name": "good ones", "min_score": 10}]}`, ""},
+		{"scoring reference", `{"scoring": {"price_weight": 30}}`, "needs its reference value"},
+		{"scoring point of interest", `{"scoring": {"distances": [{"poi": "School", "km": 5, "weight": 20}]}}`, "unknown point of interest"},
+		{"search score without scoring", `{"saved_searches": [{"name": "T3", "min_score": 50}]}`, "there is no scoring"},
+		{"market days", `{"market": {"days": -1}}`, "market days"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := load(t, tt.config)
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatalf("expected no error, got %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("expected an error with %q, got %v", tt.wantErr, err)
+			}
+		})
+	}
+}
@@ -1,10 +1,13 @@
 package email
 
 import (
+	"errors"
 	"fmt"
 	"io"
-	"log"
+	"log/slog"
 	"regexp"
+	"sort"
+	"strconv"
 	"strings"
 
 	"github.com/PuerkitoBio/goquery"
@@ -14,23 +17,47 @@ import (
 )
 
 type EmailTemplate struct {
+	// UID is the IMAP UID of the message
+	UID     uint32
 	From    string
 	Subject string
 	Snippet string
 	Link    string
+	Price   int
+	Area    int
+	// Photo is the URL of the main photo of the listing
+	Photo string
+	// Thumbnail is the path of the cached copy of the photo, set after it is downloaded
+	Thumbnail string
+	// Score and ScoreDetail are set when the config has a scoring
+	Score       int
+	ScoreDetail string
+	// Market compares the price per m² with the area, like "12% below Aveiro T3 median"
+	Market string
+	// Warnings are the problems found while parsing the body
+	Warnings []string
+	// Body is the HTML the fields were extracted from
+	Body string
 }
 
+var (
+	// ErrDial is returned when the IMAP server can't be reached
+	ErrDial = errors.New("could not connect to the IMAP server")
+	// ErrLogin is returned when the IMAP server refuses the credentials
+	ErrLogin = errors.New("could not login to the IMAP server")
+)
+
 func initClient() (*client.Client, error) {
 	c, err := client.DialTLS("imap.gmail.com:993", nil)
 	if err != nil {
-		return nil, err
+		return nil, fmt.Errorf("%w: %v", ErrDial, err)
 	}
 	return c, nil
 }
 
 func loginClient(c *client.Client, email string, password string) error {
 	if err := c.Login(email, password); err != nil {
-		return err
+		return fmt.Errorf("%w: %v", ErrLogin, err)
 	}
 	return nil
 }
@@ -80,6 +107,59 @@ func GetLinkFromSource(source string, html string, hrefSlice *[]string) error {
 	return nil
 }
 
+// Part of the URL of the listing photos of every portal, the logos and icons never have it
+// Casa Sapo has no fixture yet so it uses the first big image
+var photoPatterns = map[string]string{
+	"idealista":  "idealista.pt/blur/",
+	"SUPERCASA":  "imagens.supercasa.pt",
+	"Imovirtual": "olxcdn.com/v1/files/eyJ",
+	"CasaYes":    "i.casayes.pt/l-feat/listings",
+}
+
+// PhotoHosts returns the hosts of the photos of the known portals, the only ones that are downloaded
+func PhotoHosts() []string {
+	var hosts []string
+	for _, pattern := range photoPatterns {
+		host, _, _ := strings.Cut(pattern, "/")
+		hosts = append(hosts, host)
+	}
+	sort.Strings(hosts)
+	return hosts
+}
+
+// ExtractPhoto returns the URL of the first listing photo of the email, empty when there is none
+func ExtractPhoto(html string, source string) (string, error) {
+	doc, err := goquery.NewDocumentFromReader(strings.NewReader(html))
+	if err != nil {
+		return "", err
+	}
+
+	pattern, known := photoPatterns[source]
+	var photo string
+	doc.Find("img").EachWithBreak(func(i int, s *goquery.Selection) bool {
+		src, _ := s.Attr("src")
+		// Gmail proxies the images and keeps the original URL after the #
+		if i := strings.Index(src, "#https://"); i >= 0 {
+			src = src[i+1:]
+		}
+		if !strings.HasPrefix(src, "https://") && !strings.HasPrefix(src, "http://") {
+			return true
+		}
+		if known && strings.Contains(src, pattern) {
+			photo = src
+			return false
+		}
+		if !known {
+			if w, err := strconv.Atoi(s.AttrOr("width", "")); err == nil && w >= 200 {
+				photo = src
+				return false
+			}
+		}
+		return true
+	})
+	return photo, nil
+}
+
 // Function that extracts the snippet from the HTML itself
 // it cuts from the startCut until the finalCut and grab the content of a tag inside that cut
 func ExtractSnippet(html string, startCut string, finalCut string, tag string, source string) (string, error) {
@@ -156,11 +236,79 @@ func GetSnippetFromSource(source string, html string, snippet *string) error {
 		}
 	case "CasaYes":
 		*snippet, err = ExtractSnippet(html, "p style=color:#111317;line-height:27px;margin:0;overflow:hidden;text-overflow:ellipsis;white-space:nowrap;max-width:260px", "p style=color:#576075;line-height:27px;margin:0;overflow:hidden;text-overflow:ellipsis;white-space:nowrap;max-width:260px", "b", source)
+		// Some clients (and the saved fixtures) keep the style attribute quoted
+		if *snippet == "" {
+			*snippet, err = ExtractSnippet(html, `p style="color:#111317;line-height:27px;margin:0;overflow:hidden;text-overflow:ellipsis;white-space:nowrap;max-width:260px"`, `p style="color:#576075;line-height:27px;margin:0;overflow:hidden;text-overflow:ellipsis;white-space:nowrap;max-width:260px"`, "b", source)
+		}
 	}
 
 	return nil
 }
 
+var (
+	priceRegex = regexp.MustCompile(`(\d{1,3}(?:\.\d{3})+|\d{1,3}(?: \d{3})+|\d{4,})\s?€`)
+	areaRegex  = regexp.MustCompile(`(\d+(?:[.,]\d+)?)\s?m(?:²|2)`)
+)
+
+// Where every listing of an email starts, a digest email repeats it for each listing
+// the snippet comes from the first listing so the price and the area must too
+var listingMarkers = map[string][]string{
+	"idealista":  {"<!-- preheader - description mail -->"},
+	"SUPERCASA":  {"<!-- Pre-header -->"},
+	"Casa Sapo":  {"font-size: 13px; color: #777777; font-family: Arial, Helvetica, sans-serif; padding: 2px 0;"},
+	"Imovirtual": {`<td style="padding-bottom: 8px;">`},
+	// The price is above the title so the listing starts at its photo
+	"CasaYes": {`alt="listing"`, "alt=listing"},
+}
+
+// Function that returns the index of the tag that has the text at i
+func tagStart(html string, i int) int {
+	if t := strings.LastIndex(html[:i], "<"); t >= 0 && !strings.Contains(html[t:i], ">") {
+		return t
+	}
+	return i
+}
+
+// Function that returns the html of the first listing of an email, from its marker to the marker of the next one
+// the whole html when the portal has no marker or the email does not have it
+func firstListing(html string, source string) string {
+	for _, m := range listingMarkers[source] {
+		start := strings.Index(html, m)
+		if start < 0 {
+			continue
+		}
+		end := len(html)
+		if next := strings.Index(html[start+len(m):], m); next >= 0 {
+			end = tagStart(html, start+len(m)+next)
+		}
+		return html[tagStart(html, start):end]
+	}
+	return html
+}
+
+// ExtractDetails looks for the asking price and area of the first listing of the email
+// it returns 0 for the ones that are not present
+func ExtractDetails(html string, source string) (int, int, error) {
+	var price, area int
+
+	doc, err := goquery.NewDocumentFromReader(strings.NewReader(firstListing(html, source)))
+	if err != nil {
+		return 0, 0, err
+	}
+	text := NormalizeSnippet(doc.Find("body").Text())
+
+	if m := priceRegex.FindStringSubmatch(text); m != nil {
+		price, _ = strconv.Atoi(strings.NewReplacer(".", "", " ", "").Replace(m[1]))
+	}
+
+	if m := areaRegex.FindStringSubmatch(text); m != nil {
+		// idealista writes 138.000 m² and imovirtual 191,79 m² so only keep the integer part
+		area, _ = strconv.Atoi(strings.FieldsFunc(m[1], func(r rune) bool { return r == '.' || r == ',' })[0])
+	}
+
+	return price, area, nil
+}
+
 // NormalizeSnippet collapses multiple spaces into one
 func NormalizeSnippet(snippet string) string {
 	re := regexp.MustCompile(`\s+`)
@@ -168,18 +316,20 @@ func NormalizeSnippet(snippet string) string {
 }
 
 // Function to process the email body and extract links and snippets
-func ProcessEmailBody(from string, body string) (EmailTemplate, error) {
+// the problems are logged with logger and kept as warnings
+func ProcessEmailBody(logger *slog.Logger, from string, body string) (EmailTemplate, error) {
 	var email EmailTemplate
 	var hrefSlice []string
 	var snippet string
 
 	// Extract links from the body
 	if err := GetLinkFromSource(from, body, &hrefSlice); err != nil {
-		log.Println(fmt.Errorf("Error while getLinkFromSource: %v", err))
+		logger.Warn("Error while getting link", "portal", from, "err", err)
+		email.Warnings = append(email.Warnings, fmt.Sprintf("link: %v", err))
 	} else if len(hrefSlice) > 0 {
 		// CasaYes for some reason uses the second link
 		// to be the valid link for the house
-		if from == "CasaYes" {
+		if from == "CasaYes" && len(hrefSlice) > 1 {
 			email.Link = hrefSlice[1]
 		} else {
 			email.Link = hrefSlice[0]
@@ -188,16 +338,34 @@ func ProcessEmailBody(from string, body string) (EmailTemplate, error) {
 
 	// Extract snippet from the body
 	if err := GetSnippetFromSource(from, body, &snippet); err != nil {
-		log.Printf("Error while getting Snippet in %s : %v\n", from, err)
+		logger.Warn("Error while getting snippet", "portal", from, "err", err)
+		email.Warnings = append(email.Warnings, fmt.Sprintf("snippet: %v", err))
 	} else {
 		email.Snippet = NormalizeSnippet(snippet)
 	}
 
+	// Extract the main photo from the body
+	if photo, err := ExtractPhoto(body, from); err != nil {
+		logger.Warn("Error while getting photo", "portal", from, "err", err)
+		email.Warnings = append(email.Warnings, fmt.Sprintf("photo: %v", err))
+	} else {
+		email.Photo = photo
+	}
+
+	// Extract price and area from the body
+	if price, area, err := ExtractDetails(body, from); err != nil {
+		logger.Warn("Error while getting details", "portal", from, "err", err)
+		email.Warnings = append(email.Warnings, fmt.Sprintf("details: %v", err))
+	} else {
+		email.Price = price
+		email.Area = area
+	}
+
 	return email, nil
 }
 
 // Function that generates the final slice to place inside the HTML template
-func buildEmail(messages chan *imap.Message, section *imap.BodySectionName, newMessages *int) ([]EmailTemplate, error) {
+func buildEmail(logger *slog.Logger, messages chan *imap.Message, section *imap.BodySectionName, newMessages *int) ([]EmailTemplate, error) {
 	var emails []EmailTemplate
 
 	for message := range messages {
@@ -220,7 +388,8 @@ func buildEmail(messages chan *imap.Message, section *imap.BodySectionName, newM
 
 		header := mr.Header
 
-		var email EmailTemplate
+		email := EmailTemplate{UID: message.Uid}
+		msgLogger := logger.With("uid", message.Uid)
 		if from, err := header.AddressList("From"); err == nil {
 			email.From = from[0].Name
 		}
@@ -228,6 +397,8 @@ func buildEmail(messages chan *imap.Message, section *imap.BodySectionName, newM
 			email.Subject = subject
 		}
 
+		msgLogger.Debug("Fetched message", "portal", email.From, "subject", email.Subject)
+
 		// Workaround for unwanted emails
 		if email.Subject != "Novos anúncios hoje" && email.Subject != "Imóveis da mediadora Loben" && email.Subject != "Novos imóveis hoje" {
 			for {
@@ -235,7 +406,7 @@ func buildEmail(messages chan *imap.Message, section *imap.BodySectionName, newM
 				if err == io.EOF {
 					break
 				} else if err != nil {
-					log.Fatal(err)
+					return []EmailTemplate{}, fmt.Errorf("Error while reading message %d: %w", message.Uid, err)
 				}
 
 				b, err := io.ReadAll(p.Body)
@@ -244,78 +415,120 @@ func buildEmail(messages chan *imap.Message, section *imap.BodySectionName, newM
 				}
 
 				// Process the email body
-				processedEmail, err := ProcessEmailBody(email.From, string(b))
+				processedEmail, err := ProcessEmailBody(msgLogger, email.From, string(b))
 				if err != nil {
-					log.Printf("Error processing email body: %v", err)
+					msgLogger.Error("Error processing email body", "err", err)
 					continue
 				}
 				email.Link = processedEmail.Link
 				email.Snippet = processedEmail.Snippet
+				email.Warnings = processedEmail.Warnings
+				email.Body = string(b)
+				if processedEmail.Price != 0 {
+					email.Price = processedEmail.Price
+				}
+				if processedEmail.Area != 0 {
+					email.Area = processedEmail.Area
+				}
 			}
+			msgLogger.Info("Processed message", "portal", email.From, "link", email.Link != "", "snippet", email.Snippet != "", "price", email.Price)
 			emails = append(emails, email)
+		} else {
+			msgLogger.Debug("Skipping unwanted message", "subject", email.Subject)
 		}
 	}
 
 	return emails, nil
 }
 
+// Function that returns the mailbox with the portal emails
+func mailbox(isDebug bool) string {
+	if isDebug {
+		return "teste"
+	}
+	return "Casas"
+}
+
 // Main function that performs all the necessary logic to read and build emails
-func ReadEmails(isDebug bool, email string, password string, newMessages *int) ([]EmailTemplate, error) {
+// the messages are read without marking them as seen, MarkSeen does it once the run
+// saved them, so a run killed halfway reads them again the next time
+// the UIDs of every unread message, wanted or not, are returned for MarkSeen
+func ReadEmails(logger *slog.Logger, isDebug bool, email string, password string, newMessages *int) ([]EmailTemplate, []uint32, error) {
 	c, err := initClient()
 	if err != nil {
-		return []EmailTemplate{}, err
+		return []EmailTemplate{}, nil, err
 	}
 	defer c.Close()
 
 	if err := loginClient(c, email, password); err != nil {
-		return []EmailTemplate{}, err
-	}
-
-	var mbox *imap.MailboxStatus
-
-	if isDebug {
-		mbox, err = c.Select("teste", false)
-	} else {
-		mbox, err = c.Select("Casas", false)
+		return []EmailTemplate{}, nil, err
 	}
 
+	mbox, err := c.Select(mailbox(isDebug), false)
 	if err != nil {
-		return []EmailTemplate{}, err
+		return []EmailTemplate{}, nil, err
 	}
 
 	if mbox.Messages == 0 {
-		log.Println("No messages in Casas so skipping ...")
-		return []EmailTemplate{}, fmt.Errorf("No messages in Casas so skipping ...")
+		logger.Info("No messages in Casas so skipping ...")
+		return []EmailTemplate{}, nil, fmt.Errorf("No messages in Casas so skipping ...")
 	}
 
-	seqSet := new(imap.SeqSet)
-	seqSet.AddNum(mbox.Messages)
 	criteria := imap.NewSearchCriteria()
 	criteria.WithoutFlags = []string{"\\Seen"}
-	uids, err := c.Search(criteria)
+	uids, err := c.UidSearch(criteria)
 	if err != nil {
-		return []EmailTemplate{}, err
+		return []EmailTemplate{}, nil, err
 	}
 
 	seqset := new(imap.SeqSet)
 	seqset.AddNum(uids...)
-	section := &imap.BodySectionName{}
-	items := []imap.FetchItem{imap.FetchEnvelope, imap.FetchFlags, imap.FetchInternalDate, section.FetchItem()}
+	section := &imap.BodySectionName{Peek: true}
+	items := []imap.FetchItem{imap.FetchEnvelope, imap.FetchFlags, imap.FetchInternalDate, imap.FetchUid, section.FetchItem()}
 	messages := make(chan *imap.Message, 1)
 
 	// Fetch all messages unread that are inside Casas label
 	var emails []EmailTemplate
-	//isErr := false
 	go func() {
-		if err = c.Fetch(seqset, items, messages); err != nil {
-			log.Println("Fetch err:", err)
+		if err := c.UidFetch(seqset, items, messages); err != nil {
+			logger.Error("Error while fetching messages", "err", err)
 		}
 	}()
 
-	emails, err = buildEmail(messages, section, newMessages)
+	logger.Info("Fetching unread messages", "count", len(uids))
+	emails, err = buildEmail(logger, messages, section, newMessages)
 	if err != nil {
-		return []EmailTemplate{}, err
+		return []EmailTemplate{}, nil, err
 	}
 
-	return emails, err
+	return emails, uids, err
+}
+
+// MarkSeen marks the messages read by ReadEmails as seen so the next run skips them
+func MarkSeen(logger *slog.Logger, isDebug bool, email string, password string, uids []uint32) error {
+	if len(uids) == 0 {
+		return nil
+	}
+
+	c, err := initClient()
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+
+	if err := loginClient(c, email, password); err != nil {
+		return err
+	}
+	if _, err := c.Select(mailbox(isDebug), false); err != nil {
+		return err
+	}
+
+	seqset := new(imap.SeqSet)
+	seqset.AddNum(uids...)
+	item := imap.FormatFlagsOp(imap.AddFlags, true)
+	if err := c.UidStore(seqset, item, []interface{}{imap.SeenFlag}, nil); err != nil {
+		return err
+	}
+	logger.Info("Marked messages as seen", "count", len(uids))
+	return nil
 }
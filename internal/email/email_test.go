@@ -1,7 +1,9 @@
 package email
 
 import (
+	"log/slog"
 	"os"
+	"strings"
 	"testing"
 )
 
@@ -22,41 +24,47 @@ func TestProcessEmailBody(t *testing.T) {
 		bodyFile    string
 		wantLink    string
 		wantSnippet string
+		wantPrice   int
+		wantArea    int
 	}{
 		/*		{
 					name:        "idealista",
 					from:        "idealista",
-					bodyFile:    "/home/brun0/Desktop/personal/gmah/testdata/idealista.html",
+					bodyFile:    "../../testdata/idealista.html",
 					wantLink:    `3D"https://www.idealista.pt/imovel/33667017/?xts=3D582068&xto=`,
 					wantSnippet: "Apartamento T3 em praceta Doutor Alberto Tavares de Castro 9 Oliveira do Bairro Oliveira do Bairro 160000 E282AC Apartamento T3 venda no Centro da CidadeDescubra este excelente apartamento T3 que co Ver 9 fotos 160000 E282AC 160000 E282AC€",
 				},
 				{
 					name:        "SUPERCASA",
 					from:        "SUPERCASA",
-					bodyFile:    "/home/brun0/Desktop/personal/gmah/testdata/SUPERCASA.html",
+					bodyFile:    "../../testdata/SUPERCASA.html",
 					wantLink:    "https://supercasa.pt/venda-apartamento-t3-aveiro/i1736538?utm_source=scalert&utm_medium=immediatealert-newrealestate&utm_campaign=20240921&mid=583735611&ansid=674057883&euid=mb1EXd64Jg7G1fa2ijnWvA==&ffcf=1",
 					wantSnippet: "Apartamento T3 venda em Glria e Vera Cruz",
 				},
 				{
 					name:        "Imovirtual",
 					from:        "Imovirtual",
-					bodyFile:    "/home/brun0/Desktop/personal/gmah/testdata/imovirtual.html",
+					bodyFile:    "../../testdata/imovirtual.html",
 					wantLink:    "https://www.imovirtual.com/pt/anuncio/moradia-t3-para-venda-em-anadia-ID1fxx0?utm_medium=email&utm_source=siren&utm_campaign=saved-search-immediate",
 					wantSnippet: "Moradia T3 para venda em Anadia",
 				},*/
 		{
 			name:        "CasaYes",
 			from:        "CasaYes",
-			bodyFile:    "/home/brun0/Desktop/personal/gmah/testdata/casayes.html",
+			bodyFile:    "../../testdata/casayes.html",
 			wantLink:    "https://1818X.trk.elasticemail.com/tracking/click?d=fONHM7NUd7C3oiKWHQrWe2020qp_xSD1KaqL1Eq9CPW9uvjqqkosmRpzWW5Drx3_XGNnBLoGCohIU0mhg794xC4sPGN2EmtfMRQrXcfYOsWnlrbUHLHFo6DV4UzhtEYsWYX8ZE8AmBWlGO3Jq8awf0iVq87gq8OKX7udCRFsd4pF0",
 			wantSnippet: "Apartamento T3 Ovar So Joo Arada e So Vicente de Pereira Jus Ovar",
+			wantPrice:   239900,
+			wantArea:    124,
 		},
 		{
 			name:        "CasaYes",
 			from:        "CasaYes",
-			bodyFile:    "/home/brun0/Desktop/personal/gmah/testdata/casayes2.html",
+			bodyFile:    "../../testdata/casayes2.html",
 			wantLink:    "https://1818X.trk.elasticemail.com/tracking/click?d=fONHM7NUd7C3oiKWHQrWe2020qp_xSD1KaqL1Eq9CPX_UpDV5qx3CZN-pebYlv0tUucJkdANC2LAJMPjGuYGOmwN7ptmVsKiitrEG556wiC4cOz7kmoDB1JFJtUnlCq_r-ArFCL0cHCISsALI7N066eFGq9NvJGUWXdT5PJkP87u0",
 			wantSnippet: "Moradia T3 Esgueira Aveiro",
+			wantPrice:   205000,
+			wantArea:    119,
 		},
 	}
 
@@ -65,7 +73,7 @@ func TestProcessEmailBody(t *testing.T) {
 			// Load the HTML content from the file
 			body := loadTestHTMLFile(t, tt.bodyFile)
 
-			email, err := ProcessEmailBody(tt.from, body)
+			email, err := ProcessEmailBody(slog.Default(), tt.from, body)
 			if err != nil {
 				t.Fatalf("processEmailBody() returned an error: %v", err)
 			}
@@ -76,6 +84,85 @@ func TestProcessEmailBody(t *testing.T) {
 			if email.Snippet != tt.wantSnippet {
 				t.Errorf("expected Snippet to be %s, got %s", tt.wantSnippet, email.Snippet)
 			}
+			if email.Price != tt.wantPrice {
+				t.Errorf("expected Price to be %d, got %d", tt.wantPrice, email.Price)
+			}
+			if email.Area != tt.wantArea {
+				t.Errorf("expected Area to be %d, got %d", tt.wantArea, email.Area)
+			}
+		})
+	}
+}
+
+// Test the ExtractPhoto function
+func TestExtractPhoto(t *testing.T) {
+	tests := []struct {
+		name      string
+		from      string
+		bodyFile  string
+		wantPhoto string
+	}{
+		{"idealista", "idealista", "../../testdata/idealista_decoded.html", "https://img3.idealista.pt/blur/500_375_mq/0/id.pro.pt.image.master/49/3d/70/257129818.jpg"},
+		{"SUPERCASA", "SUPERCASA", "../../testdata/SUPERCASA.html", "https://imagens.supercasa.pt/Z720x540/OAYES/S5/C14481/P25282474/Tphoto/IDaac78101-0000-0500-0000-000013b6be30.jpg"},
+		{"Imovirtual", "Imovirtual", "../../testdata/imovirtual.html", "https://ireland.apollo.olxcdn.com/v1/files/eyJmbiI6InQ0c290Z3RkdDViMS1FQ09TWVNURU0iLCJ3IjpbeyJmbiI6IjY5bmxwYTdlY3FtNzEtQVBUIiwicyI6IjE0IiwicCI6IjEwLC0xMCIsImEiOiIwIn1dfQ._lkKnKgmPcEI_Q-NbyDwnm-CnSOTDDlVCidQhS-vARs/image;s=655x491;q=80"},
+		{"CasaYes", "CasaYes", "../../testdata/casayes.html", "https://i.casayes.pt/l-feat/listings/remax-family-ii/FE04vLl0pfw/l-view_listings_12686_7000358_eab91d5f-b383-43d0-8937-f133b284ff9b.jpg"},
+		{"unknown portal", "Casa Sapo", "../../testdata/SUPERCASA.html", "https://imagens.supercasa.pt/Z720x540/OAYES/S5/C14481/P25282474/Tphoto/IDaac78101-0000-0500-0000-000013b6be30.jpg"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			photo, err := ExtractPhoto(loadTestHTMLFile(t, tt.bodyFile), tt.from)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if photo != tt.wantPhoto {
+				t.Errorf("expected photo %s, got %s", tt.wantPhoto, photo)
+			}
+		})
+	}
+}
+
+// Test the PhotoHosts function
+func TestPhotoHosts(t *testing.T) {
+	want := []string{"i.casayes.pt", "idealista.pt", "imagens.supercasa.pt", "olxcdn.com"}
+	got := PhotoHosts()
+	if strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Errorf("expected hosts %v, got %v", want, got)
+	}
+}
+
+// Test the ExtractDetails function
+func TestExtractDetails(t *testing.T) {
+	card := func(price, area string) string {
+		return `<table><tr><td><img alt="listing" src="https://i.casayes.pt/l-feat/1.jpg" width="260"></td></tr></table>` +
+			`<p style="color:#111317;line-height:36px"><b>` + price + `</b>` +
+			`<p style="color:#111317;line-height:27px"><b>Moradia T3 Aveiro</b>` +
+			`<p><b>` + area + `</b></p>`
+	}
+
+	tests := []struct {
+		name      string
+		from      string
+		body      string
+		wantPrice int
+		wantArea  int
+	}{
+		{"single listing", "CasaYes", loadTestHTMLFile(t, "../../testdata/casayes2.html"), 205000, 119},
+		{"digest", "CasaYes", `<p>Novos imóveis 3 000 €</p>` + card("239 900 €", "124 m<sup>2</sup>") + card("150 000 €", "80 m<sup>2</sup>"), 239900, 124},
+		// The area of the second listing is not the area of the first
+		{"digest without area", "CasaYes", card("239 900 €", "") + card("150 000 €", "80 m<sup>2</sup>"), 239900, 0},
+		{"portal without marker", "Loben", `<p>Moradia T3</p><p>198.000 €</p><p>110 m²</p>`, 198000, 110},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			price, area, err := ExtractDetails(tt.body, tt.from)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if price != tt.wantPrice || area != tt.wantArea {
+				t.Errorf("expected %d € and %d m², got %d € and %d m²", tt.wantPrice, tt.wantArea, price, area)
+			}
 		})
 	}
 }
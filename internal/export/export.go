@@ -0,0 +1,337 @@
+package export
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/BrunoTeixeira1996/gmah/internal/geo"
+	"github.com/BrunoTeixeira1996/gmah/internal/listing"
+	"github.com/BrunoTeixeira1996/gmah/internal/search"
+)
+
+// Format of an export
+type Format string
+
+const (
+	CSV   Format = "csv"
+	JSONL Format = "jsonl"
+	XLSX  Format = "xlsx"
+)
+
+// ParseFormat returns the format named s, empty is csv
+func ParseFormat(s string) (Format, error) {
+	switch Format(s) {
+	case "", CSV:
+		return CSV, nil
+	case JSONL, XLSX:
+		return Format(s), nil
+	}
+	return "", fmt.Errorf("unknown export format %q (use csv, jsonl or xlsx)", s)
+}
+
+// ContentType is the media type of the format
+func (f Format) ContentType() string {
+	switch f {
+	case JSONL:
+		return "application/x-ndjson"
+	case XLSX:
+		return "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet"
+	}
+	return "text/csv; charset=utf-8"
+}
+
+// Filter selects the listings of an export, zero values select everything
+type Filter struct {
+	// From and To are days, a listing is exported when it was seen between them
+	From time.Time
+	To   time.Time
+	// Search is the saved search the listings must match
+	Search *search.SavedSearch
+	// Within are distances to points of interest the listings must be within, see NewWithin
+	Within []search.Within
+}
+
+// NewFilter builds a filter from YYYY-MM-DD dates and the name of a saved search, all optional
+func NewFilter(from, to, searchName string, searches []search.SavedSearch, loc *time.Location) (Filter, error) {
+	var f Filter
+	var err error
+	if from != "" {
+		if f.From, err = time.ParseInLocation("2006-01-02", from, loc); err != nil {
+			return Filter{}, fmt.Errorf("from %q is not a YYYY-MM-DD date", from)
+		}
+	}
+	if to != "" {
+		if f.To, err = time.ParseInLocation("2006-01-02", to, loc); err != nil {
+			return Filter{}, fmt.Errorf("to %q is not a YYYY-MM-DD date", to)
+		}
+	}
+	if !f.From.IsZero() && !f.To.IsZero() && f.To.Before(f.From) {
+		return Filter{}, fmt.Errorf("from must be before to")
+	}
+	if searchName != "" {
+		for i := range searches {
+			if searches[i].Name == searchName {
+				f.Search = &searches[i]
+				break
+			}
+		}
+		if f.Search == nil {
+			return Filter{}, fmt.Errorf("unknown saved search %q", searchName)
+		}
+	}
+	return f, nil
+}
+
+// NewWithin returns the rule of the listings at most km km from the point of interest poi,
+// both are query parameters so empty means there is no rule
+func NewWithin(poi, km string, pois []geo.POI) ([]search.Within, error) {
+	if poi == "" && km == "" {
+		return nil, nil
+	}
+	if poi == "" || km == "" {
+		return nil, fmt.Errorf("near and km go together")
+	}
+	n, err := strconv.ParseFloat(km, 64)
+	if err != nil {
+		return nil, fmt.Errorf("km %q is not a number", km)
+	}
+	w := search.Within{POI: poi, Km: n}
+	if err := w.Resolve(pois); err != nil {
+		return nil, err
+	}
+	return []search.Within{w}, nil
+}
+
+// Select returns the records that pass the filter, in the same order
+func (f Filter) Select(records []listing.Record) []listing.Record {
+	selected := []listing.Record{}
+	for _, r := range records {
+		if !f.From.IsZero() && r.LastSeen.Before(f.From) {
+			continue
+		}
+		// To is a day, everything seen during it counts
+		if !f.To.IsZero() && !r.FirstSeen.Before(f.To.AddDate(0, 0, 1)) {
+			continue
+		}
+		if f.Search != nil && !f.Search.Match(r.Listing) {
+			continue
+		}
+		near := true
+		for _, w := range f.Within {
+			near = near && w.Match(r.Listing)
+		}
+		if !near {
+			continue
+		}
+		selected = append(selected, r)
+	}
+	return selected
+}
+
+// Sort orders the records by newest (the order of the catalog), score (best first), price, price_per_m2,
+// market (cheapest for their area first) or km:<point of interest> (nearest first), the records without that value go last
+func Sort(records []listing.Record, by string, pois []geo.POI) error {
+	var value func(r listing.Record) (float64, bool)
+	switch {
+	case by == "" || by == "newest":
+		return nil
+	case by == "score":
+		value = func(r listing.Record) (float64, bool) { return -float64(r.Score), len(r.ScoreParts) > 0 }
+	case by == "price":
+		value = func(r listing.Record) (float64, bool) { return float64(r.Price), r.Price > 0 }
+	case by == "price_per_m2":
+		value = func(r listing.Record) (float64, bool) {
+			if r.Price == 0 || r.Area == 0 {
+				return 0, false
+			}
+			return float64(r.Price / r.Area), true
+		}
+	case by == "market":
+		value = func(r listing.Record) (float64, bool) {
+			if r.Market == nil {
+				return 0, false
+			}
+			return r.Market.Deviation, true
+		}
+	case strings.HasPrefix(by, "km:"):
+		poi, ok := geo.FindPOI(pois, strings.TrimPrefix(by, "km:"))
+		if !ok {
+			return fmt.Errorf("unknown point of interest %q", strings.TrimPrefix(by, "km:"))
+		}
+		value = func(r listing.Record) (float64, bool) { return Distance(r, poi) }
+	default:
+		return fmt.Errorf("unknown sort %q (use newest, score, price, price_per_m2, market or km:<point of interest>)", by)
+	}
+
+	sort.SliceStable(records, func(i, j int) bool {
+		vi, oki := value(records[i])
+		vj, okj := value(records[j])
+		if oki != okj {
+			return oki
+		}
+		return vi < vj
+	})
+	return nil
+}
+
+// Row is an exported listing, the fields are the columns in this order
+type Row struct {
+	Key        string    `json:"key"`
+	Portal     string    `json:"portal"`
+	Title      string    `json:"title"`
+	Typology   string    `json:"typology"`
+	Price      int       `json:"price"`
+	Area       int       `json:"area"`
+	PricePerM2 int       `json:"price_per_m2"`
+	Location   string    `json:"location"`
+	Link       string    `json:"link"`
+	FirstSeen  time.Time `json:"first_seen"`
+	LastSeen   time.Time `json:"last_seen"`
+	TimesSeen  int       `json:"times_seen"`
+	Status     string    `json:"status"`
+	Notes      string    `json:"notes"`
+	Group      string    `json:"group"`
+	Score      int       `json:"score"`
+	// AreaMedian is the median price per m² of the area of the listing and VsArea how far it is from it in %,
+	// both 0 when it is unknown
+	AreaMedian int     `json:"area_median_per_m2"`
+	VsArea     float64 `json:"vs_area_median"`
+	// Distances are the km to every point of interest, left out when the listing has no place
+	Distances map[string]float64 `json:"distances,omitempty"`
+
+	// km has the distances in the order of the points of interest, "" when unknown
+	km []interface{}
+}
+
+// Columns are the header of the csv and xlsx exports
+var Columns = []string{"key", "portal", "title", "typology", "price", "area", "price_per_m2", "location", "link", "first_seen", "last_seen", "times_seen", "status", "notes", "group", "score", "area_median_per_m2", "vs_area_median"}
+
+// Header returns the columns followed by the distance to every point of interest
+func Header(pois []geo.POI) []string {
+	header := append([]string(nil), Columns...)
+	for _, p := range pois {
+		header = append(header, "km_to_"+strings.ReplaceAll(geo.Normalize(p.Name), " ", "_"))
+	}
+	return header
+}
+
+// Distance returns the distance in km from the record to a point of interest, rounded to 100 m
+// false when the record has no place or only its district is known
+func Distance(r listing.Record, poi geo.POI) (float64, bool) {
+	if r.Place == nil {
+		return 0, false
+	}
+	km, ok := r.Place.DistanceTo(poi)
+	if !ok {
+		return 0, false
+	}
+	return math.Round(km*10) / 10, true
+}
+
+// NewRow returns the row of a record, unknown numbers are 0
+func NewRow(r listing.Record, pois []geo.POI) Row {
+	row := Row{
+		Key:       r.Key,
+		Portal:    r.Portal,
+		Title:     r.Title,
+		Typology:  r.Typology,
+		Price:     r.Price,
+		Area:      r.Area,
+		Location:  r.Location,
+		Link:      r.Link,
+		FirstSeen: r.FirstSeen,
+		LastSeen:  r.LastSeen,
+		TimesSeen: r.TimesSeen,
+		Status:    r.Status,
+		Notes:     r.Notes,
+		Group:     r.Group,
+		Score:     r.Score,
+	}
+	if r.Market != nil {
+		row.AreaMedian = r.Market.Median
+		row.VsArea = math.Round(r.Market.Deviation*1000) / 10
+	}
+	if r.Price > 0 && r.Area > 0 {
+		row.PricePerM2 = r.Price / r.Area
+	}
+	for _, p := range pois {
+		km, ok := Distance(r, p)
+		if !ok {
+			row.km = append(row.km, "")
+			continue
+		}
+		if row.Distances == nil {
+			row.Distances = map[string]float64{}
+		}
+		row.Distances[p.Name] = km
+		row.km = append(row.km, km)
+	}
+	return row
+}
+
+// values returns the cells of the row in the order of Header
+func (r Row) values() []interface{} {
+	return append([]interface{}{r.Key, r.Portal, r.Title, r.Typology, r.Price, r.Area, r.PricePerM2, r.Location, r.Link, r.FirstSeen, r.LastSeen, r.TimesSeen, r.Status, r.Notes, r.Group, r.Score, r.AreaMedian, r.VsArea}, r.km...)
+}
+
+// Write exports the records to w in the format, with their distance to the points of interest
+func Write(w io.Writer, f Format, records []listing.Record, pois []geo.POI) error {
+	rows := make([]Row, 0, len(records))
+	for _, r := range records {
+		rows = append(rows, NewRow(r, pois))
+	}
+
+	switch f {
+	case JSONL:
+		return writeJSONL(w, rows)
+	case XLSX:
+		return writeXLSX(w, Header(pois), rows)
+	}
+	return writeCSV(w, Header(pois), rows)
+}
+
+// Function that writes one JSON object per line
+func writeJSONL(w io.Writer, rows []Row) error {
+	enc := json.NewEncoder(w)
+	for _, r := range rows {
+		if err := enc.Encode(r); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Function that writes the rows as csv with a header, dates are RFC 3339
+func writeCSV(w io.Writer, header []string, rows []Row) error {
+	cw := csv.NewWriter(w)
+	if err := cw.Write(header); err != nil {
+		return err
+	}
+	for _, r := range rows {
+		var record []string
+		for _, v := range r.values() {
+			switch v := v.(type) {
+			case string:
+				record = append(record, v)
+			case int:
+				record = append(record, strconv.Itoa(v))
+			case float64:
+				record = append(record, strconv.FormatFloat(v, 'f', -1, 64))
+			case time.Time:
+				record = append(record, v.Format(time.RFC3339))
+			}
+		}
+		if err := cw.Write(record); err != nil {
+			return err
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
@@ -0,0 +1,202 @@
+package export
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/BrunoTeixeira1996/gmah/internal/geo"
+	"github.com/BrunoTeixeira1996/gmah/internal/listing"
+	"github.com/BrunoTeixeira1996/gmah/internal/search"
+)
+
+// University of Aveiro, 3.2 km from Esgueira
+var testPOIs = []geo.POI{{Name: "Work", Lat: 40.6302, Lon: -8.6575}}
+
+func testRecords() []listing.Record {
+	day := func(d int) time.Time { return time.Date(2024, 9, d, 23, 59, 0, 0, time.UTC) }
+	return []listing.Record{
+		{
+			Listing:   listing.Listing{Portal: "idealista", Title: "Moradia T3, Esgueira", Typology: "T3", Price: 205000, Area: 100, Location: "Esgueira, Aveiro", Link: "https://www.idealista.pt/imovel/123/", Place: &geo.Place{ID: "aveiro/aveiro/esgueira", District: "Aveiro", Municipality: "Aveiro", Parish: "Esgueira", Lat: 40.65, Lon: -8.63}, Market: &listing.Comparison{Area: "Esgueira", Typology: "T3", Median: 2330, Sample: 5, Deviation: -0.1202}},
+			Key:       "idealista|www.idealista.pt/imovel/123",
+			FirstSeen: day(1), LastSeen: day(10), TimesSeen: 3,
+			Status: "visit", Notes: "Saturday 10h",
+		},
+		{
+			Listing:   listing.Listing{Portal: "CasaYes", Title: "Apartamento T2", Typology: "T2", Price: 150000},
+			Key:       "casayes|apartamento-t2|0",
+			FirstSeen: day(20), LastSeen: day(20), TimesSeen: 1,
+		},
+	}
+}
+
+// Test the Select function
+func TestSelect(t *testing.T) {
+	searches := []search.SavedSearch{{Name: "t3", Typologies: []string{"T3"}, Mode: search.Digest}}
+
+	tests := []struct {
+		name     string
+		from     string
+		to       string
+		search   string
+		near     string
+		km       string
+		wantKeys int
+		wantErr  bool
+	}{
+		{"everything", "", "", "", "", "", 2, false},
+		{"seen until the 10th", "", "2024-09-10", "", "", "", 1, false},
+		{"seen after the 11th", "2024-09-11", "", "", "", "", 1, false},
+		{"range", "2024-09-05", "2024-09-25", "", "", "", 2, false},
+		{"saved search", "", "", "t3", "", "", 1, false},
+		{"unknown saved search", "", "", "t4", "", "", 0, true},
+		{"malformed date", "01/09/2024", "", "", "", "", 0, true},
+		{"reversed range", "2024-09-25", "2024-09-05", "", "", "", 0, true},
+		{"within 5 km of work", "", "", "", "work", "5", 1, false},
+		{"within 1 km of work", "", "", "", "Work", "1", 0, false},
+		{"unknown point of interest", "", "", "", "School", "5", 0, true},
+		{"near without km", "", "", "", "Work", "", 0, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f, err := NewFilter(tt.from, tt.to, tt.search, searches, time.UTC)
+			if err == nil {
+				f.Within, err = NewWithin(tt.near, tt.km, testPOIs)
+			}
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("expected error %v, got %v", tt.wantErr, err)
+			}
+			if err != nil {
+				return
+			}
+			if got := f.Select(testRecords()); len(got) != tt.wantKeys {
+				t.Errorf("expected %d listings, got %d", tt.wantKeys, len(got))
+			}
+		})
+	}
+}
+
+// Test the Write function
+func TestWrite(t *testing.T) {
+	var b bytes.Buffer
+	if err := Write(&b, CSV, testRecords(), testPOIs); err != nil {
+		t.Fatal(err)
+	}
+	rows, err := csv.NewReader(&b).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rows) != 3 || strings.Join(rows[0], ",") != strings.Join(Columns, ",")+",km_to_work" {
+		t.Fatalf("expected a header and 2 rows, got %v", rows)
+	}
+	if rows[1][2] != "Moradia T3, Esgueira" || rows[1][6] != "2050" || rows[1][12] != "visit" || rows[1][17] != "-12" || rows[1][18] != "3.2" || rows[2][17] != "0" || rows[2][18] != "" {
+		t.Errorf("unexpected csv rows %v", rows[1:])
+	}
+
+	b.Reset()
+	if err := Write(&b, JSONL, testRecords(), testPOIs); err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(b.String()), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("expected 2 lines, got %d", len(lines))
+	}
+	var row Row
+	if err := json.Unmarshal([]byte(lines[0]), &row); err != nil || row.Notes != "Saturday 10h" || row.Distances["Work"] != 3.2 {
+		t.Errorf("unexpected json line %s (%v)", lines[0], err)
+	}
+
+	b.Reset()
+	if err := Write(&b, XLSX, testRecords(), testPOIs); err != nil {
+		t.Fatal(err)
+	}
+	zr, err := zip.NewReader(bytes.NewReader(b.Bytes()), int64(b.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var sheet string
+	for _, f := range zr.File {
+		if f.Name == "xl/worksheets/sheet1.xml" {
+			rc, err := f.Open()
+			if err != nil {
+				t.Fatal(err)
+			}
+			content, _ := io.ReadAll(rc)
+			rc.Close()
+			sheet = string(content)
+		}
+	}
+	for _, want := range []string{`<c r="E2"><v>205000</v></c>`, `<c r="N1" t="inlineStr"><is><t xml:space="preserve">notes</t></is></c>`, `<c r="R2"><v>-12</v></c>`, `<c r="S2"><v>3.2</v></c>`, `<row r="3">`} {
+		if !strings.Contains(sheet, want) {
+			t.Errorf("expected %s in the sheet", want)
+		}
+	}
+}
+
+// Test the Sort function
+func TestSort(t *testing.T) {
+	tests := []struct {
+		by       string
+		wantKeys []string
+		wantErr  bool
+	}{
+		{"", []string{"idealista|www.idealista.pt/imovel/123", "casayes|apartamento-t2|0"}, false},
+		{"price", []string{"casayes|apartamento-t2|0", "idealista|www.idealista.pt/imovel/123"}, false},
+		// Without area the second one has no price per m² so it goes last
+		{"price_per_m2", []string{"idealista|www.idealista.pt/imovel/123", "casayes|apartamento-t2|0"}, false},
+		{"km:work", []string{"idealista|www.idealista.pt/imovel/123", "casayes|apartamento-t2|0"}, false},
+		// Only the first one has a market comparison
+		{"market", []string{"idealista|www.idealista.pt/imovel/123", "casayes|apartamento-t2|0"}, false},
+		{"km:school", nil, true},
+		{"area", nil, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.by, func(t *testing.T) {
+			records := testRecords()
+			err := Sort(records, tt.by, testPOIs)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("expected error %v, got %v", tt.wantErr, err)
+			}
+			if err != nil {
+				return
+			}
+			for i, key := range tt.wantKeys {
+				if records[i].Key != key {
+					t.Errorf("expected %s at %d, got %s", key, i, records[i].Key)
+				}
+			}
+		})
+	}
+}
+
+// Test the Distance function
+func TestDistance(t *testing.T) {
+	tests := []struct {
+		name   string
+		place  *geo.Place
+		wantKm float64
+		wantOk bool
+	}{
+		{"parish", &geo.Place{District: "Aveiro", Municipality: "Aveiro", Parish: "Esgueira", Lat: 40.65, Lon: -8.63}, 3.2, true},
+		{"municipality", &geo.Place{District: "Aveiro", Municipality: "Ílhavo", Lat: 40.60, Lon: -8.6667}, 3.4, true},
+		// The seat of the district says nothing about where the listing is
+		{"district", &geo.Place{District: "Aveiro", Lat: 40.6405, Lon: -8.6538}, 0, false},
+		{"no place", nil, 0, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			km, ok := Distance(listing.Record{Listing: listing.Listing{Place: tt.place}}, testPOIs[0])
+			if km != tt.wantKm || ok != tt.wantOk {
+				t.Errorf("expected %v km (%v), got %v km (%v)", tt.wantKm, tt.wantOk, km, ok)
+			}
+		})
+	}
+}
@@ -0,0 +1,273 @@
+package extraction
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/BrunoTeixeira1996/gmah/internal/email"
+	"github.com/BrunoTeixeira1996/gmah/internal/metrics"
+	"github.com/BrunoTeixeira1996/gmah/internal/store"
+)
+
+// Fields that every listing should have
+const (
+	FieldLink  = "link"
+	FieldPrice = "price"
+	FieldTitle = "title"
+)
+
+const statsDocument = "extraction"
+
+const (
+	// A field is healthy when the baseline has minBaseline messages and it is missing in at most maxBaselineMissing of them
+	minBaseline        = 10
+	maxBaselineMissing = 0.2
+	// A healthy field breaks when it is missing in at least half of the messages of a run and in minFailures of them
+	minFailures = 2
+)
+
+// Counts are the messages of a portal and how many of them missed each field
+type Counts struct {
+	Messages int            `json:"messages"`
+	Missing  map[string]int `json:"missing"`
+}
+
+// Function that tells if the field was extracted from almost every message of the counts
+func (c Counts) healthy(field string) bool {
+	return c.Messages >= minBaseline && float64(c.Missing[field]) <= maxBaselineMissing*float64(c.Messages)
+}
+
+// Stats are the extraction counters of a portal across every run
+type Stats struct {
+	Portal   string `json:"portal"`
+	Runs     int    `json:"runs"`
+	Messages int    `json:"messages"`
+	// Missing counts the messages where a field could not be extracted
+	Missing map[string]int `json:"missing"`
+	// Complete counts the messages where every field was extracted
+	Complete int  `json:"complete"`
+	Broken   bool `json:"broken"`
+	// BrokenFields are the fields that were healthy in the baseline and are missing since BrokenSince
+	BrokenFields []string  `json:"broken_fields,omitempty"`
+	BrokenSince  time.Time `json:"broken_since,omitempty"`
+	LastSeen     time.Time `json:"last_seen"`
+	// Baseline counts the messages of the runs where the portal was not broken, a run is compared to it
+	Baseline Counts `json:"baseline"`
+}
+
+// Rate returns the share of messages where every field was extracted
+func (s Stats) Rate() float64 {
+	if s.Messages == 0 {
+		return 1
+	}
+	return float64(s.Complete) / float64(s.Messages)
+}
+
+// Sample is a message whose extraction failed, kept to create a fixture from it
+type Sample struct {
+	Portal  string   `json:"portal"`
+	Subject string   `json:"subject"`
+	Missing []string `json:"missing"`
+	// File is relative to the samples directory
+	File string `json:"file"`
+}
+
+// Breakage is a portal whose emails started to lose fields
+type Breakage struct {
+	Portal   string         `json:"portal"`
+	Messages int            `json:"messages"`
+	Missing  map[string]int `json:"missing"`
+	// Rate is the success rate of the portal before this run
+	Rate float64 `json:"rate"`
+}
+
+// Tracker keeps the extraction stats of every portal in the store
+type Tracker struct {
+	mu    sync.Mutex
+	store *store.Store
+	stats map[string]*Stats
+}
+
+// SamplesDir is the directory inside the data directory where the samples are written
+const SamplesDir = "samples"
+
+// Open loads the tracker from the store
+func Open(s *store.Store) (*Tracker, error) {
+	var stats []*Stats
+	if err := s.Load(statsDocument, &stats); err != nil {
+		return nil, err
+	}
+
+	t := &Tracker{store: s, stats: map[string]*Stats{}}
+	for _, st := range stats {
+		// The stats from before the baseline start it with every message
+		if st.Baseline.Messages == 0 {
+			st.Baseline = Counts{Messages: st.Messages, Missing: map[string]int{}}
+			for f, n := range st.Missing {
+				st.Baseline.Missing[f] = n
+			}
+		}
+		t.stats[st.Portal] = st
+	}
+	return t, nil
+}
+
+// Missing returns the fields that could not be extracted from the email
+// the title comes from the snippet, the subject is the same for every email of a portal
+func Missing(e email.EmailTemplate) []string {
+	var missing []string
+	if e.Link == "" {
+		missing = append(missing, FieldLink)
+	}
+	if e.Price == 0 {
+		missing = append(missing, FieldPrice)
+	}
+	if e.Snippet == "" {
+		missing = append(missing, FieldTitle)
+	}
+	return missing
+}
+
+// Function that returns the name of the sample file of an email
+// the name depends on the body so the same email is only written once
+func sampleFile(e email.EmailTemplate) string {
+	sum := sha256.Sum256([]byte(e.Body))
+	portal := strings.Map(func(r rune) rune {
+		if r >= 'a' && r <= 'z' || r >= '0' && r <= '9' {
+			return r
+		}
+		return '-'
+	}, strings.ToLower(e.From))
+	return portal + "-" + hex.EncodeToString(sum[:6]) + ".html"
+}
+
+// Record adds the emails of a run to the stats
+// a portal breaks when at least half of its emails, and minFailures of them, miss a field that was healthy
+// in its baseline, so a field a portal never sends is not a breakage and neither is a single bad email
+// breakages are only returned when a healthy portal breaks so a broken portal alerts once
+// the first email of every failing portal is written to the samples directory
+func (t *Tracker) Record(emails []email.EmailTemplate, at time.Time) ([]Breakage, []Sample, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	type portalRun struct {
+		messages int
+		failed   int
+		missing  map[string]int
+	}
+	runs := map[string]*portalRun{}
+
+	var samples []Sample
+	for _, e := range emails {
+		pr, ok := runs[e.From]
+		if !ok {
+			pr = &portalRun{missing: map[string]int{}}
+			runs[e.From] = pr
+		}
+		pr.messages++
+
+		missing := Missing(e)
+		if len(missing) == 0 {
+			continue
+		}
+		for _, f := range missing {
+			pr.missing[f]++
+		}
+		pr.failed++
+
+		if pr.failed == 1 && e.Body != "" {
+			file := sampleFile(e)
+			if err := t.store.WriteFile(SamplesDir+"/"+file, []byte(e.Body)); err != nil {
+				return nil, nil, err
+			}
+			samples = append(samples, Sample{Portal: e.From, Subject: e.Subject, Missing: missing, File: file})
+		}
+	}
+
+	var breakages []Breakage
+	for portal, pr := range runs {
+		st, ok := t.stats[portal]
+		if !ok {
+			st = &Stats{Portal: portal, Missing: map[string]int{}, Baseline: Counts{Missing: map[string]int{}}}
+			t.stats[portal] = st
+		}
+		rate := st.Rate()
+
+		st.Runs++
+		st.Messages += pr.messages
+		st.Complete += pr.messages - pr.failed
+		for f, n := range pr.missing {
+			st.Missing[f] += n
+		}
+		st.LastSeen = at
+		metrics.ParseFailures.Add(float64(pr.failed), portal)
+
+		// A broken field stays broken while it is missing, any field breaks only from a healthy baseline
+		var broken []string
+		for _, f := range []string{FieldLink, FieldPrice, FieldTitle} {
+			failing := pr.missing[f] > 0 && pr.missing[f]*2 >= pr.messages
+			wasBroken := false
+			for _, b := range st.BrokenFields {
+				wasBroken = wasBroken || b == f
+			}
+			if failing && (wasBroken || pr.missing[f] >= minFailures && st.Baseline.healthy(f)) {
+				broken = append(broken, f)
+			}
+		}
+
+		if len(broken) > 0 && !st.Broken {
+			st.BrokenSince = at
+			breakages = append(breakages, Breakage{Portal: portal, Messages: pr.messages, Missing: pr.missing, Rate: rate})
+		}
+		if len(broken) == 0 {
+			st.BrokenSince = time.Time{}
+			st.Baseline.Messages += pr.messages
+			for f, n := range pr.missing {
+				st.Baseline.Missing[f] += n
+			}
+		}
+		st.Broken = len(broken) > 0
+		st.BrokenFields = broken
+	}
+	sort.Slice(breakages, func(i, j int) bool { return breakages[i].Portal < breakages[j].Portal })
+	sort.Slice(samples, func(i, j int) bool { return samples[i].Portal < samples[j].Portal })
+
+	return breakages, samples, t.save()
+}
+
+// must be called with t.mu held
+func (t *Tracker) save() error {
+	stats := make([]*Stats, 0, len(t.stats))
+	for _, st := range t.stats {
+		stats = append(stats, st)
+	}
+	sort.Slice(stats, func(i, j int) bool { return stats[i].Portal < stats[j].Portal })
+	return t.store.Save(statsDocument, stats)
+}
+
+// Stats returns a copy of the stats of every portal sorted by portal
+func (t *Tracker) Stats() []Stats {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	stats := make([]Stats, 0, len(t.stats))
+	for _, st := range t.stats {
+		c := *st
+		c.Missing = map[string]int{}
+		for f, n := range st.Missing {
+			c.Missing[f] = n
+		}
+		c.Baseline.Missing = map[string]int{}
+		for f, n := range st.Baseline.Missing {
+			c.Baseline.Missing[f] = n
+		}
+		c.BrokenFields = append([]string(nil), st.BrokenFields...)
+		stats = append(stats, c)
+	}
+	sort.Slice(stats, func(i, j int) bool { return stats[i].Portal < stats[j].Portal })
+	return stats
+}
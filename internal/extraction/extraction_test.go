@@ -0,0 +1,117 @@
+package extraction
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/BrunoTeixeira1996/gmah/internal/email"
+	"github.com/BrunoTeixeira1996/gmah/internal/store"
+)
+
+// Test the Record function
+func TestRecord(t *testing.T) {
+	st, err := store.Open(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	tracker, err := Open(st)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ok := email.EmailTemplate{From: "idealista", Subject: "Novo anúncio", Snippet: "Moradia T3", Link: "https://www.idealista.pt/imovel/1/", Price: 205000, Body: "<html>ok</html>"}
+	// What a redesigned email looks like to the parser
+	redesigned := email.EmailTemplate{From: "idealista", Subject: "Novo anúncio", Body: "<html>new layout</html>"}
+	many := func(n int, e email.EmailTemplate) []email.EmailTemplate {
+		var emails []email.EmailTemplate
+		for i := 0; i < n; i++ {
+			emails = append(emails, e)
+		}
+		return emails
+	}
+
+	tests := []struct {
+		name      string
+		emails    []email.EmailTemplate
+		breakages int
+		samples   int
+		broken    bool
+	}{
+		{"healthy", many(10, ok), 0, 0, false},
+		{"one bad email out of three", []email.EmailTemplate{ok, ok, redesigned}, 0, 1, false},
+		{"a single message run", []email.EmailTemplate{redesigned}, 0, 1, false},
+		{"redesign", []email.EmailTemplate{ok, redesigned, redesigned}, 1, 1, true},
+		{"still broken alerts once", []email.EmailTemplate{redesigned}, 0, 1, true},
+		{"fixed", []email.EmailTemplate{ok}, 0, 0, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			breakages, samples, err := tracker.Record(tt.emails, time.Now())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(breakages) != tt.breakages {
+				t.Errorf("expected %d breakages, got %+v", tt.breakages, breakages)
+			}
+			if len(samples) != tt.samples {
+				t.Fatalf("expected %d samples, got %+v", tt.samples, samples)
+			}
+			for _, s := range samples {
+				b, err := os.ReadFile(filepath.Join(st.Dir(), SamplesDir, s.File))
+				if err != nil || string(b) != redesigned.Body {
+					t.Errorf("expected the sample to hold the offending message, got %q (%v)", b, err)
+				}
+			}
+			if stats := tracker.Stats(); len(stats) != 1 || stats[0].Broken != tt.broken {
+				t.Errorf("expected broken to be %v, got %+v", tt.broken, stats)
+			}
+		})
+	}
+
+	// The stats survive a restart
+	tracker, err = Open(st)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stats := tracker.Stats()
+	if len(stats) != 1 || stats[0].Messages != 19 || stats[0].Complete != 14 || stats[0].Missing[FieldLink] != 5 || stats[0].Baseline.Messages != 15 {
+		t.Errorf("unexpected stats after reload: %+v", stats)
+	}
+}
+
+// Test that a field a portal never sends is not a breakage
+func TestRecordBaseline(t *testing.T) {
+	st, err := store.Open(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	tracker, err := Open(st)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Casa Sapo has no price in its emails from the first one
+	noPrice := email.EmailTemplate{From: "Casa Sapo", Subject: "Novos imóveis", Snippet: "Moradia T3", Link: "https://casa.sapo.pt/1"}
+	for i := 0; i < 5; i++ {
+		breakages, _, err := tracker.Record([]email.EmailTemplate{noPrice, noPrice, noPrice}, time.Now())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(breakages) != 0 {
+			t.Fatalf("expected no breakage in run %d, got %+v", i, breakages)
+		}
+	}
+
+	// Its link is healthy though, so losing it is a breakage
+	noPrice.Link = ""
+	breakages, _, err := tracker.Record([]email.EmailTemplate{noPrice, noPrice}, time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(breakages) != 1 || tracker.Stats()[0].BrokenFields[0] != FieldLink {
+		t.Errorf("expected the link to break, got %+v", tracker.Stats())
+	}
+}
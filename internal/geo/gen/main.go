@@ -0,0 +1,474 @@
+// Command gen writes the bundled gazetteer from the official map of the parishes of Portugal
+// (CAOP, Carta Administrativa Oficial de Portugal, published by the Direção-Geral do Território)
+//
+// Usage:
+//
+//	go run ./gen -o gazetteer.json -outlines ../mapview/outlines.geojson -unions unions.csv continente.geojson madeira.geojson=Madeira acores.geojson=Açores
+//
+// Every GeoJSON file is a CAOP layer of parishes converted to WGS84, for example with
+//
+//	ogr2ogr -f GeoJSON -t_srs EPSG:4326 continente.geojson Cont_Freg_CAOP2023.shp
+//
+// and file=name puts all the parishes of the file in the district name, for the autonomous regions.
+// unions.csv has the 2013 parish unions, one row per former parish: the DICOFRE of the union and the former name.
+// The coordinates are the area weighted centroids of the polygons, those of a municipality and of a district
+// come from the polygons of their parishes. The outlines of the municipalities for the map are the borders
+// of their parishes without the sides they share, simplified to OutlineTolerance.
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"math"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/BrunoTeixeira1996/gmah/internal/geo"
+)
+
+// Properties are the names of the CAOP fields with the code and the names of a parish
+type Properties struct {
+	Code         string
+	Parish       string
+	Municipality string
+	District     string
+}
+
+// Layer is a GeoJSON file of parishes, District replaces the district of every parish when it is set
+type Layer struct {
+	Path     string
+	District string
+}
+
+// parish is a parish of CAOP with the centroid and the area of its polygons
+type parish struct {
+	code         string
+	name         string
+	municipality string
+	district     string
+	lat, lon     float64
+	area         float64
+	// rings are the outlines and the holes of every polygon, for the outlines of the municipalities
+	rings [][][]float64
+}
+
+type featureCollection struct {
+	Features []struct {
+		Properties map[string]interface{} `json:"properties"`
+		Geometry   struct {
+			Type        string          `json:"type"`
+			Coordinates json.RawMessage `json:"coordinates"`
+		} `json:"geometry"`
+	} `json:"features"`
+}
+
+// Function that returns the area weighted centroid and the area of a ring, negative for a clockwise ring
+// the coordinates are lon and lat, the few km of a parish are flat enough for it
+func ring(points [][]float64) (lat, lon, area float64) {
+	var cx, cy float64
+	for i := range points {
+		if len(points[i]) < 2 {
+			continue
+		}
+		j := (i + 1) % len(points)
+		x0, y0 := points[i][0], points[i][1]
+		x1, y1 := points[j][0], points[j][1]
+		cross := x0*y1 - x1*y0
+		area += cross
+		cx += (x0 + x1) * cross
+		cy += (y0 + y1) * cross
+	}
+	area /= 2
+	if area == 0 {
+		return 0, 0, 0
+	}
+	return cy / (6 * area), cx / (6 * area), area
+}
+
+// Function that returns the polygons of a Polygon or MultiPolygon geometry
+func parsePolygons(kind string, coordinates json.RawMessage) ([][][][]float64, error) {
+	var polygons [][][][]float64
+	switch kind {
+	case "Polygon":
+		var polygon [][][]float64
+		if err := json.Unmarshal(coordinates, &polygon); err != nil {
+			return nil, err
+		}
+		polygons = [][][][]float64{polygon}
+	case "MultiPolygon":
+		if err := json.Unmarshal(coordinates, &polygons); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("unsupported geometry %q", kind)
+	}
+	return polygons, nil
+}
+
+// Function that returns the area weighted centroid and the area of a Polygon or MultiPolygon geometry
+func centroid(kind string, coordinates json.RawMessage) (lat, lon, area float64, err error) {
+	polygons, err := parsePolygons(kind, coordinates)
+	if err != nil {
+		return 0, 0, 0, err
+	}
+	return polygonsCentroid(polygons)
+}
+
+// Function that returns the area weighted centroid and the area of polygons
+// the first ring of a polygon is its outline and the others are holes
+func polygonsCentroid(polygons [][][][]float64) (lat, lon, area float64, err error) {
+	var sumLat, sumLon float64
+	for _, polygon := range polygons {
+		for i, points := range polygon {
+			rlat, rlon, rarea := ring(points)
+			rarea = math.Abs(rarea)
+			if i > 0 {
+				rarea = -rarea
+			}
+			sumLat += rlat * rarea
+			sumLon += rlon * rarea
+			area += rarea
+		}
+	}
+	if area <= 0 {
+		return 0, 0, 0, errors.New("geometry without area")
+	}
+	return sumLat / area, sumLon / area, area, nil
+}
+
+// Function that reads the parishes of a layer, the polygons with the same code are one parish
+func readLayer(r io.Reader, district string, props Properties) ([]parish, error) {
+	var fc featureCollection
+	if err := json.NewDecoder(r).Decode(&fc); err != nil {
+		return nil, err
+	}
+
+	byCode := map[string]*parish{}
+	var codes []string
+	for i, f := range fc.Features {
+		get := func(name string) string {
+			v, _ := f.Properties[name].(string)
+			return strings.TrimSpace(v)
+		}
+		code := get(props.Code)
+		if code == "" {
+			return nil, fmt.Errorf("feature %d has no %s", i, props.Code)
+		}
+		polygons, err := parsePolygons(f.Geometry.Type, f.Geometry.Coordinates)
+		if err != nil {
+			return nil, fmt.Errorf("parish %s: %w", code, err)
+		}
+		lat, lon, area, err := polygonsCentroid(polygons)
+		if err != nil {
+			return nil, fmt.Errorf("parish %s: %w", code, err)
+		}
+
+		p, ok := byCode[code]
+		if !ok {
+			p = &parish{code: code, name: parishName(get(props.Parish)), municipality: titleCase(get(props.Municipality)), district: titleCase(get(props.District))}
+			if district != "" {
+				p.district = district
+			}
+			byCode[code] = p
+			codes = append(codes, code)
+		}
+		// An island or an enclave is one more polygon of the same parish
+		total := p.area + area
+		p.lat = (p.lat*p.area + lat*area) / total
+		p.lon = (p.lon*p.area + lon*area) / total
+		p.area = total
+		for _, polygon := range polygons {
+			p.rings = append(p.rings, polygon...)
+		}
+	}
+
+	parishes := make([]parish, 0, len(codes))
+	for _, code := range codes {
+		parishes = append(parishes, *byCode[code])
+	}
+	return parishes, nil
+}
+
+// Function that reads the former parishes of every union, by the code of the union
+func readUnions(r io.Reader) (map[string][]string, error) {
+	records, err := csv.NewReader(r).ReadAll()
+	if err != nil {
+		return nil, err
+	}
+	unions := map[string][]string{}
+	for i, rec := range records {
+		if len(rec) < 2 {
+			return nil, fmt.Errorf("line %d: expected the code of the union and the former name", i+1)
+		}
+		code, name := strings.TrimSpace(rec[0]), strings.TrimSpace(rec[1])
+		// The header
+		if i == 0 && strings.EqualFold(code, "dicofre") {
+			continue
+		}
+		unions[code] = append(unions[code], name)
+	}
+	return unions, nil
+}
+
+// Words that stay in lower case inside a name
+var particles = map[string]bool{"a": true, "as": true, "o": true, "os": true, "e": true, "de": true, "da": true, "das": true, "do": true, "dos": true}
+
+// Function that turns a name written in capitals, like CAOP writes the districts and the municipalities,
+// into the usual case: "VILA NOVA DE GAIA" is "Vila Nova de Gaia" and "ALBERGARIA-A-VELHA" is "Albergaria-a-Velha"
+func titleCase(s string) string {
+	if s != strings.ToUpper(s) {
+		return s
+	}
+	words := strings.FieldsFunc(strings.ToLower(s), func(r rune) bool { return r == ' ' })
+	for i, w := range words {
+		parts := strings.Split(w, "-")
+		for j, p := range parts {
+			if p == "" || ((i > 0 || j > 0) && particles[p]) {
+				continue
+			}
+			r := []rune(p)
+			parts[j] = strings.ToUpper(string(r[0])) + string(r[1:])
+		}
+		words[i] = strings.Join(parts, "-")
+	}
+	return strings.Join(words, " ")
+}
+
+// Function that returns the name a parish is known by, without the "União das freguesias de" of the unions
+func parishName(s string) string {
+	s = titleCase(s)
+	lower := strings.ToLower(s)
+	for _, prefix := range []string{"união das freguesias de ", "união das freguesias do ", "união das freguesias da "} {
+		if strings.HasPrefix(lower, prefix) {
+			return strings.TrimSpace(s[len(prefix):])
+		}
+	}
+	return s
+}
+
+// Function that returns the key names are sorted by
+func sortKey(s string) string {
+	return geo.Normalize(s)
+}
+
+// Build groups the parishes in their municipalities and districts, with the former names of the unions
+// the centroid of a municipality or a district is the area weighted centroid of its parishes
+func build(parishes []parish, unions map[string][]string) ([]geo.District, error) {
+	type weighted struct {
+		lat, lon, area float64
+	}
+	add := func(w *weighted, p parish) {
+		total := w.area + p.area
+		w.lat = (w.lat*w.area + p.lat*p.area) / total
+		w.lon = (w.lon*w.area + p.lon*p.area) / total
+		w.area = total
+	}
+
+	var (
+		districts      []geo.District
+		districtIndex  = map[string]int{}
+		municipalIndex = map[string]int{}
+		districtW      = map[string]*weighted{}
+		municipalW     = map[string]*weighted{}
+		used           = map[string]bool{}
+	)
+	for _, p := range parishes {
+		if p.district == "" || p.municipality == "" || p.name == "" {
+			return nil, fmt.Errorf("parish %s is missing its name, municipality or district", p.code)
+		}
+		di, ok := districtIndex[p.district]
+		if !ok {
+			di = len(districts)
+			districts = append(districts, geo.District{Name: p.district})
+			districtIndex[p.district] = di
+			districtW[p.district] = &weighted{}
+		}
+		mkey := p.district + "/" + p.municipality
+		mi, ok := municipalIndex[mkey]
+		if !ok {
+			mi = len(districts[di].Municipalities)
+			districts[di].Municipalities = append(districts[di].Municipalities, geo.Municipality{Name: p.municipality})
+			municipalIndex[mkey] = mi
+			municipalW[mkey] = &weighted{}
+		}
+		add(districtW[p.district], p)
+		add(municipalW[mkey], p)
+
+		former := unions[p.code]
+		used[p.code] = former != nil
+		districts[di].Municipalities[mi].Parishes = append(districts[di].Municipalities[mi].Parishes, geo.Parish{
+			Name:   p.name,
+			Former: former,
+			Lat:    round(p.lat),
+			Lon:    round(p.lon),
+		})
+	}
+
+	// A union that is not in the map means the two files are not of the same edition
+	for code := range unions {
+		if !used[code] {
+			return nil, fmt.Errorf("union %s is not a parish of the map", code)
+		}
+	}
+
+	for di := range districts {
+		d := &districts[di]
+		w := districtW[d.Name]
+		d.Lat, d.Lon = round(w.lat), round(w.lon)
+		for mi := range d.Municipalities {
+			m := &d.Municipalities[mi]
+			w := municipalW[d.Name+"/"+m.Name]
+			m.Lat, m.Lon = round(w.lat), round(w.lon)
+			sort.Slice(m.Parishes, func(i, j int) bool { return sortKey(m.Parishes[i].Name) < sortKey(m.Parishes[j].Name) })
+		}
+		sort.Slice(d.Municipalities, func(i, j int) bool { return sortKey(d.Municipalities[i].Name) < sortKey(d.Municipalities[j].Name) })
+	}
+	sort.SliceStable(districts, func(i, j int) bool { return sortKey(districts[i].Name) < sortKey(districts[j].Name) })
+	return districts, nil
+}
+
+// Function that rounds a coordinate to 4 decimals, about 10 m
+func round(v float64) float64 {
+	return math.Round(v*1e4) / 1e4
+}
+
+// Function that writes the districts with a parish per line, so the changes of a new edition are easy to review
+func write(w io.Writer, districts []geo.District) error {
+	line := func(indent string, v interface{}, open string) string {
+		b, _ := json.Marshal(v)
+		s := string(b)
+		if open != "" {
+			s = strings.TrimSuffix(s, "}") + `,"` + open + `":[`
+		}
+		return indent + s
+	}
+	type header struct {
+		Name string  `json:"name"`
+		Lat  float64 `json:"lat"`
+		Lon  float64 `json:"lon"`
+	}
+
+	var b strings.Builder
+	b.WriteString("[\n")
+	for di, d := range districts {
+		b.WriteString(line("", header{d.Name, d.Lat, d.Lon}, "municipalities") + "\n")
+		for mi, m := range d.Municipalities {
+			b.WriteString(line(" ", header{m.Name, m.Lat, m.Lon}, "parishes") + "\n")
+			for pi, p := range m.Parishes {
+				b.WriteString(line("  ", p, ""))
+				if pi < len(m.Parishes)-1 {
+					b.WriteString(",")
+				}
+				b.WriteString("\n")
+			}
+			b.WriteString(" ]}")
+			if mi < len(d.Municipalities)-1 {
+				b.WriteString(",")
+			}
+			b.WriteString("\n")
+		}
+		b.WriteString("]}")
+		if di < len(districts)-1 {
+			b.WriteString(",")
+		}
+		b.WriteString("\n")
+	}
+	b.WriteString("]\n")
+
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// Function that reads the layers and the unions and writes the gazetteer to output,
+// and the outlines of the municipalities to outlinesPath when it is set
+func generate(output, outlinesPath, unionsPath string, layers []Layer, props Properties) error {
+	unions := map[string][]string{}
+	if unionsPath != "" {
+		f, err := os.Open(unionsPath)
+		if err != nil {
+			return err
+		}
+		unions, err = readUnions(f)
+		f.Close()
+		if err != nil {
+			return fmt.Errorf("%s: %w", unionsPath, err)
+		}
+	}
+
+	var parishes []parish
+	for _, l := range layers {
+		f, err := os.Open(l.Path)
+		if err != nil {
+			return err
+		}
+		ps, err := readLayer(f, l.District, props)
+		f.Close()
+		if err != nil {
+			return fmt.Errorf("%s: %w", l.Path, err)
+		}
+		slog.Info("Read layer", "path", l.Path, "parishes", len(ps))
+		parishes = append(parishes, ps...)
+	}
+
+	districts, err := build(parishes, unions)
+	if err != nil {
+		return err
+	}
+	if err := writeFile(output, func(w io.Writer) error { return write(w, districts) }); err != nil {
+		return err
+	}
+	if outlinesPath == "" {
+		return nil
+	}
+	return writeFile(outlinesPath, func(w io.Writer) error { return writeOutlines(w, outlines(parishes, OutlineTolerance)) })
+}
+
+// Function that writes a file next to path and renames it, so a failure leaves the old file
+func writeFile(path string, write func(io.Writer) error) error {
+	tmp := path + ".tmp"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	if err := write(f); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+func main() {
+	output := flag.String("o", "gazetteer.json", "file the gazetteer is written to")
+	outlinesPath := flag.String("outlines", "", "file the GeoJSON outlines of the municipalities are written to")
+	unions := flag.String("unions", "", "CSV with the DICOFRE of every 2013 union and the name of each former parish")
+	var props Properties
+	flag.StringVar(&props.Code, "code", "DICOFRE", "property with the code of the parish")
+	flag.StringVar(&props.Parish, "parish", "Freguesia", "property with the name of the parish")
+	flag.StringVar(&props.Municipality, "municipality", "Concelho", "property with the name of the municipality")
+	flag.StringVar(&props.District, "district", "Distrito", "property with the name of the district")
+	flag.Parse()
+
+	if flag.NArg() == 0 {
+		slog.Error("Give at least one GeoJSON file of parishes")
+		os.Exit(2)
+	}
+	var layers []Layer
+	for _, arg := range flag.Args() {
+		path, district, _ := strings.Cut(arg, "=")
+		layers = append(layers, Layer{Path: path, District: district})
+	}
+
+	if err := generate(*output, *outlinesPath, *unions, layers, props); err != nil {
+		slog.Error("Error while generating the gazetteer", "err", err)
+		os.Exit(1)
+	}
+}
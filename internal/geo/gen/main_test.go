@@ -0,0 +1,198 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/BrunoTeixeira1996/gmah/internal/geo"
+	"github.com/BrunoTeixeira1996/gmah/internal/mapview"
+)
+
+// Test the centroid function
+func TestCentroid(t *testing.T) {
+	tests := []struct {
+		name     string
+		kind     string
+		coords   string
+		wantLat  float64
+		wantLon  float64
+		wantArea float64
+	}{
+		{"square", "Polygon", `[[[0,0],[2,0],[2,2],[0,2],[0,0]]]`, 1, 1, 4},
+		{"clockwise square", "Polygon", `[[[0,0],[0,2],[2,2],[2,0],[0,0]]]`, 1, 1, 4},
+		// The hole in the right half moves the centroid to the left
+		{"square with a hole", "Polygon", `[[[0,0],[4,0],[4,4],[0,4],[0,0]],[[2,0],[4,0],[4,4],[2,4],[2,0]]]`, 2, 1, 8},
+		// The big polygon weighs 4 times the small one
+		{"parish and its island", "MultiPolygon", `[[[[0,0],[2,0],[2,2],[0,2],[0,0]]],[[[10,0],[11,0],[11,1],[10,1],[10,0]]]]`, 0.9, 2.9, 5},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			lat, lon, area, err := centroid(tt.kind, json.RawMessage(tt.coords))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if math.Abs(lat-tt.wantLat) > 1e-9 || math.Abs(lon-tt.wantLon) > 1e-9 || math.Abs(area-tt.wantArea) > 1e-9 {
+				t.Errorf("expected %v %v with area %v, got %v %v with area %v", tt.wantLat, tt.wantLon, tt.wantArea, lat, lon, area)
+			}
+		})
+	}
+
+	if _, _, _, err := centroid("Point", json.RawMessage(`[0,0]`)); err == nil {
+		t.Error("expected an error for a point")
+	}
+}
+
+// Test the titleCase and parishName functions
+func TestNames(t *testing.T) {
+	tests := []struct {
+		in   string
+		want string
+	}{
+		{"VILA NOVA DE GAIA", "Vila Nova de Gaia"},
+		{"ALBERGARIA-A-VELHA", "Albergaria-a-Velha"},
+		{"ÍLHAVO", "Ílhavo"},
+		{"São João da Madeira", "São João da Madeira"},
+		{"União das freguesias de Glória e Vera Cruz", "Glória e Vera Cruz"},
+	}
+
+	for _, tt := range tests {
+		if got := parishName(tt.in); got != tt.want {
+			t.Errorf("expected %q, got %q", tt.want, got)
+		}
+	}
+}
+
+// Test the generate function with two small layers
+func TestGenerate(t *testing.T) {
+	dir := t.TempDir()
+	square := func(x, y float64) string {
+		b, _ := json.Marshal([][][]float64{{{x, y}, {x + 0.1, y}, {x + 0.1, y + 0.1}, {x, y + 0.1}, {x, y}}})
+		return string(b)
+	}
+	feature := func(code, parish, municipality, district, coords string) string {
+		return `{"type":"Feature","properties":{"DICOFRE":"` + code + `","Freguesia":"` + parish + `","Concelho":"` + municipality + `","Distrito":"` + district + `"},` +
+			`"geometry":{"type":"Polygon","coordinates":` + coords + `}}`
+	}
+	files := map[string]string{
+		"continente.geojson": `{"type":"FeatureCollection","features":[` +
+			feature("010103", "União das freguesias de Glória e Vera Cruz", "AVEIRO", "AVEIRO", square(-8.7, 40.6)) + `,` +
+			feature("010109", "Esgueira", "AVEIRO", "AVEIRO", square(-8.6, 40.6)) + `,` +
+			feature("011001", "Gafanha da Nazaré", "ÍLHAVO", "AVEIRO", square(-8.7, 40.5)) + `]}`,
+		"madeira.geojson": `{"type":"FeatureCollection","features":[` +
+			feature("310101", "Sé", "FUNCHAL", "", square(-16.9, 32.6)) + `]}`,
+		"unions.csv": "dicofre,former\n010103,Glória\n010103,Vera Cruz\n",
+	}
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	output := filepath.Join(dir, "gazetteer.json")
+	layers := []Layer{{Path: filepath.Join(dir, "continente.geojson")}, {Path: filepath.Join(dir, "madeira.geojson"), District: "Madeira"}}
+	props := Properties{Code: "DICOFRE", Parish: "Freguesia", Municipality: "Concelho", District: "Distrito"}
+	if err := generate(output, "", filepath.Join(dir, "unions.csv"), layers, props); err != nil {
+		t.Fatal(err)
+	}
+
+	b, err := os.ReadFile(output)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var districts []geo.District
+	if err := json.Unmarshal(b, &districts); err != nil {
+		t.Fatalf("expected the gazetteer format, got %v:\n%s", err, b)
+	}
+	if len(districts) != 2 || districts[0].Name != "Aveiro" || districts[1].Name != "Madeira" {
+		t.Fatalf("expected Aveiro and Madeira, got %+v", districts)
+	}
+	aveiro := districts[0]
+	if len(aveiro.Municipalities) != 2 || aveiro.Municipalities[0].Name != "Aveiro" || aveiro.Municipalities[1].Name != "Ílhavo" {
+		t.Fatalf("expected the municipalities Aveiro and Ílhavo, got %+v", aveiro.Municipalities)
+	}
+	// The municipality is between its two parishes
+	if m := aveiro.Municipalities[0]; m.Lat != 40.65 || m.Lon != -8.6 {
+		t.Errorf("expected the centroid of Aveiro at 40.65 -8.6, got %v %v", m.Lat, m.Lon)
+	}
+
+	g := geo.New(districts)
+	for location, wantID := range map[string]string{
+		"Vera Cruz, Aveiro":  "aveiro/aveiro/gloria-e-vera-cruz",
+		"Gafanha da Nazaré":  "aveiro/ilhavo/gafanha-da-nazare",
+		"Sé, Funchal":        "madeira/funchal/se",
+		"Esgueira":           "aveiro/aveiro/esgueira",
+		"Glria e Vera Cruz":  "aveiro/aveiro/gloria-e-vera-cruz",
+		"Albergaria-a-Velha": "",
+	} {
+		p, ok := g.Find(location)
+		if ok != (wantID != "") || p.ID != wantID {
+			t.Errorf("expected %q to be %q, got %q (%v)", location, wantID, p.ID, ok)
+		}
+	}
+
+	// A union that is not on the map is a mistake in the files
+	if err := os.WriteFile(filepath.Join(dir, "unions.csv"), []byte("dicofre,former\n019999,Nowhere\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := generate(output, "", filepath.Join(dir, "unions.csv"), layers, props); err == nil || !strings.Contains(err.Error(), "019999") {
+		t.Errorf("expected an error about the unknown union, got %v", err)
+	}
+}
+
+// Test the outlines function with two parishes that share a side and a parish with a hole
+func TestOutlines(t *testing.T) {
+	square := func(x, y, side float64) [][]float64 {
+		return [][]float64{{x, y}, {x + side, y}, {x + side, y + side}, {x, y + side}, {x, y}}
+	}
+	// Esgueira goes the other way round
+	esgueira := [][]float64{{-8.6, 40.6}, {-8.6, 40.7}, {-8.5, 40.7}, {-8.5, 40.6}, {-8.6, 40.6}}
+	parishes := []parish{
+		{code: "010103", municipality: "Aveiro", district: "Aveiro", rings: [][][]float64{square(-8.7, 40.6, 0.1)}},
+		{code: "010109", municipality: "Aveiro", district: "Aveiro", rings: [][][]float64{esgueira}},
+		{code: "011001", municipality: "Ílhavo", district: "Aveiro", rings: [][][]float64{square(-8.8, 40.4, 0.2), square(-8.75, 40.45, 0.05)}},
+	}
+	got := outlines(parishes, OutlineTolerance)
+	if len(got) != 2 || got[0].name != "Aveiro" || got[1].name != "Ílhavo" {
+		t.Fatalf("expected Aveiro and Ílhavo, got %+v", got)
+	}
+	if len(got[0].rings) != 1 || len(got[0].rings[0]) != 5 {
+		t.Errorf("expected a single rectangle for Aveiro, got %v", got[0].rings)
+	}
+	if len(got[1].rings) != 2 {
+		t.Errorf("expected the outline and the hole of Ílhavo, got %v", got[1].rings)
+	}
+
+	var b bytes.Buffer
+	if err := writeOutlines(&b, got); err != nil {
+		t.Fatal(err)
+	}
+	shapes, err := mapview.ParseOutlines(b.Bytes())
+	if err != nil || len(shapes) != 2 || shapes[0].Name != "Aveiro" || len(shapes[1].Rings) != 2 {
+		t.Errorf("expected the outlines the map reads, got %+v (%v):\n%s", shapes, err, b.String())
+	}
+}
+
+// Test that the bundled gazetteer has the format the generator writes
+func TestWrite(t *testing.T) {
+	districts, err := geo.Bundled()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var b bytes.Buffer
+	if err := write(&b, districts); err != nil {
+		t.Fatal(err)
+	}
+	var again []geo.District
+	if err := json.Unmarshal(b.Bytes(), &again); err != nil {
+		t.Fatal(err)
+	}
+	if len(again) != len(districts) || len(geo.New(again).Places()) != len(geo.New(districts).Places()) {
+		t.Errorf("expected the same places after writing them")
+	}
+}
@@ -0,0 +1,207 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"math"
+	"sort"
+	"strings"
+)
+
+// OutlineTolerance is how far in degrees, about 200 m, the simplified outlines can be from the border,
+// less than a pixel of the map of a whole district
+const OutlineTolerance = 0.002
+
+// outline is the border of a municipality, the rings of the parishes without the sides they share
+type outline struct {
+	name     string
+	district string
+	rings    [][][2]float64
+}
+
+// segment is a side of a ring
+type segment struct {
+	a, b [2]float64
+}
+
+// Function that returns the point of a GeoJSON position, rounded so the same vertex of two parishes is equal
+func point(pos []float64) [2]float64 {
+	return [2]float64{math.Round(pos[0]*1e7) / 1e7, math.Round(pos[1]*1e7) / 1e7}
+}
+
+// Function that returns the outlines of the municipalities, simplified so no point is further than tolerance
+// degrees from the border. CAOP draws the shared border of two parishes with the same vertices, so the sides
+// found twice in a municipality are inside it and the others are its border
+func outlines(parishes []parish, tolerance float64) []outline {
+	type municipality struct {
+		outline
+		count map[segment]int
+		sides []segment
+	}
+	byKey := map[string]*municipality{}
+	var keys []string
+	for _, p := range parishes {
+		key := p.district + "/" + p.municipality
+		m, ok := byKey[key]
+		if !ok {
+			m = &municipality{outline: outline{name: p.municipality, district: p.district}, count: map[segment]int{}}
+			byKey[key] = m
+			keys = append(keys, key)
+		}
+		for _, r := range p.rings {
+			for i := 0; i+1 < len(r); i++ {
+				if len(r[i]) < 2 || len(r[i+1]) < 2 {
+					continue
+				}
+				s := segment{point(r[i]), point(r[i+1])}
+				if s.a == s.b {
+					continue
+				}
+				// The same side in either direction
+				if s.b[0] < s.a[0] || (s.b[0] == s.a[0] && s.b[1] < s.a[1]) {
+					s.a, s.b = s.b, s.a
+				}
+				if m.count[s] == 0 {
+					m.sides = append(m.sides, s)
+				}
+				m.count[s]++
+			}
+		}
+	}
+
+	sort.Slice(keys, func(i, j int) bool { return sortKey(keys[i]) < sortKey(keys[j]) })
+	var result []outline
+	for _, key := range keys {
+		m := byKey[key]
+		var border []segment
+		for _, s := range m.sides {
+			if m.count[s] == 1 {
+				border = append(border, s)
+			}
+		}
+		for _, r := range chain(border) {
+			if r = simplify(r, tolerance); len(r) >= 4 {
+				m.rings = append(m.rings, r)
+			}
+		}
+		if len(m.rings) > 0 {
+			result = append(result, m.outline)
+		}
+	}
+	return result
+}
+
+// Function that joins the sides into closed rings, a vertex shared by more than two sides
+// is left by any of the sides not walked yet
+func chain(sides []segment) [][][2]float64 {
+	at := map[[2]float64][]int{}
+	for i, s := range sides {
+		at[s.a] = append(at[s.a], i)
+		at[s.b] = append(at[s.b], i)
+	}
+	used := make([]bool, len(sides))
+	next := func(p [2]float64) (int, bool) {
+		for _, i := range at[p] {
+			if !used[i] {
+				return i, true
+			}
+		}
+		return 0, false
+	}
+
+	var rings [][][2]float64
+	for i := range sides {
+		if used[i] {
+			continue
+		}
+		used[i] = true
+		start := sides[i].a
+		r := [][2]float64{start, sides[i].b}
+		for r[len(r)-1] != start {
+			j, ok := next(r[len(r)-1])
+			if !ok {
+				break
+			}
+			used[j] = true
+			if sides[j].a == r[len(r)-1] {
+				r = append(r, sides[j].b)
+			} else {
+				r = append(r, sides[j].a)
+			}
+		}
+		if r[len(r)-1] == start {
+			rings = append(rings, r)
+		}
+	}
+	return rings
+}
+
+// Function that simplifies a line with the Douglas-Peucker algorithm, the first and the last points are kept
+func simplify(line [][2]float64, tolerance float64) [][2]float64 {
+	if len(line) < 3 {
+		return line
+	}
+	a, b := line[0], line[len(line)-1]
+	far, index := -1.0, 0
+	for i := 1; i < len(line)-1; i++ {
+		if d := distance(line[i], a, b); d > far {
+			far, index = d, i
+		}
+	}
+	if far <= tolerance {
+		return [][2]float64{a, b}
+	}
+	left := simplify(line[:index+1], tolerance)
+	right := simplify(line[index:], tolerance)
+	return append(left[:len(left)-1], right...)
+}
+
+// Function that returns the distance from p to the segment from a to b
+func distance(p, a, b [2]float64) float64 {
+	dx, dy := b[0]-a[0], b[1]-a[1]
+	if dx == 0 && dy == 0 {
+		return math.Hypot(p[0]-a[0], p[1]-a[1])
+	}
+	t := math.Max(0, math.Min(1, ((p[0]-a[0])*dx+(p[1]-a[1])*dy)/(dx*dx+dy*dy)))
+	return math.Hypot(p[0]-a[0]-t*dx, p[1]-a[1]-t*dy)
+}
+
+// Function that writes the outlines as a GeoJSON FeatureCollection with a municipality per line,
+// every ring is a polygon of a MultiPolygon and the name of a feature is its municipality
+func writeOutlines(w io.Writer, outlines []outline) error {
+	type geometry struct {
+		Type        string           `json:"type"`
+		Coordinates [][][][2]float64 `json:"coordinates"`
+	}
+	type feature struct {
+		Type       string            `json:"type"`
+		Properties map[string]string `json:"properties"`
+		Geometry   geometry          `json:"geometry"`
+	}
+
+	var b strings.Builder
+	b.WriteString(`{"type":"FeatureCollection","features":[` + "\n")
+	for i, o := range outlines {
+		f := feature{Type: "Feature", Properties: map[string]string{"name": o.name, "district": o.district}, Geometry: geometry{Type: "MultiPolygon"}}
+		for _, r := range o.rings {
+			ring := make([][2]float64, len(r))
+			for j, p := range r {
+				ring[j] = [2]float64{round(p[0]), round(p[1])}
+			}
+			f.Geometry.Coordinates = append(f.Geometry.Coordinates, [][][2]float64{ring})
+		}
+		line, err := json.Marshal(f)
+		if err != nil {
+			return err
+		}
+		b.Write(line)
+		if i < len(outlines)-1 {
+			b.WriteString(",")
+		}
+		b.WriteString("\n")
+	}
+	b.WriteString("]}\n")
+
+	_, err := io.WriteString(w, b.String())
+	return err
+}
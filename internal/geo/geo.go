@@ -0,0 +1,348 @@
+package geo
+
+import (
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"math"
+	"regexp"
+	"sort"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/BrunoTeixeira1996/gmah/internal/store"
+)
+
+// Document is the name of the store document that adds to or corrects the bundled gazetteer,
+// it has the same format as gazetteer.json
+const Document = "gazetteer"
+
+// The bundled gazetteer and the outlines of the map are written by gen from the CAOP parishes and the 2013 unions
+// in gen/data, see the README
+// until it is generated it only has every district, the municipalities of the Aveiro district and the parishes
+// of Aveiro and Ílhavo, with the coordinates of their seats
+//
+//go:generate go run ./gen -o gazetteer.json -outlines ../mapview/outlines.geojson -unions gen/data/unions.csv gen/data/continente.geojson gen/data/madeira.geojson=Madeira gen/data/acores.geojson=Açores
+//go:embed gazetteer.json
+var bundled []byte
+
+// Parish is a freguesia, Former has the names of the parishes it was before the 2013 unions
+type Parish struct {
+	Name   string   `json:"name"`
+	Former []string `json:"former,omitempty"`
+	Lat    float64  `json:"lat"`
+	Lon    float64  `json:"lon"`
+}
+
+// Municipality is a concelho and its parishes
+type Municipality struct {
+	Name     string   `json:"name"`
+	Lat      float64  `json:"lat"`
+	Lon      float64  `json:"lon"`
+	Parishes []Parish `json:"parishes,omitempty"`
+}
+
+// District is a distrito, or an autonomous region, and its municipalities
+type District struct {
+	Name           string         `json:"name"`
+	Lat            float64        `json:"lat"`
+	Lon            float64        `json:"lon"`
+	Municipalities []Municipality `json:"municipalities,omitempty"`
+}
+
+// Place is a location found in the gazetteer, the parish or the municipality are empty
+// when the location is not that precise
+type Place struct {
+	// ID is district/municipality/parish in lower case without accents, like aveiro/aveiro/esgueira
+	ID           string  `json:"id"`
+	District     string  `json:"district"`
+	Municipality string  `json:"municipality,omitempty"`
+	Parish       string  `json:"parish,omitempty"`
+	Lat          float64 `json:"lat"`
+	Lon          float64 `json:"lon"`
+}
+
+// Function that returns 1 for a district, 2 for a municipality and 3 for a parish
+func (p Place) level() int {
+	switch {
+	case p.Parish != "":
+		return 3
+	case p.Municipality != "":
+		return 2
+	}
+	return 1
+}
+
+// Gazetteer finds the places named in the locations of the listings
+type Gazetteer struct {
+	places []Place
+	// names has the places of every normalized name, old parish names included
+	names map[string][]int
+}
+
+var (
+	accents = strings.NewReplacer(
+		"á", "a", "à", "a", "â", "a", "ã", "a", "é", "e", "è", "e", "ê", "e", "í", "i",
+		"ó", "o", "ò", "o", "ô", "o", "õ", "o", "ú", "u", "ü", "u", "ç", "c",
+	)
+	nonWordRegex = regexp.MustCompile(`[^a-z0-9]+`)
+)
+
+// Normalize lowers the case, removes the accents and turns the punctuation into spaces,
+// "Glória e Vera Cruz, Aveiro" is "gloria e vera cruz aveiro"
+func Normalize(s string) string {
+	return strings.TrimSpace(nonWordRegex.ReplaceAllString(accents.Replace(strings.ToLower(s)), " "))
+}
+
+// Function that normalizes a name the way the email snippets lose their accented letters,
+// "Glória" is "glria"
+func stripped(s string) string {
+	var b strings.Builder
+	for _, r := range strings.ToLower(s) {
+		if r < utf8.RuneSelf {
+			b.WriteRune(r)
+		}
+	}
+	return strings.TrimSpace(nonWordRegex.ReplaceAllString(b.String(), " "))
+}
+
+// Function that turns a name into its part of a place ID
+func slug(s string) string {
+	return strings.ReplaceAll(Normalize(s), " ", "-")
+}
+
+// New returns a gazetteer of the districts
+func New(districts []District) *Gazetteer {
+	g := &Gazetteer{names: map[string][]int{}}
+	add := func(p Place, names ...string) {
+		g.places = append(g.places, p)
+		for _, n := range names {
+			for _, key := range []string{Normalize(n), stripped(n)} {
+				ids := g.names[key]
+				if key != "" && (len(ids) == 0 || ids[len(ids)-1] != len(g.places)-1) {
+					g.names[key] = append(ids, len(g.places)-1)
+				}
+			}
+		}
+	}
+
+	for _, d := range districts {
+		add(Place{ID: slug(d.Name), District: d.Name, Lat: d.Lat, Lon: d.Lon}, d.Name)
+		for _, m := range d.Municipalities {
+			mid := slug(d.Name) + "/" + slug(m.Name)
+			add(Place{ID: mid, District: d.Name, Municipality: m.Name, Lat: m.Lat, Lon: m.Lon}, m.Name)
+			for _, p := range m.Parishes {
+				place := Place{ID: mid + "/" + slug(p.Name), District: d.Name, Municipality: m.Name, Parish: p.Name, Lat: p.Lat, Lon: p.Lon}
+				add(place, append([]string{p.Name}, p.Former...)...)
+			}
+		}
+	}
+	return g
+}
+
+// Places returns every district, municipality and parish of the gazetteer
+func (g *Gazetteer) Places() []Place {
+	return append([]Place(nil), g.places...)
+}
+
+// Unmapped returns the districts without any municipality, where only the district of a listing is found
+func (g *Gazetteer) Unmapped() []string {
+	mapped := map[string]bool{}
+	for _, p := range g.places {
+		if p.level() > 1 {
+			mapped[p.District] = true
+		}
+	}
+	var districts []string
+	for _, p := range g.places {
+		if p.level() == 1 && !mapped[p.District] {
+			districts = append(districts, p.District)
+		}
+	}
+	return districts
+}
+
+// Bundled returns the districts of the gazetteer built into gmah
+func Bundled() ([]District, error) {
+	var districts []District
+	err := json.Unmarshal(bundled, &districts)
+	return districts, err
+}
+
+// Load returns the bundled gazetteer with the gazetteer document of the store on top
+func Load(s *store.Store) (*Gazetteer, error) {
+	districts, err := Bundled()
+	if err != nil {
+		return nil, err
+	}
+	var extra []District
+	if err := s.Load(Document, &extra); err != nil {
+		return nil, err
+	}
+	return New(Merge(districts, extra)), nil
+}
+
+// Merge adds the districts, municipalities and parishes of extra to base
+// the ones with the same name are replaced, keeping what extra leaves empty
+func Merge(base, extra []District) []District {
+	for _, d := range extra {
+		i := indexOf(len(base), func(i int) string { return base[i].Name }, d.Name)
+		if i < 0 {
+			base = append(base, d)
+			continue
+		}
+		if d.Lat != 0 || d.Lon != 0 {
+			base[i].Lat, base[i].Lon = d.Lat, d.Lon
+		}
+		munis := base[i].Municipalities
+		for _, m := range d.Municipalities {
+			j := indexOf(len(munis), func(j int) string { return munis[j].Name }, m.Name)
+			if j < 0 {
+				munis = append(munis, m)
+				continue
+			}
+			if m.Lat != 0 || m.Lon != 0 {
+				munis[j].Lat, munis[j].Lon = m.Lat, m.Lon
+			}
+			for _, p := range m.Parishes {
+				k := indexOf(len(munis[j].Parishes), func(k int) string { return munis[j].Parishes[k].Name }, p.Name)
+				if k < 0 {
+					munis[j].Parishes = append(munis[j].Parishes, p)
+				} else {
+					munis[j].Parishes[k] = p
+				}
+			}
+		}
+		base[i].Municipalities = munis
+	}
+	return base
+}
+
+// Function that returns the index of the first of n names equal to name, -1 when there is none
+func indexOf(n int, nameAt func(int) string, name string) int {
+	for i := 0; i < n; i++ {
+		if Normalize(nameAt(i)) == Normalize(name) {
+			return i
+		}
+	}
+	return -1
+}
+
+// match is a name found in a location, from start to end in the normalized location
+type match struct {
+	place      int
+	start, end int
+}
+
+// Find returns the most precise place named in a location
+// every name found counts, so "Esgueira, Aveiro" and "praceta do Carmo Esgueira Aveiro" are both the parish of Esgueira,
+// and a name inside a longer one, like Madeira in São João da Madeira, is ignored
+// a name of several places with nothing else to tell them apart is not found
+func (g *Gazetteer) Find(location string) (Place, bool) {
+	padded := " " + Normalize(location) + " "
+
+	var matches []match
+	for key, places := range g.names {
+		from := 0
+		for {
+			i := strings.Index(padded[from:], " "+key+" ")
+			if i < 0 {
+				break
+			}
+			start := from + i + 1
+			for _, p := range places {
+				matches = append(matches, match{place: p, start: start, end: start + len(key)})
+			}
+			from = start
+		}
+	}
+
+	var kept []match
+	for _, m := range matches {
+		inside := false
+		for _, o := range matches {
+			if o.start <= m.start && m.end <= o.end && o.end-o.start > m.end-m.start {
+				inside = true
+				break
+			}
+		}
+		if !inside {
+			kept = append(kept, m)
+		}
+	}
+
+	// The places whose district or municipality were also named come first, then the most precise
+	score := map[int]int{}
+	for _, m := range kept {
+		p := g.places[m.place]
+		s := p.level()
+		for _, o := range kept {
+			if strings.HasPrefix(p.ID, g.places[o.place].ID+"/") {
+				s += 10
+			}
+		}
+		score[m.place] = s
+	}
+	if len(score) == 0 {
+		return Place{}, false
+	}
+
+	best := make([]int, 0, len(score))
+	for p := range score {
+		best = append(best, p)
+	}
+	sort.Slice(best, func(i, j int) bool { return score[best[i]] > score[best[j]] })
+	if len(best) > 1 && score[best[0]] == score[best[1]] {
+		return Place{}, false
+	}
+	return g.places[best[0]], true
+}
+
+// POI is a point of interest, like work or a school
+type POI struct {
+	Name string  `json:"name"`
+	Lat  float64 `json:"lat"`
+	Lon  float64 `json:"lon"`
+}
+
+// Validate checks that the point has a name and coordinates
+func (p POI) Validate() error {
+	if p.Name == "" {
+		return fmt.Errorf("a point of interest needs a name")
+	}
+	if p.Lat < -90 || p.Lat > 90 || p.Lon < -180 || p.Lon > 180 || (p.Lat == 0 && p.Lon == 0) {
+		return fmt.Errorf("point of interest %q has invalid coordinates %v, %v", p.Name, p.Lat, p.Lon)
+	}
+	return nil
+}
+
+// FindPOI returns the point of interest called name
+func FindPOI(pois []POI, name string) (POI, bool) {
+	for _, p := range pois {
+		if Normalize(p.Name) == Normalize(name) {
+			return p, true
+		}
+	}
+	return POI{}, false
+}
+
+// EarthRadius is the mean radius of the Earth in km
+const EarthRadius = 6371.0
+
+// Distance returns the straight line distance in km between two coordinates (haversine)
+func Distance(lat1, lon1, lat2, lon2 float64) float64 {
+	rad := math.Pi / 180
+	dLat := (lat2 - lat1) * rad
+	dLon := (lon2 - lon1) * rad
+	a := math.Sin(dLat/2)*math.Sin(dLat/2) + math.Cos(lat1*rad)*math.Cos(lat2*rad)*math.Sin(dLon/2)*math.Sin(dLon/2)
+	return 2 * EarthRadius * math.Asin(math.Sqrt(a))
+}
+
+// DistanceTo returns the distance in km from the place to a point of interest, false for a district
+// because its seat can be tens of km away from the listing
+func (p Place) DistanceTo(poi POI) (float64, bool) {
+	if p.level() < 2 {
+		return 0, false
+	}
+	return Distance(p.Lat, p.Lon, poi.Lat, poi.Lon), true
+}
@@ -0,0 +1,112 @@
+package geo
+
+import (
+	"math"
+	"testing"
+)
+
+// Test the Find function with the bundled gazetteer
+func TestFind(t *testing.T) {
+	districts, err := Bundled()
+	if err != nil {
+		t.Fatal(err)
+	}
+	g := New(districts)
+
+	tests := []struct {
+		name     string
+		location string
+		wantID   string
+	}{
+		{"parish and municipality", "Esgueira, Aveiro", "aveiro/aveiro/esgueira"},
+		{"parish union", "Glória e Vera Cruz", "aveiro/aveiro/gloria-e-vera-cruz"},
+		{"parish union without accents", "GLORIA E VERA CRUZ", "aveiro/aveiro/gloria-e-vera-cruz"},
+		{"accents lost by the snippet", "Glria e Vera Cruz", "aveiro/aveiro/gloria-e-vera-cruz"},
+		{"parish before the union", "Vera Cruz, Aveiro", "aveiro/aveiro/gloria-e-vera-cruz"},
+		{"street before the parish", "praceta Doutor Alberto Tavares de Castro Esgueira Aveiro", "aveiro/aveiro/esgueira"},
+		{"municipality", "Aveiro", "aveiro/aveiro"},
+		{"municipality with accent", "Ílhavo", "aveiro/ilhavo"},
+		{"name inside a longer one", "São João da Madeira", "aveiro/sao-joao-da-madeira"},
+		{"district only", "Porto", "porto"},
+		{"unknown", "Centro", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p, ok := g.Find(tt.location)
+			if ok != (tt.wantID != "") || p.ID != tt.wantID {
+				t.Errorf("expected %q, got %q (%v)", tt.wantID, p.ID, ok)
+			}
+			if ok && (p.Lat == 0 || p.Lon == 0) {
+				t.Errorf("expected coordinates, got %v", p)
+			}
+		})
+	}
+}
+
+// Test the Merge function
+func TestMerge(t *testing.T) {
+	base := []District{{Name: "Aveiro", Lat: 1, Lon: 1, Municipalities: []Municipality{
+		{Name: "Ílhavo", Lat: 2, Lon: 2, Parishes: []Parish{{Name: "Gafanha do Carmo", Lat: 3, Lon: 3}}},
+	}}}
+	extra := []District{
+		{Name: "AVEIRO", Municipalities: []Municipality{
+			{Name: "Ilhavo", Parishes: []Parish{{Name: "Gafanha do Carmo", Lat: 4, Lon: 4}, {Name: "Gafanha da Nazaré", Lat: 5, Lon: 5}}},
+			{Name: "Vagos", Lat: 6, Lon: 6},
+		}},
+		{Name: "Coimbra", Lat: 7, Lon: 7},
+	}
+
+	g := New(Merge(base, extra))
+
+	tests := []struct {
+		location string
+		wantID   string
+		wantLat  float64
+	}{
+		{"Aveiro", "aveiro", 1},
+		{"Ílhavo", "aveiro/ilhavo", 2},
+		{"Gafanha do Carmo", "aveiro/ilhavo/gafanha-do-carmo", 4},
+		{"Gafanha da Nazaré", "aveiro/ilhavo/gafanha-da-nazare", 5},
+		{"Vagos", "aveiro/vagos", 6},
+		{"Coimbra", "coimbra", 7},
+	}
+	for _, tt := range tests {
+		p, ok := g.Find(tt.location)
+		if !ok || p.ID != tt.wantID || p.Lat != tt.wantLat {
+			t.Errorf("%s: expected %s at %v, got %v (%v)", tt.location, tt.wantID, tt.wantLat, p, ok)
+		}
+	}
+}
+
+// Test the Unmapped function
+func TestUnmapped(t *testing.T) {
+	g := New([]District{
+		{Name: "Aveiro", Municipalities: []Municipality{{Name: "Ílhavo"}}},
+		{Name: "Coimbra"},
+		{Name: "Faro"},
+	})
+	if got := g.Unmapped(); len(got) != 2 || got[0] != "Coimbra" || got[1] != "Faro" {
+		t.Errorf("expected Coimbra and Faro, got %v", got)
+	}
+}
+
+// Test the Distance function
+func TestDistance(t *testing.T) {
+	tests := []struct {
+		name                   string
+		lat1, lon1, lat2, lon2 float64
+		want                   float64
+	}{
+		{"same point", 40.64, -8.65, 40.64, -8.65, 0},
+		{"Aveiro to Porto", 40.6405, -8.6538, 41.1579, -8.6291, 57.6},
+		{"Lisboa to Faro", 38.7223, -9.1393, 37.0194, -7.9322, 216.9},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Distance(tt.lat1, tt.lon1, tt.lat2, tt.lon2); math.Abs(got-tt.want) > 0.5 {
+				t.Errorf("expected %.1f km, got %.1f km", tt.want, got)
+			}
+		})
+	}
+}
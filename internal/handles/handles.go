@@ -2,56 +2,626 @@ package handles
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
-	"log"
+	"log/slog"
 	"net/http"
-	"os"
+	"net/url"
+	"strconv"
 	"strings"
+	"time"
 
+	"github.com/BrunoTeixeira1996/gmah/internal/auth"
+	"github.com/BrunoTeixeira1996/gmah/internal/export"
+	"github.com/BrunoTeixeira1996/gmah/internal/extraction"
+	"github.com/BrunoTeixeira1996/gmah/internal/geo"
+	"github.com/BrunoTeixeira1996/gmah/internal/health"
+	"github.com/BrunoTeixeira1996/gmah/internal/listing"
+	"github.com/BrunoTeixeira1996/gmah/internal/logging"
+	"github.com/BrunoTeixeira1996/gmah/internal/lookup"
+	"github.com/BrunoTeixeira1996/gmah/internal/mapview"
+	"github.com/BrunoTeixeira1996/gmah/internal/report"
 	"github.com/BrunoTeixeira1996/gmah/internal/requests"
+	"github.com/BrunoTeixeira1996/gmah/internal/runner"
+	"github.com/BrunoTeixeira1996/gmah/internal/search"
+	"github.com/BrunoTeixeira1996/gmah/internal/serve"
 )
 
-// Handles POST to lookup for a specific date
-func LookUpSpecificHandle(w http.ResponseWriter, r *http.Request) {
-	defer r.Body.Close()
-	if r.Method != "POST" {
-		http.Error(w, "NOT POST!", http.StatusBadRequest)
-		return
+// Handles POST to lookup the daily pages of a date, a range or the last N days
+// the days are in the JSON response and, when asked, the notifiers get a link to them
+// baseURL is the address of the gmah server used in the links
+func LookUpSpecificHandle(n requests.Notifier, dumpDir string, baseURL string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		defer r.Body.Close()
+		if r.Method != "POST" {
+			http.Error(w, "NOT POST!", http.StatusBadRequest)
+			return
+		}
+
+		var req lookup.Request
+		decoder := json.NewDecoder(r.Body)
+		decoder.DisallowUnknownFields()
+		if err := decoder.Decode(&req); err != nil {
+			writeJSON(w, http.StatusBadRequest, errorResponse{fmt.Sprintf("invalid json: %v", err)})
+			return
+		}
+
+		from, to, err := req.Range(time.Now())
+		if err != nil {
+			writeJSON(w, http.StatusBadRequest, errorResponse{err.Error()})
+			return
+		}
+
+		days, err := lookup.Find(dumpDir, from, to)
+		if err != nil {
+			slog.Error("Error while looking for the daily pages", "dir", dumpDir, "err", err)
+			writeJSON(w, http.StatusInternalServerError, errorResponse{"could not read the daily pages"})
+			return
+		}
+
+		var found []lookup.Day
+		for _, d := range days {
+			if d.Found {
+				found = append(found, d)
+			}
+		}
+
+		// A single page is linked directly, otherwise the page that lists the days
+		label := from.Format("2006-01-02")
+		link := baseURL + "/days?from=" + from.Format("2006-01-02") + "&to=" + to.Format("2006-01-02")
+		if !from.Equal(to) {
+			label += " to " + to.Format("2006-01-02")
+		} else if len(found) == 1 {
+			link = baseURL + "/dump/" + found[0].File
+		}
+		slog.Info("Lookup", "from", from.Format("2006-01-02"), "to", to.Format("2006-01-02"), "found", len(found))
+
+		if req.Notify {
+			if err := requests.NotifyLookup(n, label, len(found), link); err != nil {
+				slog.Error("Error while notifying lookup", "err", err)
+			}
+		}
+
+		resp := struct {
+			From  string       `json:"from"`
+			To    string       `json:"to"`
+			Found int          `json:"found"`
+			Link  string       `json:"link,omitempty"`
+			Days  []lookup.Day `json:"days"`
+		}{
+			From:  from.Format("2006-01-02"),
+			To:    to.Format("2006-01-02"),
+			Found: len(found),
+			Days:  days,
+		}
+		if len(found) > 0 {
+			resp.Link = link
+		}
+		writeJSON(w, http.StatusOK, resp)
 	}
+}
 
-	decoder := json.NewDecoder(r.Body)
-	temp := struct {
-		Date string
-	}{}
+// Handles GET /days?from=YYYY-MM-DD&to=YYYY-MM-DD with the daily pages of the range
+func DaysPageHandle(dumpDir string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "GET" {
+			http.Error(w, "NOT GET!", http.StatusBadRequest)
+			return
+		}
+		q := r.URL.Query()
+		from, to, err := lookup.Request{From: q.Get("from"), To: q.Get("to")}.Range(time.Now())
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		days, err := lookup.Find(dumpDir, from, to)
+		if err != nil {
+			slog.Error("Error while looking for the daily pages", "dir", dumpDir, "err", err)
+			http.Error(w, "could not read the daily pages", http.StatusInternalServerError)
+			return
+		}
+		if err := serve.DaysPage(w, days); err != nil {
+			slog.Warn("Error while rendering the days page", "err", err)
+		}
+	}
+}
 
-	if err := decoder.Decode(&temp); err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		log.Println("Error while unmarshal json response:", err)
-		return
+// errorResponse is the JSON body of the 4xx and 5xx answers
+type errorResponse struct {
+	Error string `json:"error"`
+}
+
+// Handles GET to list the notifications that were not delivered yet
+func OutboxHandle(o *requests.Outbox) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "GET" {
+			http.Error(w, "NOT GET!", http.StatusBadRequest)
+			return
+		}
+		writeJSON(w, http.StatusOK, o.Pending())
+	}
+}
+
+// Handles DELETE /api/v1/outbox/<id> to remove a delivery, like one that was given up
+func OutboxDeleteHandle(o *requests.Outbox) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "DELETE" {
+			http.Error(w, "NOT DELETE!", http.StatusBadRequest)
+			return
+		}
+		id := strings.TrimPrefix(r.URL.Path, "/api/v1/outbox/")
+		err := o.Delete(id)
+		if errors.Is(err, requests.ErrUnknownDelivery) {
+			writeJSON(w, http.StatusNotFound, errorResponse{err.Error()})
+			return
+		}
+		if err != nil {
+			slog.Error("Error while saving the outbox", "delivery", id, "err", err)
+			writeJSON(w, http.StatusInternalServerError, errorResponse{"could not save the outbox"})
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
 	}
-	tempD := strings.Split(temp.Date, "/")
-	wantFileName := fmt.Sprintf("%s-%s-%s_serve.html", tempD[2], tempD[1], tempD[0])
+}
 
-	// Check if html and link already exist on /perm/home/gmah/html path
-	path := "/perm/home/gmah/html"
-	files, err := os.ReadDir(path)
-	if err != nil {
-		log.Println("Error while reading the perm data of gokrazy:", err)
-		return
+// Function that writes v as the JSON response
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		slog.Warn("Error while writing json response", "err", err)
+	}
+}
+
+// Handles GET or POST to ask for a run, only POST when a is enabled so a link can not start a run
+// the run happens in the background, its status is at /api/v1/runs/<id>
+func DemandHandle(c *runner.Coordinator, a *auth.Auth) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if a.Enabled() && r.Method != "POST" {
+			http.Error(w, "NOT POST!", http.StatusBadRequest)
+			return
+		}
+		if r.Method != "GET" && r.Method != "POST" {
+			http.Error(w, "NOT GET OR POST!", http.StatusBadRequest)
+			return
+		}
+		run, deduped := c.Enqueue(runner.TriggerDemand)
+		slog.Info("Demand queued run", "run_id", run.ID, "deduped", deduped)
+
+		location := "/api/v1/runs/" + run.ID
+		w.Header().Set("Location", location)
+		writeJSON(w, http.StatusAccepted, struct {
+			ID      string        `json:"id"`
+			Status  runner.Status `json:"status"`
+			Deduped bool          `json:"deduped"`
+			URL     string        `json:"url"`
+		}{
+			ID:      run.ID,
+			Status:  run.Status,
+			Deduped: deduped,
+			URL:     location,
+		})
 	}
-	for _, file := range files {
-		if wantFileName == file.Name() {
-			// Notify bot to send link
-			requests.NotifyTelegramBotAboutSpecificLookup(wantFileName, temp.Date, nil)
+}
+
+// Handles GET /api/v1/runs to list the run history and /api/v1/runs/<id> for a single run
+func RunHandle(c *runner.Coordinator) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "GET" {
+			http.Error(w, "NOT GET!", http.StatusBadRequest)
+			return
+		}
+		id := strings.Trim(strings.TrimPrefix(r.URL.Path, "/api/v1/runs"), "/")
+		if id == "" {
+			writeJSON(w, http.StatusOK, c.List())
+			return
+		}
+		run, ok := c.Get(id)
+		if !ok {
+			http.Error(w, "run not found", http.StatusNotFound)
+			return
+		}
+		writeJSON(w, http.StatusOK, run)
+	}
+}
+
+// Handles GET /runs for the run history page and /runs/<id> for the report of a run
+func RunsPageHandle(c *runner.Coordinator) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "GET" {
+			http.Error(w, "NOT GET!", http.StatusBadRequest)
+			return
+		}
+
+		var err error
+		id := strings.Trim(strings.TrimPrefix(r.URL.Path, "/runs"), "/")
+		if id == "" {
+			err = serve.RunsPage(w, c.List())
+		} else {
+			run, ok := c.Get(id)
+			if !ok {
+				http.Error(w, "run not found", http.StatusNotFound)
+				return
+			}
+			err = serve.RunPage(w, run)
+		}
+		if err != nil {
+			slog.Warn("Error while rendering the runs page", "err", err)
+		}
+	}
+}
+
+// Handles GET /api/v1/parsers with the extraction success rate of every portal
+func ParsersHandle(t *extraction.Tracker) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "GET" {
+			http.Error(w, "NOT GET!", http.StatusBadRequest)
+			return
+		}
+
+		type portalStats struct {
+			extraction.Stats
+			Rate float64 `json:"rate"`
+		}
+		stats := []portalStats{}
+		for _, st := range t.Stats() {
+			stats = append(stats, portalStats{Stats: st, Rate: st.Rate()})
+		}
+		writeJSON(w, http.StatusOK, stats)
+	}
+}
+
+// Handles GET /parsers for the extraction success rate page
+func ParsersPageHandle(t *extraction.Tracker) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "GET" {
+			http.Error(w, "NOT GET!", http.StatusBadRequest)
+			return
+		}
+		if err := serve.ParsersPage(w, t.Stats()); err != nil {
+			slog.Warn("Error while rendering the parsers page", "err", err)
+		}
+	}
+}
+
+// Handles GET /healthz, the process is up if it can answer
+func HealthzHandle(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, struct {
+		Status string `json:"status"`
+	}{"ok"})
+}
+
+// Handles GET /readyz with the result of every readiness check
+// it answers 503 when one of the checks fails
+func ReadyzHandle(c *health.Checker) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		report := c.Ready()
+		status := http.StatusOK
+		if !report.Ready {
+			status = http.StatusServiceUnavailable
+		}
+		writeJSON(w, status, report)
+	}
+}
+
+// Handles GET /debug/logs with the recent logs
+// ?level= keeps the lines at or above a level, ?run= the lines of a run and ?format=json answers JSON
+func DebugLogsHandle(ring *logging.Ring) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "GET" {
+			http.Error(w, "NOT GET!", http.StatusBadRequest)
+			return
+		}
+
+		q := r.URL.Query()
+		level := slog.LevelDebug
+		if s := q.Get("level"); s != "" {
+			var err error
+			if level, err = logging.ParseLevel(s); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+		}
+
+		entries := []logging.Entry{}
+		for _, e := range ring.Entries() {
+			var l slog.Level
+			if err := l.UnmarshalText([]byte(e.Level)); err == nil && l < level {
+				continue
+			}
+			if run := q.Get("run"); run != "" && e.Attrs["run_id"] != run {
+				continue
+			}
+			entries = append(entries, e)
+		}
+
+		if q.Get("format") == "json" {
+			writeJSON(w, http.StatusOK, entries)
 			return
 		}
+		if err := serve.LogsPage(w, entries); err != nil {
+			slog.Warn("Error while rendering the logs page", "err", err)
+		}
 	}
-	err = fmt.Errorf("Could not find any find from that date")
-	log.Println(err)
-	requests.NotifyTelegramBotAboutSpecificLookup("", temp.Date, err)
 }
 
 // Handles "/"
 func IndexHandle(w http.ResponseWriter, r *http.Request) {
-	fmt.Fprintf(w, "Homepage")
+	if r.URL.Path != "/" {
+		http.NotFound(w, r)
+		return
+	}
+	if err := serve.IndexPage(w); err != nil {
+		slog.Warn("Error while rendering the homepage", "err", err)
+	}
+}
+
+// Function that returns the period of a path like <prefix>/weekly/2024-W39
+func reportPeriod(path string, prefix string) (report.Period, error) {
+	kind, id, _ := strings.Cut(strings.Trim(strings.TrimPrefix(path, prefix), "/"), "/")
+	return report.Parse(report.Kind(kind), id, time.Local)
+}
+
+// Handles GET /api/v1/reports/<weekly|monthly>/<id> with the report of a week or month
+func ReportHandle(c *listing.Catalog) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "GET" {
+			http.Error(w, "NOT GET!", http.StatusBadRequest)
+			return
+		}
+		p, err := reportPeriod(r.URL.Path, "/api/v1/reports")
+		if err != nil {
+			writeJSON(w, http.StatusBadRequest, errorResponse{err.Error()})
+			return
+		}
+		writeJSON(w, http.StatusOK, report.Build(c.Records(), p, time.Now()))
+	}
+}
+
+// Handles GET /reports with the recent reports and /reports/<weekly|monthly>/<id> for a single report
+func ReportsPageHandle(c *listing.Catalog) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "GET" {
+			http.Error(w, "NOT GET!", http.StatusBadRequest)
+			return
+		}
+
+		var err error
+		if strings.Trim(strings.TrimPrefix(r.URL.Path, "/reports"), "/") == "" {
+			var weeks, months []report.Period
+			week, month := report.Week(time.Now()), report.Month(time.Now())
+			for i := 0; i < 8; i++ {
+				weeks = append(weeks, week)
+				week = week.Previous()
+			}
+			for i := 0; i < 6; i++ {
+				months = append(months, month)
+				month = month.Previous()
+			}
+			err = serve.ReportsPage(w, weeks, months)
+		} else {
+			p, perr := reportPeriod(r.URL.Path, "/reports")
+			if perr != nil {
+				http.Error(w, perr.Error(), http.StatusBadRequest)
+				return
+			}
+			err = serve.ReportPage(w, report.Build(c.Records(), p, time.Now()))
+		}
+		if err != nil {
+			slog.Warn("Error while rendering the reports page", "err", err)
+		}
+	}
+}
+
+// shortlistRequest is the body of POST /api/v1/shortlist
+type shortlistRequest struct {
+	Key    string `json:"key"`
+	Status string `json:"status"`
+	Notes  string `json:"notes"`
+}
+
+// Handles POST to shortlist a listing of the catalog, an empty status removes it from the shortlist
+func ShortlistHandle(c *listing.Catalog) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		defer r.Body.Close()
+		if r.Method != "POST" {
+			http.Error(w, "NOT POST!", http.StatusBadRequest)
+			return
+		}
+
+		var req shortlistRequest
+		decoder := json.NewDecoder(r.Body)
+		decoder.DisallowUnknownFields()
+		if err := decoder.Decode(&req); err != nil {
+			writeJSON(w, http.StatusBadRequest, errorResponse{fmt.Sprintf("invalid json: %v", err)})
+			return
+		}
+		if req.Key == "" {
+			writeJSON(w, http.StatusBadRequest, errorResponse{"key is required"})
+			return
+		}
+
+		rec, err := c.SetStatus(req.Key, req.Status, req.Notes)
+		if errors.Is(err, listing.ErrUnknownListing) {
+			writeJSON(w, http.StatusNotFound, errorResponse{err.Error()})
+			return
+		}
+		if err != nil {
+			slog.Error("Error while saving the shortlist", "key", req.Key, "err", err)
+			writeJSON(w, http.StatusInternalServerError, errorResponse{"could not save the listing"})
+			return
+		}
+		writeJSON(w, http.StatusOK, rec)
+	}
+}
+
+// Function that returns the filter of the from, to, search, near and km query parameters
+func listingFilter(q url.Values, searches []search.SavedSearch, pois []geo.POI) (export.Filter, error) {
+	filter, err := export.NewFilter(q.Get("from"), q.Get("to"), q.Get("search"), searches, time.Local)
+	if err != nil {
+		return export.Filter{}, err
+	}
+	if filter.Within, err = export.NewWithin(q.Get("near"), q.Get("km"), pois); err != nil {
+		return export.Filter{}, err
+	}
+	return filter, nil
+}
+
+// Handles GET /api/v1/export?format=csv|jsonl|xlsx&from=YYYY-MM-DD&to=YYYY-MM-DD&search=<name>&near=<poi>&km=<n>&sort=<order>
+// every parameter is optional, the listings come as a file to download
+func ExportHandle(c *listing.Catalog, searches []search.SavedSearch, pois []geo.POI) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "GET" {
+			http.Error(w, "NOT GET!", http.StatusBadRequest)
+			return
+		}
+		q := r.URL.Query()
+		format, err := export.ParseFormat(q.Get("format"))
+		if err != nil {
+			writeJSON(w, http.StatusBadRequest, errorResponse{err.Error()})
+			return
+		}
+		filter, err := listingFilter(q, searches, pois)
+		if err != nil {
+			writeJSON(w, http.StatusBadRequest, errorResponse{err.Error()})
+			return
+		}
+
+		records := filter.Select(c.Records())
+		if err := export.Sort(records, q.Get("sort"), pois); err != nil {
+			writeJSON(w, http.StatusBadRequest, errorResponse{err.Error()})
+			return
+		}
+		w.Header().Set("Content-Type", format.ContentType())
+		w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=\"gmah-listings-%s.%s\"", time.Now().Format("2006-01-02"), format))
+		if err := export.Write(w, format, records, pois); err != nil {
+			slog.Warn("Error while writing the export", "format", format, "err", err)
+		}
+	}
+}
+
+// Handles GET /map with the listings seen in the last 30 days, or between from and to,
+// that match the saved search named search and are within km of the point of interest near
+func MapPageHandle(c *listing.Catalog, searches []search.SavedSearch, pois []geo.POI, g *geo.Gazetteer, cfg mapview.Config, outlines []mapview.Shape) http.HandlerFunc {
+	var names []string
+	for _, s := range searches {
+		names = append(names, s.Name)
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "GET" {
+			http.Error(w, "NOT GET!", http.StatusBadRequest)
+			return
+		}
+		q := r.URL.Query()
+		color, err := mapview.ParseColorBy(q.Get("color"))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		filter, err := listingFilter(q, searches, pois)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if filter.From.IsZero() && filter.To.IsZero() {
+			now := time.Now()
+			filter.From = time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.Local).AddDate(0, 0, -30)
+			q.Set("from", filter.From.Format("2006-01-02"))
+		}
+		q.Set("color", string(color))
+
+		records := filter.Select(c.Records())
+		if err := export.Sort(records, q.Get("sort"), pois); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		opts := mapview.Options{
+			ColorBy:     color,
+			TileURL:     cfg.TileURL,
+			Attribution: cfg.Attribution,
+			Outlines:    outlines,
+			Places:      g.Places(),
+		}
+		if opts.Zoom, opts.Lat, opts.Lon, err = mapZoom(q); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		m := mapview.Build(records, opts)
+		if err := serve.MapPage(w, m, serve.MapFilter{Query: q, Searches: names, POIs: pois}, records); err != nil {
+			slog.Warn("Error while rendering the map page", "err", err)
+		}
+	}
+}
+
+// Function that returns the zoom and the middle of the map asked in the query, 0 when the map fits every listing
+func mapZoom(q url.Values) (int, float64, float64, error) {
+	if q.Get("zoom") == "" {
+		return 0, 0, 0, nil
+	}
+	zoom, err := strconv.Atoi(q.Get("zoom"))
+	if err != nil || zoom < 1 || zoom > mapview.MaxZoom {
+		return 0, 0, 0, fmt.Errorf("zoom must be between 1 and %d", mapview.MaxZoom)
+	}
+	lat, err := strconv.ParseFloat(q.Get("lat"), 64)
+	if err != nil || lat < -85 || lat > 85 {
+		return 0, 0, 0, fmt.Errorf("invalid latitude %q", q.Get("lat"))
+	}
+	lon, err := strconv.ParseFloat(q.Get("lon"), 64)
+	if err != nil || lon < -180 || lon > 180 {
+		return 0, 0, 0, fmt.Errorf("invalid longitude %q", q.Get("lon"))
+	}
+	return zoom, lat, lon, nil
+}
+
+// Function that returns where to go after logging in, only paths of this server are allowed
+func nextPath(next string) string {
+	if !strings.HasPrefix(next, "/") || strings.HasPrefix(next, "//") || strings.HasPrefix(next, "/\\") {
+		return "/"
+	}
+	return next
+}
+
+// Handles GET /login with the login form and POST /login to start a session
+func LoginHandle(a *auth.Auth) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case "GET":
+			if err := serve.LoginPage(w, nextPath(r.URL.Query().Get("next")), ""); err != nil {
+				slog.Warn("Error while rendering the login page", "err", err)
+			}
+		case "POST":
+			next := nextPath(r.PostFormValue("next"))
+			username := r.PostFormValue("username")
+			id, err := a.Login(username, r.PostFormValue("password"), time.Now())
+			if err != nil {
+				slog.Warn("Failed login", "username", username, "remote", r.RemoteAddr)
+				w.WriteHeader(http.StatusUnauthorized)
+				if err := serve.LoginPage(w, next, auth.ErrInvalidCredentials.Error()); err != nil {
+					slog.Warn("Error while rendering the login page", "err", err)
+				}
+				return
+			}
+			slog.Info("Login", "username", username, "remote", r.RemoteAddr)
+			http.SetCookie(w, a.SessionCookie(id))
+			http.Redirect(w, r, next, http.StatusSeeOther)
+		default:
+			http.Error(w, "NOT GET OR POST!", http.StatusBadRequest)
+		}
+	}
+}
+
+// Handles POST /logout to end the session
+func LogoutHandle(a *auth.Auth) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "POST" {
+			http.Error(w, "NOT POST!", http.StatusBadRequest)
+			return
+		}
+		if c, err := r.Cookie(auth.SessionCookie); err == nil {
+			a.Logout(c.Value)
+		}
+		http.SetCookie(w, a.SessionCookie(""))
+		http.Redirect(w, r, "/login", http.StatusSeeOther)
+	}
 }
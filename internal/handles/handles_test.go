@@ -0,0 +1,145 @@
+package handles
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/BrunoTeixeira1996/gmah/internal/auth"
+	"github.com/BrunoTeixeira1996/gmah/internal/requests"
+	"github.com/BrunoTeixeira1996/gmah/internal/runner"
+	"github.com/BrunoTeixeira1996/gmah/internal/store"
+)
+
+type recorder struct {
+	messages []requests.Message
+}
+
+func (r *recorder) Name() string {
+	return "recorder"
+}
+
+func (r *recorder) Notify(m requests.Message) error {
+	r.messages = append(r.messages, m)
+	return nil
+}
+
+// Test the LookUpSpecificHandle function
+func TestLookUpSpecificHandle(t *testing.T) {
+	dir := t.TempDir()
+	today := time.Now().Format("2006-01-02")
+	if err := os.WriteFile(filepath.Join(dir, today+"_serve.html"), []byte("<html></html>"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name       string
+		body       string
+		wantStatus int
+		wantFound  int
+		wantNotify int
+	}{
+		{"old bot request", `{"Date": "` + time.Now().Format("02/01/2006") + `"}`, http.StatusOK, 1, 0},
+		{"last days with notify", `{"last_days": 3, "notify": true}`, http.StatusOK, 1, 1},
+		{"range without pages", `{"from": "01/01/2020", "to": "02/01/2020"}`, http.StatusOK, 0, 0},
+		{"malformed date", `{"date": "24/09"}`, http.StatusBadRequest, 0, 0},
+		{"unknown field", `{"day": "24/09/2024"}`, http.StatusBadRequest, 0, 0},
+		{"not json", `24/09/2024`, http.StatusBadRequest, 0, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			n := &recorder{}
+			w := httptest.NewRecorder()
+			r := httptest.NewRequest("POST", "/lpspecific", strings.NewReader(tt.body))
+			LookUpSpecificHandle(n, dir, "http://localhost:9090")(w, r)
+
+			if w.Code != tt.wantStatus {
+				t.Fatalf("expected status %d, got %d: %s", tt.wantStatus, w.Code, w.Body.String())
+			}
+			if len(n.messages) != tt.wantNotify {
+				t.Errorf("expected %d notifications, got %d", tt.wantNotify, len(n.messages))
+			}
+			if w.Code != http.StatusOK {
+				return
+			}
+
+			var resp struct {
+				Found int    `json:"found"`
+				Link  string `json:"link"`
+			}
+			if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+				t.Fatal(err)
+			}
+			if resp.Found != tt.wantFound || (tt.wantFound > 0) != (resp.Link != "") {
+				t.Errorf("expected %d pages found, got %+v", tt.wantFound, resp)
+			}
+		})
+	}
+}
+
+// Test the DemandHandle function
+func TestDemandHandle(t *testing.T) {
+	st, err := store.Open(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, err := runner.New(func(run runner.Run) (runner.Result, error) { return runner.Result{}, nil }, st, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	open := auth.New(auth.Config{})
+	protected := auth.New(auth.Config{Tokens: []auth.Token{{Name: "bot", Token: "bot-token-0123456789"}}})
+
+	tests := []struct {
+		name       string
+		a          *auth.Auth
+		method     string
+		wantStatus int
+	}{
+		{"open GET", open, "GET", http.StatusAccepted},
+		{"open POST", open, "POST", http.StatusAccepted},
+		{"open DELETE", open, "DELETE", http.StatusBadRequest},
+		// A link or an image in a page must not start a run
+		{"protected GET", protected, "GET", http.StatusBadRequest},
+		{"protected POST", protected, "POST", http.StatusAccepted},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			DemandHandle(c, tt.a)(w, httptest.NewRequest(tt.method, "/demand", nil))
+			if w.Code != tt.wantStatus {
+				t.Errorf("expected status %d, got %d: %s", tt.wantStatus, w.Code, w.Body.String())
+			}
+		})
+	}
+}
+
+// Test the LogoutHandle function
+func TestLogoutHandle(t *testing.T) {
+	a := auth.New(auth.Config{Tokens: []auth.Token{{Name: "bot", Token: "bot-token-0123456789"}}})
+
+	tests := []struct {
+		method     string
+		wantStatus int
+	}{
+		{"GET", http.StatusBadRequest},
+		{"POST", http.StatusSeeOther},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.method, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			LogoutHandle(a)(w, httptest.NewRequest(tt.method, "/logout", nil))
+			if w.Code != tt.wantStatus {
+				t.Errorf("expected status %d, got %d", tt.wantStatus, w.Code)
+			}
+		})
+	}
+}
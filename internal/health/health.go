@@ -0,0 +1,88 @@
+package health
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// Check is the outcome of one readiness check
+type Check struct {
+	Name   string `json:"name"`
+	OK     bool   `json:"ok"`
+	Detail string `json:"detail,omitempty"`
+}
+
+// Report is the answer of /readyz
+type Report struct {
+	Ready  bool    `json:"ready"`
+	Checks []Check `json:"checks"`
+}
+
+// CheckFunc returns a detail about what it checked or the reason it is not ready
+type CheckFunc func() (string, error)
+
+type namedCheck struct {
+	name string
+	fn   CheckFunc
+}
+
+// Checker runs every readiness check in the order they were added
+type Checker struct {
+	mu     sync.Mutex
+	checks []namedCheck
+}
+
+// Add registers a readiness check
+func (c *Checker) Add(name string, fn CheckFunc) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.checks = append(c.checks, namedCheck{name, fn})
+}
+
+// Ready runs the checks, gmah is ready when all of them pass
+func (c *Checker) Ready() Report {
+	c.mu.Lock()
+	checks := append([]namedCheck(nil), c.checks...)
+	c.mu.Unlock()
+
+	report := Report{Ready: true, Checks: []Check{}}
+	for _, nc := range checks {
+		detail, err := nc.fn()
+		check := Check{Name: nc.name, OK: err == nil, Detail: detail}
+		if err != nil {
+			check.Detail = err.Error()
+			report.Ready = false
+		}
+		report.Checks = append(report.Checks, check)
+	}
+	return report
+}
+
+// Attempt remembers the outcome of the last attempt of something, like logging in to IMAP
+type Attempt struct {
+	mu  sync.Mutex
+	at  time.Time
+	err error
+}
+
+// Set records the outcome of an attempt
+func (a *Attempt) Set(err error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.at = time.Now()
+	a.err = err
+}
+
+// Check fails when the last attempt failed, it passes when there was no attempt yet
+func (a *Attempt) Check() (string, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.at.IsZero() {
+		return "no attempt yet", nil
+	}
+	if a.err != nil {
+		return "", fmt.Errorf("%s: %v", a.at.Format(time.RFC3339), a.err)
+	}
+	return "ok at " + a.at.Format(time.RFC3339), nil
+}
@@ -0,0 +1,38 @@
+package health
+
+import (
+	"fmt"
+	"testing"
+)
+
+// Test the Ready function
+func TestReady(t *testing.T) {
+	var login Attempt
+
+	c := &Checker{}
+	c.Add("store", func() (string, error) { return "data", nil })
+	c.Add("imap", login.Check)
+
+	tests := []struct {
+		name    string
+		attempt func()
+		ready   bool
+	}{
+		{"no attempt yet", func() {}, true},
+		{"login refused", func() { login.Set(fmt.Errorf("invalid credentials")) }, false},
+		{"login fixed", func() { login.Set(nil) }, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.attempt()
+			report := c.Ready()
+			if report.Ready != tt.ready || len(report.Checks) != 2 {
+				t.Errorf("expected ready to be %v, got %+v", tt.ready, report)
+			}
+			if report.Checks[1].Name != "imap" || report.Checks[1].OK != tt.ready || report.Checks[1].Detail == "" {
+				t.Errorf("unexpected imap check %+v", report.Checks[1])
+			}
+		})
+	}
+}
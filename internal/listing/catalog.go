@@ -0,0 +1,411 @@
+package listing
+
+import (
+	"errors"
+	"net/url"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/BrunoTeixeira1996/gmah/internal/geo"
+	"github.com/BrunoTeixeira1996/gmah/internal/photos"
+	"github.com/BrunoTeixeira1996/gmah/internal/store"
+)
+
+const catalogDocument = "listings"
+
+// Record is a listing as known across runs
+type Record struct {
+	Listing
+	Key       string    `json:"key"`
+	FirstSeen time.Time `json:"first_seen"`
+	LastSeen  time.Time `json:"last_seen"`
+	TimesSeen int       `json:"times_seen"`
+	// Prices has an entry every time the asking price changed
+	Prices []PricePoint `json:"prices,omitempty"`
+	// Status is set for the houses we are following, like shortlisted or visited
+	Status string `json:"status,omitempty"`
+	Notes  string `json:"notes,omitempty"`
+	// Group is the key of the first record of the same house on another portal or
+	// announced again later, found by its photo
+	Group string `json:"group,omitempty"`
+	// Pending is the run that first saw the listing until that run queued its notifications,
+	// a run stopped before that leaves it for the next run to notify
+	Pending string `json:"pending,omitempty"`
+}
+
+// PricePoint is the asking price of a listing since At
+type PricePoint struct {
+	At    time.Time `json:"at"`
+	Price int       `json:"price"`
+}
+
+// PriceAt returns the asking price known at t, 0 when the listing had no price yet
+func (r Record) PriceAt(t time.Time) int {
+	price := 0
+	for _, p := range r.Prices {
+		if p.At.After(t) {
+			break
+		}
+		price = p.Price
+	}
+	return price
+}
+
+// Links of these hosts change on every email so they can't identify a listing
+var trackingHosts = []string{"trk.elasticemail.com"}
+
+var keyRegex = regexp.MustCompile(`[^a-z0-9]+`)
+
+// Key identifies a listing across emails
+// the link without query is used when it is stable, otherwise the title and the area
+// the price is left out so a listing keeps its key when the price changes
+func (l Listing) Key() string {
+	portal := strings.ToLower(l.Portal)
+
+	if u, err := url.Parse(l.Link); err == nil && l.Link != "" {
+		stable := true
+		for _, h := range trackingHosts {
+			if strings.HasSuffix(strings.ToLower(u.Host), strings.ToLower(h)) {
+				stable = false
+			}
+		}
+		if stable {
+			return portal + "|" + strings.ToLower(u.Host) + strings.TrimSuffix(u.Path, "/")
+		}
+	}
+
+	return portal + "|" + keyRegex.ReplaceAllString(strings.ToLower(l.Title), "-") + "|" + strconv.Itoa(l.Area)
+}
+
+// Catalog keeps every listing ever seen in the store
+type Catalog struct {
+	mu       sync.Mutex
+	store    *store.Store
+	records  map[string]*Record
+	readOnly bool
+}
+
+// ErrReadOnly is returned by the writes of a catalog opened with ReadCatalog
+var ErrReadOnly = errors.New("catalog is read only")
+
+// OpenCatalog loads the catalog from the store
+func OpenCatalog(s *store.Store) (*Catalog, error) {
+	c, renamed, err := loadCatalog(s)
+	if err != nil || !renamed {
+		return c, err
+	}
+	return c, c.save()
+}
+
+// ReadCatalog loads the catalog like OpenCatalog but never writes to the store, the records of the
+// old keys are only merged in memory, so a command can read it while the daemon is running
+func ReadCatalog(s *store.Store) (*Catalog, error) {
+	c, _, err := loadCatalog(s)
+	if err != nil {
+		return nil, err
+	}
+	c.readOnly = true
+	return c, nil
+}
+
+// Function that loads the catalog and merges the records of the keys that had the price,
+// it tells if any key changed
+func loadCatalog(s *store.Store) (*Catalog, bool, error) {
+	var records []*Record
+	if err := s.Load(catalogDocument, &records); err != nil {
+		return nil, false, err
+	}
+
+	c := &Catalog{store: s, records: map[string]*Record{}}
+	// The keys of the tracking links had the price, the records of every price of a listing become one
+	renamed := map[string]string{}
+	for _, r := range records {
+		if key := r.Listing.Key(); key != r.Key {
+			renamed[r.Key] = key
+			r.Key = key
+		}
+		if other, ok := c.records[r.Key]; ok {
+			r = merge(other, r)
+		}
+		c.records[r.Key] = r
+	}
+	if len(renamed) == 0 {
+		return c, false, nil
+	}
+
+	for _, r := range c.records {
+		if key, ok := renamed[r.Group]; ok {
+			r.Group = key
+		}
+		if r.Group == r.Key {
+			r.Group = ""
+		}
+	}
+	return c, true, nil
+}
+
+// Function that merges two records of the same listing, the listing of the one seen last is kept
+func merge(a, b *Record) *Record {
+	if b.LastSeen.Before(a.LastSeen) {
+		a, b = b, a
+	}
+	merged := *b
+	if a.FirstSeen.Before(merged.FirstSeen) {
+		merged.FirstSeen = a.FirstSeen
+	}
+	merged.TimesSeen += a.TimesSeen
+	if merged.Status == "" {
+		merged.Status, merged.Notes = a.Status, a.Notes
+	}
+	if merged.Group == "" {
+		merged.Group = a.Group
+	}
+
+	// The price points of both, without repeating a price that did not change
+	points := append(append([]PricePoint(nil), a.Prices...), b.Prices...)
+	sort.SliceStable(points, func(i, j int) bool { return points[i].At.Before(points[j].At) })
+	merged.Prices = nil
+	for _, p := range points {
+		if len(merged.Prices) == 0 || merged.Prices[len(merged.Prices)-1].Price != p.Price {
+			merged.Prices = append(merged.Prices, p)
+		}
+	}
+	return &merged
+}
+
+// must be called with c.mu held
+func (c *Catalog) save() error {
+	if c.readOnly {
+		return ErrReadOnly
+	}
+	records := make([]*Record, 0, len(c.records))
+	for _, r := range c.records {
+		records = append(records, r)
+	}
+	sort.Slice(records, func(i, j int) bool { return records[i].Key < records[j].Key })
+	return c.store.Save(catalogDocument, records)
+}
+
+// Observe records the listings of the run runID and splits them in new ones and duplicates
+// a listing repeated inside the same run counts as a duplicate, and so does a new
+// listing with the photo of a known one
+// the new listings stay pending until Notified, so a run that reads the same emails after
+// an interrupted one still finds them new
+func (c *Catalog) Observe(runID string, listings []Listing, at time.Time) (fresh []Listing, duplicates []Listing, err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for _, l := range listings {
+		key := l.Key()
+		r, ok := c.records[key]
+		switch {
+		case ok && r.Pending != "" && r.Pending != runID:
+			// The run that saw it first stopped before notifying, this is the same sighting and is not counted again
+			r.Pending = runID
+			r.TimesSeen--
+			if r.Group != "" && c.groupNotified(r.Group, key, runID) {
+				duplicates = append(duplicates, l)
+			} else {
+				fresh = append(fresh, l)
+			}
+		case ok:
+			duplicates = append(duplicates, l)
+		default:
+			r = &Record{Key: key, FirstSeen: at, Pending: runID}
+			if m := c.match(l); m != nil {
+				r.Group = m.Key
+				if m.Group != "" {
+					r.Group = m.Group
+				}
+			}
+			// The house is only left out when another listing of its group was notified or is in this run
+			if r.Group != "" && c.groupNotified(r.Group, key, runID) {
+				duplicates = append(duplicates, l)
+			} else {
+				fresh = append(fresh, l)
+			}
+			c.records[key] = r
+		}
+		// An email where the price could not be extracted keeps the known one and its comparison
+		if l.Price == 0 {
+			l.Price, l.Market = r.Price, r.Market
+		}
+		if l.Price != 0 && (len(r.Prices) == 0 || r.Prices[len(r.Prices)-1].Price != l.Price) {
+			r.Prices = append(r.Prices, PricePoint{At: at, Price: l.Price})
+		}
+		// Same for the photo when it could not be downloaded this time
+		if l.Thumbnail == "" {
+			l.Photo, l.Thumbnail, l.PhotoHash = r.Photo, r.Thumbnail, r.PhotoHash
+		}
+		r.Listing = l
+		r.LastSeen = at
+		r.TimesSeen++
+	}
+
+	return fresh, duplicates, c.save()
+}
+
+// Notified marks the listings first seen by the run runID as notified, they are duplicates for the next runs
+func (c *Catalog) Notified(runID string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for _, r := range c.records {
+		if r.Pending == runID {
+			r.Pending = ""
+		}
+	}
+	return c.save()
+}
+
+// Function that tells if a listing of group other than except was notified, or is about to be by the run runID
+// must be called with c.mu held
+func (c *Catalog) groupNotified(group, except, runID string) bool {
+	for _, r := range c.records {
+		if r.Key == except || (r.Key != group && r.Group != group) {
+			continue
+		}
+		if r.Pending == "" || r.Pending == runID {
+			return true
+		}
+	}
+	return false
+}
+
+// A photo that more records than maxPhotoShares have is a placeholder or a banner of the portal, not a house
+const maxPhotoShares = 5
+
+// Function that returns the oldest record with the same photo as l, nil when there is none
+// must be called with c.mu held
+func (c *Catalog) match(l Listing) *Record {
+	h, err := photos.ParseHash(l.PhotoHash)
+	if err != nil {
+		return nil
+	}
+
+	var (
+		best   *Record
+		shares int
+	)
+	for _, r := range c.records {
+		if r.PhotoHash == "" {
+			continue
+		}
+		rh, err := photos.ParseHash(r.PhotoHash)
+		if err != nil {
+			continue
+		}
+		distance := h.Distance(rh)
+		if distance <= photos.SameDistance {
+			shares++
+		}
+		if !similar(l, r.Listing, distance) {
+			continue
+		}
+		if best == nil || r.FirstSeen.Before(best.FirstSeen) || (r.FirstSeen.Equal(best.FirstSeen) && r.Key < best.Key) {
+			best = r
+		}
+	}
+	if shares > maxPhotoShares {
+		return nil
+	}
+	return best
+}
+
+// Function that tells if two listings with photos distance bits apart are the same house
+// agencies change the title and the price between portals and re-listings, but reuse the photos
+// of the street or the building too, so the same photo also needs the same typology, an area
+// within 10% or the same municipality, and a similar one needs both the typology and the area
+func similar(a, b Listing, distance int) bool {
+	switch {
+	case distance <= photos.SameDistance:
+		return sameTypology(a, b) || closeArea(a, b) || sameMunicipality(a, b)
+	case distance <= photos.NearDistance:
+		return sameTypology(a, b) && closeArea(a, b)
+	}
+	return false
+}
+
+// Function that tells if both listings have the same typology
+func sameTypology(a, b Listing) bool {
+	return a.Typology != "" && a.Typology == b.Typology
+}
+
+// Function that tells if both listings have an area and they are within 10%
+func closeArea(a, b Listing) bool {
+	if a.Area == 0 || b.Area == 0 {
+		return false
+	}
+	diff := a.Area - b.Area
+	if diff < 0 {
+		diff = -diff
+	}
+	return diff*10 <= b.Area
+}
+
+// Function that tells if both listings were located in the same municipality
+func sameMunicipality(a, b Listing) bool {
+	if a.Place == nil || b.Place == nil || a.Place.Municipality == "" {
+		return false
+	}
+	return a.Place.District == b.Place.District && a.Place.Municipality == b.Place.Municipality
+}
+
+// Refresh finds the place of every record again, scores it and compares it to the market built
+// from the located records, then saves the catalog once. It runs when gmah starts, for the records
+// from before the gazetteer and after the gazetteer, the scoring or the market config change
+func (c *Catalog) Refresh(g *geo.Gazetteer, score func(*Listing), market func([]Record) func(*Listing)) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	records := make([]Record, 0, len(c.records))
+	for _, r := range c.records {
+		r.Locate(g)
+		score(&r.Listing)
+		records = append(records, *r)
+	}
+	compare := market(records)
+	for _, r := range c.records {
+		compare(&r.Listing)
+	}
+	return c.save()
+}
+
+// ErrUnknownListing is returned when a key is not in the catalog
+var ErrUnknownListing = errors.New("unknown listing")
+
+// SetStatus sets the status and the notes of a listing, an empty status stops following it
+func (c *Catalog) SetStatus(key, status, notes string) (Record, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	r, ok := c.records[key]
+	if !ok {
+		return Record{}, ErrUnknownListing
+	}
+	r.Status = status
+	r.Notes = notes
+	return *r, c.save()
+}
+
+// Records returns a copy of every record, newest first
+func (c *Catalog) Records() []Record {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	records := make([]Record, 0, len(c.records))
+	for _, r := range c.records {
+		records = append(records, *r)
+	}
+	sort.Slice(records, func(i, j int) bool {
+		if !records[i].FirstSeen.Equal(records[j].FirstSeen) {
+			return records[i].FirstSeen.After(records[j].FirstSeen)
+		}
+		return records[i].Key < records[j].Key
+	})
+	return records
+}
@@ -0,0 +1,317 @@
+package listing
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/BrunoTeixeira1996/gmah/internal/geo"
+	"github.com/BrunoTeixeira1996/gmah/internal/store"
+)
+
+// Test the Observe function
+func TestObserve(t *testing.T) {
+	st, err := store.Open(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, err := OpenCatalog(st)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	house := Listing{Portal: "idealista", Title: "Moradia T3", Price: 205000, Link: "https://www.idealista.pt/imovel/123/?utm=a"}
+	tracked := Listing{Portal: "CasaYes", Title: "Moradia T3 Esgueira", Price: 239900, Link: "https://trk.elasticemail.com/tracking/click?d=a"}
+
+	fresh, dups, err := c.Observe("run-1", []Listing{house, tracked}, time.Now())
+	if err != nil || len(fresh) != 2 || len(dups) != 0 {
+		t.Fatalf("expected 2 new listings, got %d new %d duplicates (%v)", len(fresh), len(dups), err)
+	}
+
+	// The first run stopped before notifying, the next one reads the same emails
+	c, err = OpenCatalog(st)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fresh, dups, err = c.Observe("run-2", []Listing{house, tracked}, time.Now())
+	if err != nil || len(fresh) != 2 || len(dups) != 0 {
+		t.Fatalf("expected the 2 listings of the stopped run to be new, got %d new %d duplicates (%v)", len(fresh), len(dups), err)
+	}
+	if err := c.Notified("run-2"); err != nil {
+		t.Fatal(err)
+	}
+
+	// Same page with another query and a new tracking link with a lower price are the same listings
+	house.Link = "https://www.idealista.pt/imovel/123/?utm=b"
+	tracked.Link = "https://trk.elasticemail.com/tracking/click?d=b"
+	tracked.Price = 229900
+
+	c, err = OpenCatalog(st)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fresh, dups, err = c.Observe("run-3", []Listing{house, tracked}, time.Now())
+	if err != nil || len(fresh) != 0 || len(dups) != 2 {
+		t.Fatalf("expected 2 duplicates, got %d new %d duplicates (%v)", len(fresh), len(dups), err)
+	}
+	for _, r := range c.Records() {
+		if r.TimesSeen != 2 {
+			t.Errorf("expected %s to be seen twice, got %d", r.Key, r.TimesSeen)
+		}
+		if r.Portal == "CasaYes" && len(r.Prices) != 2 {
+			t.Errorf("expected the price drop of %s, got %v", r.Key, r.Prices)
+		}
+	}
+}
+
+// Test that OpenCatalog merges the records of the keys that had the price
+func TestOpenCatalogRekey(t *testing.T) {
+	st, err := store.Open(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	day := func(d int) time.Time { return time.Date(2024, 9, d, 23, 59, 0, 0, time.UTC) }
+	tracked := Listing{Portal: "CasaYes", Title: "Moradia T3 Esgueira", Area: 124, Link: "https://trk.elasticemail.com/tracking/click?d=a"}
+	before, after := tracked, tracked
+	before.Price, after.Price = 239900, 229900
+	photo := Listing{Portal: "idealista", Title: "Moradia T3", Area: 124, Link: "https://www.idealista.pt/imovel/123/"}
+	old := []*Record{
+		{Listing: before, Key: "casayes|moradia-t3-esgueira|239900", FirstSeen: day(1), LastSeen: day(5), TimesSeen: 3, Status: "visit", Prices: []PricePoint{{day(1), 239900}}},
+		{Listing: after, Key: "casayes|moradia-t3-esgueira|229900", FirstSeen: day(10), LastSeen: day(12), TimesSeen: 2, Prices: []PricePoint{{day(10), 229900}}},
+		{Listing: photo, Key: photo.Key(), FirstSeen: day(11), LastSeen: day(11), TimesSeen: 1, Group: "casayes|moradia-t3-esgueira|239900"},
+	}
+	if err := st.Save(catalogDocument, old); err != nil {
+		t.Fatal(err)
+	}
+
+	// Reading merges the records in memory and leaves the document of the daemon alone
+	read, err := ReadCatalog(st)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(read.Records()) != 2 {
+		t.Errorf("expected 2 records, got %+v", read.Records())
+	}
+	if _, err := read.SetStatus("casayes|moradia-t3-esgueira|124", "visit", ""); !errors.Is(err, ErrReadOnly) {
+		t.Errorf("expected a read only catalog, got %v", err)
+	}
+	var saved []*Record
+	if err := st.Load(catalogDocument, &saved); err != nil || len(saved) != 3 {
+		t.Errorf("expected the 3 saved records untouched, got %d (%v)", len(saved), err)
+	}
+
+	c, err := OpenCatalog(st)
+	if err != nil {
+		t.Fatal(err)
+	}
+	records := c.Records()
+	if len(records) != 2 {
+		t.Fatalf("expected 2 records, got %+v", records)
+	}
+	r := records[1]
+	if r.Key != "casayes|moradia-t3-esgueira|124" || r.Price != 229900 || r.TimesSeen != 5 || r.Status != "visit" ||
+		!r.FirstSeen.Equal(day(1)) || !r.LastSeen.Equal(day(12)) || len(r.Prices) != 2 {
+		t.Errorf("unexpected merged record %+v", r)
+	}
+	if records[0].Group != r.Key {
+		t.Errorf("expected the group to follow the new key, got %q", records[0].Group)
+	}
+
+	// The new keys are saved
+	if c, err = OpenCatalog(st); err != nil || len(c.Records()) != 2 {
+		t.Errorf("expected 2 records after a restart (%v)", err)
+	}
+}
+
+// Test the Observe function with listings that share a photo
+func TestObservePhoto(t *testing.T) {
+	st, err := store.Open(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, err := OpenCatalog(st)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	day := time.Date(2024, 3, 1, 9, 0, 0, 0, time.UTC)
+	esgueira := &geo.Place{ID: "aveiro/aveiro/esgueira", District: "Aveiro", Municipality: "Aveiro", Parish: "Esgueira"}
+	house := Listing{Portal: "idealista", Title: "Moradia T3", Typology: "T3", Area: 120, Price: 205000, Link: "https://www.idealista.pt/imovel/123/", Thumbnail: "/thumbnails/a.jpg", PhotoHash: "ff00ff00ff00ff00f0f0f0f0f0f0f0f0", Place: esgueira}
+	if _, _, err := c.Observe("run-0", []Listing{house}, day); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Notified("run-0"); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name      string
+		listing   Listing
+		wantGroup string
+	}{
+		{
+			"same photo on another portal",
+			Listing{Portal: "CasaYes", Title: "Moradia em Esgueira", Area: 118, Price: 199000, Link: "https://casayes.pt/1", Thumbnail: "/thumbnails/a.jpg", PhotoHash: "ff00ff00ff00ff01f0f0f0f0f0f0f0f1"},
+			"idealista|www.idealista.pt/imovel/123",
+		},
+		{
+			"same photo in the same municipality",
+			Listing{Portal: "SUPERCASA", Title: "Moradia", Link: "https://supercasa.pt/3", Thumbnail: "/thumbnails/a.jpg", PhotoHash: "ff00ff00ff00ff00f0f0f0f0f0f0f0f0", Place: &geo.Place{ID: "aveiro/aveiro", District: "Aveiro", Municipality: "Aveiro"}},
+			"idealista|www.idealista.pt/imovel/123",
+		},
+		{
+			// Like a photo of the street used for every flat of a building
+			"same photo of another house",
+			Listing{Portal: "idealista", Title: "Apartamento T1", Typology: "T1", Area: 50, Link: "https://www.idealista.pt/imovel/321/", Thumbnail: "/thumbnails/a.jpg", PhotoHash: "ff00ff00ff00ff00f0f0f0f0f0f0f0f0", Place: &geo.Place{ID: "aveiro/ilhavo", District: "Aveiro", Municipality: "Ílhavo"}},
+			"",
+		},
+		{
+			"retouched photo of the same house months later",
+			Listing{Portal: "idealista", Title: "Moradia T3 renovada", Typology: "T3", Area: 125, Price: 215000, Link: "https://www.idealista.pt/imovel/456/", Thumbnail: "/thumbnails/a.jpg", PhotoHash: "ff00ff00ff00ffffffffff00f0f0f0f0"},
+			"idealista|www.idealista.pt/imovel/123",
+		},
+		{
+			"retouched photo of another typology",
+			Listing{Portal: "idealista", Title: "Apartamento T2", Typology: "T2", Area: 120, Link: "https://www.idealista.pt/imovel/789/", Thumbnail: "/thumbnails/a.jpg", PhotoHash: "ff00ff00ffffff00f0f0f0f0f0f0ffff"},
+			"",
+		},
+		{
+			"another photo",
+			Listing{Portal: "CasaYes", Title: "Moradia T3", Typology: "T3", Area: 120, Link: "https://casayes.pt/2", Thumbnail: "/thumbnails/a.jpg", PhotoHash: "00ff00ff00ff00ff0f0f0f0f0f0f0f0f"},
+			"",
+		},
+	}
+
+	for i, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			runID := fmt.Sprintf("run-%d", i+1)
+			fresh, dups, err := c.Observe(runID, []Listing{tt.listing}, day.AddDate(0, i+1, 0))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := c.Notified(runID); err != nil {
+				t.Fatal(err)
+			}
+			if wantDup := tt.wantGroup != ""; len(dups) == 1 != wantDup || len(fresh) == 1 == wantDup {
+				t.Errorf("expected duplicate %v, got %d new %d duplicates", wantDup, len(fresh), len(dups))
+			}
+			for _, r := range c.Records() {
+				if r.Key == tt.listing.Key() && r.Group != tt.wantGroup {
+					t.Errorf("expected group %q, got %q", tt.wantGroup, r.Group)
+				}
+			}
+		})
+	}
+}
+
+// Test that a photo shared by many listings does not group them
+func TestObservePlaceholder(t *testing.T) {
+	st, err := store.Open(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, err := OpenCatalog(st)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The "no photo" image of a portal on flats of the same typology and size
+	placeholder := func(i int) Listing {
+		return Listing{Portal: "CasaYes", Title: fmt.Sprintf("Apartamento T2 %d", i), Typology: "T2", Area: 90, Link: fmt.Sprintf("https://casayes.pt/%d", i), Thumbnail: "/thumbnails/p.jpg", PhotoHash: "0f0f0f0f0f0f0f0f0f0f0f0f0f0f0f0f"}
+	}
+	for i := 0; i <= maxPhotoShares; i++ {
+		if _, _, err := c.Observe("run-1", []Listing{placeholder(i)}, time.Now()); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	fresh, _, err := c.Observe("run-2", []Listing{placeholder(100)}, time.Now())
+	if err != nil || len(fresh) != 1 {
+		t.Fatalf("expected the listing with the placeholder to be new, got %d new (%v)", len(fresh), err)
+	}
+	for _, r := range c.Records() {
+		if r.Key == placeholder(100).Key() && r.Group != "" {
+			t.Errorf("expected no group, got %q", r.Group)
+		}
+	}
+}
+
+// Test that a listing is only left out when the first of its group was notified
+func TestObserveGroupPending(t *testing.T) {
+	st, err := store.Open(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, err := OpenCatalog(st)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	house := func(portal string) Listing {
+		return Listing{Portal: portal, Title: "Moradia T3", Typology: "T3", Area: 120, Link: "https://" + portal + ".pt/1", Thumbnail: "/thumbnails/a.jpg", PhotoHash: "ff00ff00ff00ff00f0f0f0f0f0f0f0f0"}
+	}
+
+	// The run that saw it first stopped before notifying
+	if _, _, err := c.Observe("run-1", []Listing{house("idealista")}, time.Now()); err != nil {
+		t.Fatal(err)
+	}
+	fresh, dups, err := c.Observe("run-2", []Listing{house("casayes"), house("supercasa")}, time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The second of the same run is left out, the first one is notified by this run
+	if len(fresh) != 1 || fresh[0].Portal != "casayes" || len(dups) != 1 {
+		t.Errorf("expected casayes new and supercasa duplicate, got %v new %v duplicates", fresh, dups)
+	}
+	for _, r := range c.Records() {
+		if r.Portal != "idealista" && r.Group != house("idealista").Key() {
+			t.Errorf("expected %s in the group of the first listing, got %q", r.Key, r.Group)
+		}
+	}
+}
+
+// Test the Refresh function
+func TestRefresh(t *testing.T) {
+	st, err := store.Open(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, err := OpenCatalog(st)
+	if err != nil {
+		t.Fatal(err)
+	}
+	house := Listing{Portal: "CasaYes", Title: "Moradia T3 Esgueira Aveiro", Location: "Esgueira Aveiro", Link: "https://www.casayes.pt/1"}
+	if _, _, err := c.Observe("run-1", []Listing{house}, time.Now()); err != nil {
+		t.Fatal(err)
+	}
+
+	g := geo.New([]geo.District{{Name: "Aveiro", Municipalities: []geo.Municipality{{Name: "Aveiro", Parishes: []geo.Parish{{Name: "Esgueira", Lat: 40.65, Lon: -8.63}}}}}})
+	score := func(l *Listing) { l.Score = 50 }
+	// The market sees the records already located
+	var located int
+	market := func(records []Record) func(*Listing) {
+		for _, r := range records {
+			if r.Place != nil && r.Score == 50 {
+				located++
+			}
+		}
+		return func(l *Listing) { l.Market = &Comparison{Area: l.Place.Parish} }
+	}
+	if err := c.Refresh(g, score, market); err != nil {
+		t.Fatal(err)
+	}
+	if located != 1 {
+		t.Errorf("expected the market to be built from the located and scored record")
+	}
+
+	// Everything is saved
+	if c, err = OpenCatalog(st); err != nil {
+		t.Fatal(err)
+	}
+	r := c.Records()[0]
+	if r.Place == nil || r.Place.Parish != "Esgueira" || r.Score != 50 || r.Market == nil || r.Market.Area != "Esgueira" {
+		t.Errorf("expected a located, scored and compared record after a restart, got %+v", r)
+	}
+}
@@ -0,0 +1,138 @@
+package listing
+
+import (
+	"fmt"
+	"math"
+	"regexp"
+	"strings"
+
+	"github.com/BrunoTeixeira1996/gmah/internal/email"
+	"github.com/BrunoTeixeira1996/gmah/internal/geo"
+)
+
+// Listing is the structured version of an email sent by a portal
+type Listing struct {
+	Portal   string `json:"portal"`
+	Title    string `json:"title"`
+	Typology string `json:"typology"`
+	Price    int    `json:"price"`
+	Area     int    `json:"area"`
+	Location string `json:"location"`
+	Link     string `json:"link"`
+	// Photo is the URL of the main photo on the portal
+	Photo string `json:"photo,omitempty"`
+	// Thumbnail is the path of the cached copy of the photo on the gmah server
+	Thumbnail string `json:"thumbnail,omitempty"`
+	// PhotoHash is the perceptual hash of the thumbnail, see photos.Hash
+	PhotoHash string `json:"photo_hash,omitempty"`
+	// Place is where the gazetteer found the location, nil when it did not
+	Place *geo.Place `json:"place,omitempty"`
+	// Score ranks the listing with the scoring of the config, ScoreParts explain it
+	Score      int         `json:"score,omitempty"`
+	ScoreParts []ScorePart `json:"score_parts,omitempty"`
+	// Market compares the price per m² with the other listings of the area, nil without enough of them
+	Market *Comparison `json:"market,omitempty"`
+}
+
+// ScorePart is what a rule of the scoring added to the score of a listing and why
+type ScorePart struct {
+	Rule   string  `json:"rule"`
+	Points float64 `json:"points"`
+	Detail string  `json:"detail"`
+}
+
+// Comparison is the price per m² of a listing against the median of the listings of its area, see market.Index
+type Comparison struct {
+	// Area is the parish or the municipality of the median, Typology is empty when it is every typology
+	Area     string `json:"area"`
+	Typology string `json:"typology,omitempty"`
+	Median   int    `json:"median"`
+	// Sample is how many listings the median comes from
+	Sample int `json:"sample"`
+	// Deviation is how far the listing is from the median, -0.12 is 12% below it
+	Deviation float64 `json:"deviation"`
+}
+
+// String returns the comparison in words, like "12% below Aveiro T3 median"
+func (c Comparison) String() string {
+	area := strings.TrimSpace(c.Area + " " + c.Typology)
+	pct := int(math.Round(math.Abs(c.Deviation) * 100))
+	switch {
+	case pct == 0:
+		return "at the " + area + " median"
+	case c.Deviation < 0:
+		return fmt.Sprintf("%d%% below %s median", pct, area)
+	}
+	return fmt.Sprintf("%d%% above %s median", pct, area)
+}
+
+var (
+	typologyRegex = regexp.MustCompile(`\bT(\d+)\b`)
+	locationRegex = regexp.MustCompile(`\bem (.+)$`)
+	// idealista appends the price to the snippet
+	trailingPriceRegex = regexp.MustCompile(`\s*\d+ ?(?:€|E282AC.*)$`)
+)
+
+// Function that builds a Listing from an email
+// the email parsing strips most of the punctuation so this is best effort
+func FromEmail(e email.EmailTemplate) Listing {
+	l := Listing{
+		Portal: e.From,
+		Title:  strings.TrimSpace(trailingPriceRegex.ReplaceAllString(e.Snippet, "")),
+		Price:  e.Price,
+		Area:   e.Area,
+		Link:   e.Link,
+		Photo:  e.Photo,
+		// The thumbnail is only there when the photo was downloaded
+		Thumbnail: e.Thumbnail,
+	}
+	if l.Title == "" {
+		l.Title = e.Subject
+	}
+
+	if m := typologyRegex.FindStringSubmatch(l.Title); m != nil {
+		l.Typology = "T" + m[1]
+	}
+
+	// Most portals write "<kind> T3 (para) venda em <location>"
+	// CasaYes writes "<kind> T3 <location>"
+	if m := locationRegex.FindStringSubmatch(l.Title); m != nil {
+		l.Location = m[1]
+	} else if loc := typologyRegex.Split(l.Title, 2); len(loc) == 2 {
+		l.Location = strings.TrimSpace(loc[1])
+	}
+
+	return l
+}
+
+// Locate sets the place of the listing from its location
+func (l *Listing) Locate(g *geo.Gazetteer) {
+	l.Place = nil
+	if p, ok := g.Find(l.Location); ok {
+		l.Place = &p
+	}
+}
+
+// ScoreExplanation returns the parts of the score in a single line, empty without scoring
+func (l Listing) ScoreExplanation() string {
+	var parts []string
+	for _, p := range l.ScoreParts {
+		parts = append(parts, fmt.Sprintf("%s %+g (%s)", p.Rule, p.Points, p.Detail))
+	}
+	return strings.Join(parts, ", ")
+}
+
+// Municipality returns the municipality of the place when the gazetteer found one
+// otherwise the last part of the location when it has more than one
+// "Glória e Vera Cruz, Aveiro" is Aveiro, a location without a comma is kept whole
+func (l Listing) Municipality() string {
+	if l.Place != nil && l.Place.Municipality != "" {
+		return l.Place.Municipality
+	}
+	parts := strings.Split(l.Location, ",")
+	m := strings.TrimSpace(parts[len(parts)-1])
+	if m == "" {
+		return "unknown"
+	}
+	return m
+}
@@ -0,0 +1,198 @@
+package logging
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Entry is a log line kept in the ring buffer
+type Entry struct {
+	Time    time.Time         `json:"time"`
+	Level   string            `json:"level"`
+	Message string            `json:"message"`
+	Attrs   map[string]string `json:"attrs,omitempty"`
+	// Text is the line as written by the text handler
+	Text string `json:"text"`
+}
+
+// Ring keeps the most recent log entries
+type Ring struct {
+	mu      sync.Mutex
+	entries []Entry
+	next    int
+	full    bool
+}
+
+// NewRing returns a ring buffer that keeps size entries
+func NewRing(size int) *Ring {
+	if size < 1 {
+		size = 1
+	}
+	return &Ring{entries: make([]Entry, size)}
+}
+
+func (r *Ring) add(e Entry) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.entries[r.next] = e
+	r.next = (r.next + 1) % len(r.entries)
+	if r.next == 0 {
+		r.full = true
+	}
+}
+
+// Entries returns the kept entries, oldest first
+func (r *Ring) Entries() []Entry {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if !r.full {
+		return append([]Entry(nil), r.entries[:r.next]...)
+	}
+	return append(append([]Entry(nil), r.entries[r.next:]...), r.entries[:r.next]...)
+}
+
+// ringHandler formats the records like the text handler and stores them in the ring
+type ringHandler struct {
+	ring   *Ring
+	level  slog.Leveler
+	attrs  []slog.Attr
+	groups []string
+}
+
+func (h *ringHandler) Enabled(_ context.Context, level slog.Level) bool {
+	return level >= h.level.Level()
+}
+
+func (h *ringHandler) Handle(ctx context.Context, r slog.Record) error {
+	e := Entry{Time: r.Time, Level: r.Level.String(), Message: r.Message, Attrs: map[string]string{}}
+
+	prefix := strings.Join(h.groups, ".")
+	add := func(a slog.Attr) bool {
+		key := a.Key
+		if prefix != "" {
+			key = prefix + "." + key
+		}
+		e.Attrs[key] = a.Value.String()
+		return true
+	}
+	for _, a := range h.attrs {
+		e.Attrs[a.Key] = a.Value.String()
+	}
+	r.Attrs(add)
+
+	// Reuses the text handler so the page shows the same lines as the console
+	var b bytes.Buffer
+	var th slog.Handler = slog.NewTextHandler(&b, &slog.HandlerOptions{Level: slog.LevelDebug})
+	th = th.WithAttrs(h.attrs)
+	for _, g := range h.groups {
+		th = th.WithGroup(g)
+	}
+	if err := th.Handle(ctx, r); err != nil {
+		return err
+	}
+	e.Text = strings.TrimSuffix(b.String(), "\n")
+
+	h.ring.add(e)
+	return nil
+}
+
+func (h *ringHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	c := *h
+	if len(h.groups) > 0 {
+		// Attributes inside a group are flattened with the group name
+		prefix := strings.Join(h.groups, ".") + "."
+		for _, a := range attrs {
+			c.attrs = append(append([]slog.Attr(nil), c.attrs...), slog.String(prefix+a.Key, a.Value.String()))
+		}
+		return &c
+	}
+	c.attrs = append(append([]slog.Attr(nil), h.attrs...), attrs...)
+	return &c
+}
+
+func (h *ringHandler) WithGroup(name string) slog.Handler {
+	c := *h
+	c.groups = append(append([]string(nil), h.groups...), name)
+	return &c
+}
+
+// multiHandler sends every record to all the handlers
+type multiHandler []slog.Handler
+
+func (m multiHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	for _, h := range m {
+		if h.Enabled(ctx, level) {
+			return true
+		}
+	}
+	return false
+}
+
+func (m multiHandler) Handle(ctx context.Context, r slog.Record) error {
+	var first error
+	for _, h := range m {
+		if !h.Enabled(ctx, r.Level) {
+			continue
+		}
+		if err := h.Handle(ctx, r.Clone()); err != nil && first == nil {
+			first = err
+		}
+	}
+	return first
+}
+
+func (m multiHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	c := make(multiHandler, len(m))
+	for i, h := range m {
+		c[i] = h.WithAttrs(attrs)
+	}
+	return c
+}
+
+func (m multiHandler) WithGroup(name string) slog.Handler {
+	c := make(multiHandler, len(m))
+	for i, h := range m {
+		c[i] = h.WithGroup(name)
+	}
+	return c
+}
+
+// ParseLevel turns debug, info, warn or error into a level, empty is info
+func ParseLevel(s string) (slog.Level, error) {
+	var level slog.Level
+	if s == "" {
+		return slog.LevelInfo, nil
+	}
+	if err := level.UnmarshalText([]byte(s)); err != nil {
+		return 0, fmt.Errorf("unknown log level %q", s)
+	}
+	return level, nil
+}
+
+// New returns a logger that writes to w as text or json and keeps the records in ring
+func New(w io.Writer, level slog.Level, format string, ring *Ring) (*slog.Logger, error) {
+	opts := &slog.HandlerOptions{Level: level}
+
+	var h slog.Handler
+	switch format {
+	case "", "text":
+		h = slog.NewTextHandler(w, opts)
+	case "json":
+		h = slog.NewJSONHandler(w, opts)
+	default:
+		return nil, fmt.Errorf("unknown log format %q", format)
+	}
+
+	if ring != nil {
+		h = multiHandler{h, &ringHandler{ring: ring, level: level}}
+	}
+	return slog.New(h), nil
+}
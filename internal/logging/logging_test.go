@@ -0,0 +1,50 @@
+package logging
+
+import (
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"strings"
+	"testing"
+)
+
+// Test the New function
+func TestNew(t *testing.T) {
+	ring := NewRing(2)
+	var out bytes.Buffer
+	logger, err := New(&out, slog.LevelInfo, "json", ring)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	run := logger.With("run_id", "20241019-235900-1a2b3c4d")
+	run.Debug("Fetched message", "uid", 1)
+	run.Info("Processed message", "uid", 2)
+	run.Warn("Error while getting snippet", "uid", 3)
+	logger.Error("Error while saving the outbox")
+
+	// Only the last two lines over the level are kept
+	entries := ring.Entries()
+	if len(entries) != 2 {
+		t.Fatalf("expected 2 entries, got %+v", entries)
+	}
+	if entries[0].Message != "Error while getting snippet" || entries[0].Attrs["run_id"] != "20241019-235900-1a2b3c4d" || entries[0].Attrs["uid"] != "3" {
+		t.Errorf("unexpected first entry %+v", entries[0])
+	}
+	if !strings.Contains(entries[0].Text, "level=WARN") || entries[1].Level != "ERROR" {
+		t.Errorf("unexpected entries %+v", entries)
+	}
+
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	if len(lines) != 3 {
+		t.Fatalf("expected 3 lines written, got %q", out.String())
+	}
+	var first map[string]interface{}
+	if err := json.Unmarshal([]byte(lines[0]), &first); err != nil || first["run_id"] != "20241019-235900-1a2b3c4d" {
+		t.Errorf("expected json lines with the run id, got %q (%v)", lines[0], err)
+	}
+
+	if _, err := New(&out, slog.LevelInfo, "xml", nil); err == nil {
+		t.Errorf("expected an error for an unknown format")
+	}
+}
@@ -0,0 +1,119 @@
+package lookup
+
+import (
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// MaxDays is the longest range a lookup can ask for
+const MaxDays = 366
+
+// Request is the body of a lookup, exactly one of Date, From and To or LastDays must be set
+// dates are DD/MM/YYYY like the bot sends them, YYYY-MM-DD is also accepted
+type Request struct {
+	Date     string `json:"date"`
+	From     string `json:"from"`
+	To       string `json:"to"`
+	LastDays int    `json:"last_days"`
+	// Notify also sends the result to the notifiers
+	Notify bool `json:"notify"`
+}
+
+// Day is a day of the range and its daily page when there is one
+type Day struct {
+	Date  string `json:"date"`
+	Found bool   `json:"found"`
+	File  string `json:"file,omitempty"`
+}
+
+// Function that parses a date in one of the accepted layouts
+func parseDate(field string, s string, loc *time.Location) (time.Time, error) {
+	for _, layout := range []string{"02/01/2006", "2006-01-02"} {
+		if t, err := time.ParseInLocation(layout, s, loc); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("%s %q is not a DD/MM/YYYY date", field, s)
+}
+
+// Range validates the request and returns the first and the last day it covers
+// now is used for "last N days", which ends today
+func (r Request) Range(now time.Time) (time.Time, time.Time, error) {
+	set := 0
+	if r.Date != "" {
+		set++
+	}
+	if r.From != "" || r.To != "" {
+		set++
+	}
+	if r.LastDays != 0 {
+		set++
+	}
+	if set != 1 {
+		return time.Time{}, time.Time{}, fmt.Errorf("set exactly one of date, from and to or last_days")
+	}
+
+	loc := now.Location()
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, loc)
+
+	var from, to time.Time
+	var err error
+	switch {
+	case r.Date != "":
+		if from, err = parseDate("date", r.Date, loc); err != nil {
+			return time.Time{}, time.Time{}, err
+		}
+		to = from
+	case r.LastDays != 0:
+		if r.LastDays < 1 || r.LastDays > MaxDays {
+			return time.Time{}, time.Time{}, fmt.Errorf("last_days must be between 1 and %d", MaxDays)
+		}
+		to = today
+		from = today.AddDate(0, 0, -(r.LastDays - 1))
+	default:
+		if r.From == "" || r.To == "" {
+			return time.Time{}, time.Time{}, fmt.Errorf("a range needs both from and to")
+		}
+		if from, err = parseDate("from", r.From, loc); err != nil {
+			return time.Time{}, time.Time{}, err
+		}
+		if to, err = parseDate("to", r.To, loc); err != nil {
+			return time.Time{}, time.Time{}, err
+		}
+		if to.Before(from) {
+			return time.Time{}, time.Time{}, fmt.Errorf("from must not be after to")
+		}
+		// Rounded because of the days with 23 or 25 hours
+		if days := int(math.Round(to.Sub(from).Hours()/24)) + 1; days > MaxDays {
+			return time.Time{}, time.Time{}, fmt.Errorf("the range has %d days, the limit is %d", days, MaxDays)
+		}
+	}
+
+	return from, to, nil
+}
+
+// FileName returns the name of the daily page of a day
+func FileName(day time.Time) string {
+	return day.Format("2006-01-02") + "_serve.html"
+}
+
+// Find returns every day from from to to, with the daily pages found in dir
+func Find(dir string, from, to time.Time) ([]Day, error) {
+	var days []Day
+	for d := from; !d.After(to); d = d.AddDate(0, 0, 1) {
+		day := Day{Date: d.Format("2006-01-02")}
+		_, err := os.Stat(filepath.Join(dir, FileName(d)))
+		switch {
+		case err == nil:
+			day.Found = true
+			day.File = FileName(d)
+		case !os.IsNotExist(err):
+			return nil, err
+		}
+		days = append(days, day)
+	}
+	return days, nil
+}
@@ -0,0 +1,72 @@
+package lookup
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// Test the Range function
+func TestRange(t *testing.T) {
+	now := time.Date(2024, 9, 24, 15, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name     string
+		req      Request
+		wantFrom string
+		wantTo   string
+		wantErr  bool
+	}{
+		{"single date", Request{Date: "24/09/2024"}, "2024-09-24", "2024-09-24", false},
+		{"iso date", Request{Date: "2024-09-24"}, "2024-09-24", "2024-09-24", false},
+		{"range", Request{From: "01/09/2024", To: "03/09/2024"}, "2024-09-01", "2024-09-03", false},
+		{"last 7 days", Request{LastDays: 7}, "2024-09-18", "2024-09-24", false},
+		{"nothing", Request{}, "", "", true},
+		{"date and range", Request{Date: "24/09/2024", From: "01/09/2024", To: "03/09/2024"}, "", "", true},
+		{"malformed date", Request{Date: "24/09"}, "", "", true},
+		{"impossible date", Request{Date: "31/02/2024"}, "", "", true},
+		{"missing to", Request{From: "01/09/2024"}, "", "", true},
+		{"reversed range", Request{From: "03/09/2024", To: "01/09/2024"}, "", "", true},
+		{"range too long", Request{From: "01/01/2023", To: "03/09/2024"}, "", "", true},
+		{"negative days", Request{LastDays: -1}, "", "", true},
+		{"too many days", Request{LastDays: MaxDays + 1}, "", "", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			from, to, err := tt.req.Range(now)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("expected error %v, got %v", tt.wantErr, err)
+			}
+			if err != nil {
+				return
+			}
+			if got := from.Format("2006-01-02"); got != tt.wantFrom {
+				t.Errorf("expected from %s, got %s", tt.wantFrom, got)
+			}
+			if got := to.Format("2006-01-02"); got != tt.wantTo {
+				t.Errorf("expected to %s, got %s", tt.wantTo, got)
+			}
+		})
+	}
+}
+
+// Test the Find function
+func TestFind(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{"2024-09-01_serve.html", "2024-09-03_serve.html"} {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte("<html></html>"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	from := time.Date(2024, 9, 1, 0, 0, 0, 0, time.UTC)
+	days, err := Find(dir, from, from.AddDate(0, 0, 2))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(days) != 3 || !days[0].Found || days[1].Found || !days[2].Found || days[2].File != "2024-09-03_serve.html" {
+		t.Errorf("unexpected days %+v", days)
+	}
+}
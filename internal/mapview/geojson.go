@@ -0,0 +1,109 @@
+package mapview
+
+import (
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"math"
+	"os"
+	"strings"
+)
+
+// Shape is a polygon of a GeoJSON file, a ring is a list of [longitude, latitude]
+type Shape struct {
+	Name  string
+	Rings [][][]float64
+}
+
+// geoJSON is the part of a FeatureCollection that is used
+type geoJSON struct {
+	Features []struct {
+		Properties map[string]interface{} `json:"properties"`
+		Geometry   struct {
+			Type        string          `json:"type"`
+			Coordinates json.RawMessage `json:"coordinates"`
+		} `json:"geometry"`
+	} `json:"features"`
+}
+
+// The outlines of the municipalities are written with the gazetteer by internal/geo/gen from the CAOP parishes,
+// see the README. Until it is run with the CAOP files the collection is empty and the map only has the names
+//
+//go:embed outlines.geojson
+var bundledOutlines []byte
+
+// LoadOutlines reads the outlines of the GeoJSON file at path, or the bundled ones when path is empty
+func LoadOutlines(path string) ([]Shape, error) {
+	if path == "" {
+		return ParseOutlines(bundledOutlines)
+	}
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	shapes, err := ParseOutlines(b)
+	if err != nil {
+		return nil, fmt.Errorf("Error while parsing outlines %s: %w", path, err)
+	}
+	return shapes, nil
+}
+
+// ParseOutlines reads the Polygon and MultiPolygon features of a GeoJSON FeatureCollection,
+// the name of a shape is its "name" property
+func ParseOutlines(b []byte) ([]Shape, error) {
+	var doc geoJSON
+	if err := json.Unmarshal(b, &doc); err != nil {
+		return nil, err
+	}
+
+	var shapes []Shape
+	for _, f := range doc.Features {
+		name, _ := f.Properties["name"].(string)
+		switch f.Geometry.Type {
+		case "Polygon":
+			var rings [][][]float64
+			if err := json.Unmarshal(f.Geometry.Coordinates, &rings); err != nil {
+				return nil, fmt.Errorf("Error while parsing the outline of %q: %w", name, err)
+			}
+			shapes = append(shapes, Shape{Name: name, Rings: rings})
+		case "MultiPolygon":
+			var polygons [][][][]float64
+			if err := json.Unmarshal(f.Geometry.Coordinates, &polygons); err != nil {
+				return nil, fmt.Errorf("Error while parsing the outline of %q: %w", name, err)
+			}
+			s := Shape{Name: name}
+			for _, p := range polygons {
+				s.Rings = append(s.Rings, p...)
+			}
+			shapes = append(shapes, s)
+		}
+	}
+	return shapes, nil
+}
+
+// Function that returns the shape as an SVG path, false when it is outside of the map
+func (s Shape) path(pixel func(lat, lon float64) (float64, float64)) (Outline, bool) {
+	var d strings.Builder
+	minX, minY := math.Inf(1), math.Inf(1)
+	maxX, maxY := math.Inf(-1), math.Inf(-1)
+	for _, ring := range s.Rings {
+		for i, pos := range ring {
+			if len(pos) < 2 {
+				continue
+			}
+			x, y := pixel(pos[1], pos[0])
+			minX, maxX = math.Min(minX, x), math.Max(maxX, x)
+			minY, maxY = math.Min(minY, y), math.Max(maxY, y)
+			if i == 0 {
+				fmt.Fprintf(&d, "M%.1f %.1f", x, y)
+			} else {
+				fmt.Fprintf(&d, "L%.1f %.1f", x, y)
+			}
+		}
+		d.WriteString("Z")
+	}
+	if maxX < 0 || minX > Width || maxY < 0 || minY > Height {
+		return Outline{}, false
+	}
+	return Outline{Name: s.Name, Path: d.String()}, true
+}
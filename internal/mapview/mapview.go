@@ -0,0 +1,416 @@
+package mapview
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/BrunoTeixeira1996/gmah/internal/geo"
+	"github.com/BrunoTeixeira1996/gmah/internal/listing"
+	"github.com/BrunoTeixeira1996/gmah/internal/requests"
+)
+
+// Size of the map
+const (
+	Width  = 800
+	Height = 600
+	// Margin keeps the markers away from the edges
+	Margin = 40
+	// TileSize is the side of the tiles of the tile server
+	TileSize = 256
+	// MaxZoom keeps a few listings in the same parish from being drawn at street level,
+	// their coordinates are the parish centroid
+	MaxZoom = 14
+	// ClusterSize is the side of the squares whose listings are drawn as a single marker
+	ClusterSize = 40
+)
+
+// ColorBy is what the colour of the markers shows
+type ColorBy string
+
+const (
+	ByPrice  ColorBy = "price"
+	ByStatus ColorBy = "status"
+)
+
+// ParseColorBy returns the colouring named s, empty is by price per m²
+func ParseColorBy(s string) (ColorBy, error) {
+	switch ColorBy(s) {
+	case "", ByPrice:
+		return ByPrice, nil
+	case ByStatus:
+		return ByStatus, nil
+	}
+	return "", fmt.Errorf("unknown map colour %q (use price or status)", s)
+}
+
+// Config is the map section of the config file
+type Config struct {
+	// TileURL is a tile server like http://tiles.lan/{z}/{x}/{y}.png, without it the map is plain SVG
+	TileURL string `json:"tile_url"`
+	// Attribution is shown under the map, tile servers usually ask for one
+	Attribution string `json:"attribution"`
+	// Outlines is a GeoJSON file with the municipalities drawn on the plain SVG map instead of the bundled ones
+	Outlines string `json:"outlines"`
+}
+
+// Validate checks that the tile URL has the placeholders of the tile coordinates
+func (c Config) Validate() error {
+	if c.TileURL == "" {
+		return nil
+	}
+	for _, p := range []string{"{z}", "{x}", "{y}"} {
+		if !strings.Contains(c.TileURL, p) {
+			return fmt.Errorf("map tile_url %q has no %s", c.TileURL, p)
+		}
+	}
+	return nil
+}
+
+// Tile is an image of the tile server at X, Y on the map
+type Tile struct {
+	X, Y int
+	URL  string
+}
+
+// Outline is a shape of the GeoJSON file as an SVG path
+type Outline struct {
+	Name string
+	Path string
+}
+
+// Label is the name of a municipality at its seat, drawn when there are no tiles
+type Label struct {
+	X, Y int
+	Name string
+}
+
+// Marker is a listing, or a cluster of listings close to each other
+type Marker struct {
+	X, Y   int
+	Radius int
+	Color  string
+	// Records are the listings of the marker, a single one links to the listing
+	// and a cluster to the list of its listings under the map
+	Records []listing.Record
+	// Title is the text shown when the pointer is over the marker
+	Title string
+	// ID is the anchor of the list of a cluster
+	ID string
+	// Lat and Lon are the middle of the listings, ZoomTo is the zoom that spreads them,
+	// 0 when they share a point or the map can't zoom more
+	Lat, Lon float64
+	ZoomTo   int
+}
+
+// Legend is a colour of the markers and what it means
+type Legend struct {
+	Color string
+	Label string
+}
+
+// Map is everything drawn on the SVG, in pixels from the top left corner
+type Map struct {
+	Width, Height int
+	Zoom          int
+	Tiles         []Tile
+	Outlines      []Outline
+	Labels        []Label
+	Markers       []Marker
+	Legend        []Legend
+	// Unplaced is how many listings have no coordinates, so they are not on the map
+	Unplaced    int
+	Attribution string
+}
+
+// Options of a map
+type Options struct {
+	ColorBy     ColorBy
+	TileURL     string
+	Attribution string
+	// Outlines and Places are drawn when there is no tile server
+	Outlines []Shape
+	Places   []geo.Place
+	// Zoom shows the map at that zoom around Lat and Lon instead of fitting every listing, when it is not 0
+	Zoom     int
+	Lat, Lon float64
+}
+
+// ZoomStep is how much a cluster zooms in
+const ZoomStep = 2
+
+// Colours from cheap to expensive
+var priceColors = []string{"#1a9850", "#91cf60", "#fee08b", "#fc8d59", "#d73027"}
+
+// Colours of the statuses, in alphabetical order of the status
+var statusColors = []string{"#1f77b4", "#ff7f0e", "#2ca02c", "#d62728", "#9467bd", "#8c564b", "#e377c2", "#17becf"}
+
+// Colour of the listings without price per m² or status
+const unknownColor = "#888888"
+
+// Function that returns the position of a point in the world at zoom, in pixels (web mercator)
+func project(lat, lon float64, zoom int) (float64, float64) {
+	size := TileSize * math.Exp2(float64(zoom))
+	rad := lat * math.Pi / 180
+	x := (lon + 180) / 360 * size
+	y := (1 - math.Log(math.Tan(rad)+1/math.Cos(rad))/math.Pi) / 2 * size
+	return x, y
+}
+
+// Function that returns the biggest zoom where every point fits inside the margins and the top left corner of the map
+func fit(points [][2]float64) (int, float64, float64) {
+	if len(points) == 0 {
+		return 0, 0, 0
+	}
+	for zoom := MaxZoom; ; zoom-- {
+		minX, minY := math.Inf(1), math.Inf(1)
+		maxX, maxY := math.Inf(-1), math.Inf(-1)
+		for _, p := range points {
+			x, y := project(p[0], p[1], zoom)
+			minX, maxX = math.Min(minX, x), math.Max(maxX, x)
+			minY, maxY = math.Min(minY, y), math.Max(maxY, y)
+		}
+		if zoom == 0 || (maxX-minX <= Width-2*Margin && maxY-minY <= Height-2*Margin) {
+			return zoom, (minX+maxX)/2 - Width/2, (minY+maxY)/2 - Height/2
+		}
+	}
+}
+
+// Function that returns the colour of every record and the legend
+func colors(records []listing.Record, by ColorBy) ([]string, []Legend) {
+	out := make([]string, len(records))
+	var legend []Legend
+
+	if by == ByStatus {
+		statuses := map[string]bool{}
+		for _, r := range records {
+			if r.Status != "" {
+				statuses[r.Status] = true
+			}
+		}
+		names := make([]string, 0, len(statuses))
+		for s := range statuses {
+			names = append(names, s)
+		}
+		sort.Strings(names)
+		byName := map[string]string{}
+		for i, s := range names {
+			byName[s] = statusColors[i%len(statusColors)]
+			legend = append(legend, Legend{byName[s], s})
+		}
+		for i, r := range records {
+			out[i] = unknownColor
+			if c, ok := byName[r.Status]; ok {
+				out[i] = c
+			}
+		}
+		return out, append(legend, Legend{unknownColor, "Not followed"})
+	}
+
+	var perM2 []int
+	for _, r := range records {
+		if r.Price > 0 && r.Area > 0 {
+			perM2 = append(perM2, r.Price/r.Area)
+		}
+	}
+	sort.Ints(perM2)
+	// Quintiles, so every colour has about as many listings
+	var breaks []int
+	for k := 1; k < len(priceColors) && len(perM2) > 0; k++ {
+		b := perM2[len(perM2)*k/len(priceColors)]
+		if b > perM2[0] && (len(breaks) == 0 || b > breaks[len(breaks)-1]) {
+			breaks = append(breaks, b)
+		}
+	}
+	color := func(bucket int) string {
+		if len(breaks) == 0 {
+			return priceColors[0]
+		}
+		return priceColors[bucket*(len(priceColors)-1)/len(breaks)]
+	}
+	for i, r := range records {
+		out[i] = unknownColor
+		if r.Price > 0 && r.Area > 0 {
+			out[i] = color(sort.SearchInts(breaks, r.Price/r.Area+1))
+		}
+	}
+	if len(perM2) > 0 {
+		for i := 0; i <= len(breaks); i++ {
+			var label string
+			switch {
+			case len(breaks) == 0:
+				label = "All"
+			case i == 0:
+				label = "Under " + requests.FormatPrice(breaks[0])
+			case i == len(breaks):
+				label = requests.FormatPrice(breaks[i-1]) + " and more"
+			default:
+				label = requests.FormatPrice(breaks[i-1]) + " to " + requests.FormatPrice(breaks[i])
+			}
+			legend = append(legend, Legend{color(i), label + " per m²"})
+		}
+	}
+	return out, append(legend, Legend{unknownColor, "No price per m²"})
+}
+
+// Function that returns the text shown over a marker
+func title(records []listing.Record) string {
+	var lines []string
+	if len(records) > 1 {
+		lines = append(lines, strconv.Itoa(len(records))+" listings")
+	}
+	for i, r := range records {
+		if i == 10 {
+			lines = append(lines, fmt.Sprintf("and %d more", len(records)-i))
+			break
+		}
+		line := r.Title
+		if r.Price > 0 {
+			line += " " + requests.FormatPrice(r.Price)
+		}
+		if len(r.ScoreParts) > 0 {
+			line += fmt.Sprintf(" score %d", r.Score)
+		}
+		if r.Status != "" {
+			line += " (" + r.Status + ")"
+		}
+		lines = append(lines, line)
+	}
+	return strings.Join(lines, "\n")
+}
+
+// Build draws the records that have coordinates, the listings closer than ClusterSize pixels
+// are drawn as a single marker with the colour most of them have
+func Build(records []listing.Record, opts Options) Map {
+	m := Map{Width: Width, Height: Height, Attribution: opts.Attribution}
+
+	var placed []listing.Record
+	var points [][2]float64
+	for _, r := range records {
+		if r.Place == nil {
+			m.Unplaced++
+			continue
+		}
+		placed = append(placed, r)
+		points = append(points, [2]float64{r.Place.Lat, r.Place.Lon})
+	}
+	// Without listings the map shows the places the gazetteer knows
+	if len(points) == 0 {
+		for _, p := range opts.Places {
+			if p.Municipality != "" && p.Parish == "" {
+				points = append(points, [2]float64{p.Lat, p.Lon})
+			}
+		}
+	}
+	zoom, left, top := fit(points)
+	if opts.Zoom > 0 {
+		zoom = opts.Zoom
+		x, y := project(opts.Lat, opts.Lon, zoom)
+		left, top = x-Width/2, y-Height/2
+	}
+	m.Zoom = zoom
+	pixel := func(lat, lon float64) (float64, float64) {
+		x, y := project(lat, lon, zoom)
+		return x - left, y - top
+	}
+	inside := func(x, y float64) bool { return x >= 0 && x <= Width && y >= 0 && y <= Height }
+
+	if opts.TileURL != "" {
+		n := 1 << uint(zoom)
+		for ty := int(math.Floor(top / TileSize)); float64(ty*TileSize) < top+Height; ty++ {
+			if ty < 0 || ty >= n {
+				continue
+			}
+			for tx := int(math.Floor(left / TileSize)); float64(tx*TileSize) < left+Width; tx++ {
+				url := strings.NewReplacer("{z}", strconv.Itoa(zoom), "{x}", strconv.Itoa(((tx%n)+n)%n), "{y}", strconv.Itoa(ty)).Replace(opts.TileURL)
+				m.Tiles = append(m.Tiles, Tile{X: int(math.Round(float64(tx*TileSize) - left)), Y: int(math.Round(float64(ty*TileSize) - top)), URL: url})
+			}
+		}
+	} else {
+		for _, s := range opts.Outlines {
+			if o, ok := s.path(pixel); ok {
+				m.Outlines = append(m.Outlines, o)
+			}
+		}
+		for _, p := range opts.Places {
+			if p.Municipality == "" || p.Parish != "" {
+				continue
+			}
+			if x, y := pixel(p.Lat, p.Lon); inside(x, y) {
+				m.Labels = append(m.Labels, Label{X: int(math.Round(x)), Y: int(math.Round(y)), Name: p.Municipality})
+			}
+		}
+	}
+
+	cols, legend := colors(placed, opts.ColorBy)
+	m.Legend = legend
+	rank := map[string]int{}
+	for i, l := range legend {
+		rank[l.Color] = i
+	}
+
+	type cluster struct {
+		x, y     float64
+		lat, lon float64
+		records  []listing.Record
+		colors   map[string]int
+		points   map[[2]float64]bool
+	}
+	cells := map[[2]int]*cluster{}
+	var order [][2]int
+	for i, r := range placed {
+		x, y := pixel(r.Place.Lat, r.Place.Lon)
+		// A zoomed map leaves out the listings around it, they are still in the table
+		if !inside(x, y) {
+			continue
+		}
+		cell := [2]int{int(math.Floor(x / ClusterSize)), int(math.Floor(y / ClusterSize))}
+		c, ok := cells[cell]
+		if !ok {
+			c = &cluster{colors: map[string]int{}, points: map[[2]float64]bool{}}
+			cells[cell] = c
+			order = append(order, cell)
+		}
+		c.x += x
+		c.y += y
+		c.lat += r.Place.Lat
+		c.lon += r.Place.Lon
+		c.points[[2]float64{r.Place.Lat, r.Place.Lon}] = true
+		c.records = append(c.records, r)
+		c.colors[cols[i]]++
+	}
+	for _, cell := range order {
+		c := cells[cell]
+		n := len(c.records)
+		best := ""
+		for color, count := range c.colors {
+			if best == "" || count > c.colors[best] || (count == c.colors[best] && rank[color] < rank[best]) {
+				best = color
+			}
+		}
+		radius := 6
+		if n > 1 {
+			radius = 9 + int(math.Min(11, 3*math.Log2(float64(n))))
+		}
+		mk := Marker{
+			X:       int(math.Round(c.x / float64(n))),
+			Y:       int(math.Round(c.y / float64(n))),
+			Radius:  radius,
+			Color:   best,
+			Records: c.records,
+			Title:   title(c.records),
+			Lat:     c.lat / float64(n),
+			Lon:     c.lon / float64(n),
+		}
+		if n > 1 {
+			mk.ID = fmt.Sprintf("cluster-%d", len(m.Markers)+1)
+			if len(c.points) > 1 && zoom < MaxZoom {
+				mk.ZoomTo = int(math.Min(float64(zoom+ZoomStep), MaxZoom))
+			}
+		}
+		m.Markers = append(m.Markers, mk)
+	}
+	return m
+}
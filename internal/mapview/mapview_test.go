@@ -0,0 +1,170 @@
+package mapview
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/BrunoTeixeira1996/gmah/internal/geo"
+	"github.com/BrunoTeixeira1996/gmah/internal/listing"
+)
+
+// Test the Build function
+func TestBuild(t *testing.T) {
+	esgueira := &geo.Place{ID: "aveiro/aveiro/esgueira", District: "Aveiro", Municipality: "Aveiro", Parish: "Esgueira", Lat: 40.65, Lon: -8.63}
+	ilhavo := &geo.Place{ID: "aveiro/ilhavo", District: "Aveiro", Municipality: "Ílhavo", Lat: 40.60, Lon: -8.6667}
+	anadia := &geo.Place{ID: "aveiro/anadia", District: "Aveiro", Municipality: "Anadia", Lat: 40.4386, Lon: -8.4356}
+
+	records := []listing.Record{
+		{Key: "a", Listing: listing.Listing{Title: "Moradia T3", Price: 200000, Area: 100, Link: "https://a", Place: esgueira}},
+		{Key: "b", Listing: listing.Listing{Title: "Moradia T4", Price: 300000, Area: 100, Place: esgueira}, Status: "visit"},
+		{Key: "c", Listing: listing.Listing{Title: "Apartamento T2", Price: 150000, Area: 100, Link: "https://c", Place: anadia}},
+		{Key: "d", Listing: listing.Listing{Title: "Terreno"}},
+	}
+	places := []geo.Place{*ilhavo, {ID: "porto", District: "Porto", Lat: 41.1579, Lon: -8.6291}}
+
+	tests := []struct {
+		name        string
+		opts        Options
+		wantColors  map[int]string
+		wantTiles   bool
+		wantLabels  int
+		wantLegends int
+	}{
+		{
+			"by price without tiles",
+			Options{ColorBy: ByPrice, Places: places},
+			// The cluster in Esgueira has a 2000 and a 3000 per m², the cheapest colour wins the tie
+			map[int]string{2: "#fee08b", 1: "#1a9850"},
+			false, 1, 4,
+		},
+		{
+			"by status with tiles",
+			Options{ColorBy: ByStatus, TileURL: "http://tiles/{z}/{x}/{y}.png", Places: places},
+			map[int]string{2: "#1f77b4", 1: unknownColor},
+			true, 0, 2,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := Build(records, tt.opts)
+
+			if m.Unplaced != 1 {
+				t.Errorf("expected 1 listing without place, got %d", m.Unplaced)
+			}
+			if len(m.Markers) != 2 {
+				t.Fatalf("expected a cluster and a marker, got %v", m.Markers)
+			}
+			for _, mk := range m.Markers {
+				if mk.X < Margin || mk.X > Width-Margin || mk.Y < Margin || mk.Y > Height-Margin {
+					t.Errorf("expected %v inside the margins", mk)
+				}
+				if want := tt.wantColors[len(mk.Records)]; mk.Color != want {
+					t.Errorf("expected the marker of %d listings to be %s, got %s", len(mk.Records), want, mk.Color)
+				}
+			}
+			if (len(m.Tiles) > 0) != tt.wantTiles {
+				t.Errorf("expected tiles %v, got %v", tt.wantTiles, m.Tiles)
+			}
+			for _, tile := range m.Tiles {
+				if strings.Contains(tile.URL, "{") {
+					t.Errorf("expected a tile URL without placeholders, got %s", tile.URL)
+				}
+			}
+			// Ílhavo is between Esgueira and Anadia, Porto is outside
+			if len(m.Labels) != tt.wantLabels {
+				t.Errorf("expected %d labels, got %v", tt.wantLabels, m.Labels)
+			}
+			if len(m.Legend) != tt.wantLegends {
+				t.Errorf("expected %d legend entries, got %v", tt.wantLegends, m.Legend)
+			}
+		})
+	}
+}
+
+// Test the LoadOutlines function
+func TestLoadOutlines(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "outlines.geojson")
+	doc := `{"type": "FeatureCollection", "features": [
+		{"type": "Feature", "properties": {"name": "Aveiro"}, "geometry": {"type": "Polygon", "coordinates": [[[-8.7, 40.6], [-8.6, 40.6], [-8.6, 40.7], [-8.7, 40.6]]]}},
+		{"type": "Feature", "properties": {"name": "Ílhavo"}, "geometry": {"type": "MultiPolygon", "coordinates": [[[[-8.7, 40.5], [-8.6, 40.5], [-8.6, 40.6, 10]]], [[[-8.8, 40.5], [-8.75, 40.5], [-8.75, 40.55]]]]}},
+		{"type": "Feature", "properties": {"name": "Marker"}, "geometry": {"type": "Point", "coordinates": [-8.7, 40.6]}}
+	]}`
+	if err := os.WriteFile(path, []byte(doc), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	shapes, err := LoadOutlines(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(shapes) != 2 || shapes[0].Name != "Aveiro" || len(shapes[1].Rings) != 2 {
+		t.Fatalf("expected a polygon and a multipolygon, got %v", shapes)
+	}
+
+	m := Build(nil, Options{Outlines: shapes, Places: []geo.Place{{Municipality: "Aveiro", Lat: 40.64, Lon: -8.65}, {Municipality: "Vagos", Lat: 40.55, Lon: -8.68}}})
+	if len(m.Outlines) != 2 || !strings.HasPrefix(m.Outlines[0].Path, "M") {
+		t.Errorf("expected 2 outlines, got %v", m.Outlines)
+	}
+}
+
+// Test the LoadOutlines function without a file, the bundled outlines are read
+func TestLoadOutlinesBundled(t *testing.T) {
+	if _, err := LoadOutlines(""); err != nil {
+		t.Fatalf("expected the bundled outlines to parse, got %v", err)
+	}
+	if _, err := LoadOutlines(filepath.Join(t.TempDir(), "missing.geojson")); err == nil {
+		t.Error("expected an error for a missing outlines file")
+	}
+}
+
+// Test the Build function zoomed around a cluster
+func TestBuildZoom(t *testing.T) {
+	esgueira := &geo.Place{ID: "aveiro/aveiro/esgueira", Municipality: "Aveiro", Parish: "Esgueira", Lat: 40.65, Lon: -8.63}
+	gloria := &geo.Place{ID: "aveiro/aveiro/gloria-e-vera-cruz", Municipality: "Aveiro", Parish: "Glória e Vera Cruz", Lat: 40.641, Lon: -8.652}
+	anadia := &geo.Place{ID: "aveiro/anadia", Municipality: "Anadia", Lat: 40.4386, Lon: -8.4356}
+
+	records := []listing.Record{
+		{Key: "a", Listing: listing.Listing{Title: "Moradia T3", Place: esgueira}},
+		{Key: "b", Listing: listing.Listing{Title: "Moradia T4", Place: esgueira}},
+		{Key: "c", Listing: listing.Listing{Title: "Apartamento T2", Place: gloria}},
+		{Key: "d", Listing: listing.Listing{Title: "Terreno", Place: anadia}},
+	}
+
+	m := Build(records, Options{})
+	var cluster Marker
+	for _, mk := range m.Markers {
+		if len(mk.Records) == 1 && mk.ID != "" {
+			t.Errorf("expected a single listing without an anchor, got %s", mk.ID)
+		}
+		if len(mk.Records) == 3 {
+			cluster = mk
+		}
+	}
+	if cluster.ID == "" || cluster.ZoomTo != m.Zoom+ZoomStep {
+		t.Fatalf("expected a cluster of Aveiro that zooms from %d, got %+v", m.Zoom, cluster)
+	}
+
+	zoomed := Build(records, Options{Zoom: cluster.ZoomTo, Lat: cluster.Lat, Lon: cluster.Lon})
+	if zoomed.Zoom != cluster.ZoomTo || len(zoomed.Markers) == 0 {
+		t.Fatalf("expected the listings of Aveiro at zoom %d, got %+v", cluster.ZoomTo, zoomed)
+	}
+	n := 0
+	for _, mk := range zoomed.Markers {
+		n += len(mk.Records)
+		if mk.X < 0 || mk.X > Width || mk.Y < 0 || mk.Y > Height {
+			t.Errorf("expected %v inside the map", mk)
+		}
+	}
+	if n != 3 {
+		t.Errorf("expected the 3 listings of Aveiro without Anadia, got %d", n)
+	}
+
+	// The listings of a single place can't be spread by zooming
+	last := Build(records[:2], Options{})
+	if len(last.Markers) != 1 || last.Markers[0].ZoomTo != 0 {
+		t.Errorf("expected a cluster without zoom, got %+v", last.Markers)
+	}
+}
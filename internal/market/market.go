@@ -0,0 +1,163 @@
+package market
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/BrunoTeixeira1996/gmah/internal/listing"
+)
+
+// Config is the market section of the config file
+type Config struct {
+	// Days is how far back the listings of the medians go, defaults to 180
+	Days int `json:"days"`
+	// MinSample is how many listings a median needs, defaults to 3
+	MinSample int `json:"min_sample"`
+}
+
+// Defaults fills the fields that were not set
+func (c *Config) Defaults() {
+	if c.Days == 0 {
+		c.Days = 180
+	}
+	if c.MinSample == 0 {
+		c.MinSample = 3
+	}
+}
+
+// Validate checks the window and the sample size
+func (c Config) Validate() error {
+	if c.Days < 0 {
+		return fmt.Errorf("market days must not be negative")
+	}
+	if c.MinSample < 0 {
+		return fmt.Errorf("market min_sample must not be negative")
+	}
+	return nil
+}
+
+// group is an area, the ID of a parish or a municipality, and a typology, empty for every typology
+type group struct {
+	area     string
+	typology string
+}
+
+// sample is the price per m² of a listing
+type sample struct {
+	key   string
+	perM2 int
+}
+
+// Index has the prices per m² of the recent listings by area and typology
+type Index struct {
+	minSample int
+	samples   map[group][]sample
+}
+
+// Function that returns the price per m² of a listing, false when the price or the area are unknown
+func perM2(l listing.Listing) (int, bool) {
+	if l.Price <= 0 || l.Area <= 0 {
+		return 0, false
+	}
+	return l.Price / l.Area, true
+}
+
+// Function that returns the groups of a listing from the narrowest to the widest with the name of their area:
+// its parish and typology, its municipality and typology and its municipality
+// a listing located only to the district has none
+func groups(l listing.Listing) (gs []group, names []string) {
+	if l.Place == nil || l.Place.Municipality == "" {
+		return nil, nil
+	}
+	// The ID of a parish is the ID of its municipality followed by its own name
+	parts := strings.Split(l.Place.ID, "/")
+	if len(parts) < 2 {
+		return nil, nil
+	}
+	municipality := strings.Join(parts[:2], "/")
+
+	if l.Typology != "" {
+		if l.Place.Parish != "" {
+			gs, names = append(gs, group{l.Place.ID, l.Typology}), append(names, l.Place.Parish)
+		}
+		gs, names = append(gs, group{municipality, l.Typology}), append(names, l.Place.Municipality)
+	}
+	return append(gs, group{municipality, ""}), append(names, l.Place.Municipality)
+}
+
+// Build returns the index of the records seen in the last days before now that have a price and an area
+// the records of the same house on another portal are left out, the first one already counts
+func (c Config) Build(records []listing.Record, now time.Time) *Index {
+	ix := &Index{minSample: c.MinSample, samples: map[group][]sample{}}
+	since := now.AddDate(0, 0, -c.Days)
+	for _, r := range records {
+		if r.Group != "" || r.LastSeen.Before(since) {
+			continue
+		}
+		v, ok := perM2(r.Listing)
+		if !ok {
+			continue
+		}
+		gs, _ := groups(r.Listing)
+		for _, g := range gs {
+			ix.samples[g] = append(ix.samples[g], sample{r.Key, v})
+		}
+	}
+	return ix
+}
+
+// Function that returns the median of values, which must not be empty
+func median(values []int) int {
+	sort.Ints(values)
+	n := len(values)
+	if n%2 == 1 {
+		return values[n/2]
+	}
+	return (values[n/2-1] + values[n/2]) / 2
+}
+
+// Compare returns how the price per m² of a listing compares to the narrowest of its groups with enough
+// listings, the listing itself is not part of the median
+// false when the listing has no price, area or place or no group has enough listings
+func (ix *Index) Compare(l listing.Listing) (listing.Comparison, bool) {
+	v, ok := perM2(l)
+	if !ok {
+		return listing.Comparison{}, false
+	}
+
+	key := l.Key()
+	gs, names := groups(l)
+	for i, g := range gs {
+		var values []int
+		for _, s := range ix.samples[g] {
+			if s.key != key {
+				values = append(values, s.perM2)
+			}
+		}
+		if len(values) == 0 || len(values) < ix.minSample {
+			continue
+		}
+		m := median(values)
+		if m == 0 {
+			continue
+		}
+		return listing.Comparison{
+			Area:      names[i],
+			Typology:  g.typology,
+			Median:    m,
+			Sample:    len(values),
+			Deviation: float64(v-m) / float64(m),
+		}, true
+	}
+	return listing.Comparison{}, false
+}
+
+// Apply sets the comparison of a listing, nil when there is none
+func (ix *Index) Apply(l *listing.Listing) {
+	l.Market = nil
+	if c, ok := ix.Compare(*l); ok {
+		l.Market = &c
+	}
+}
@@ -0,0 +1,96 @@
+package market
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/BrunoTeixeira1996/gmah/internal/geo"
+	"github.com/BrunoTeixeira1996/gmah/internal/listing"
+)
+
+var (
+	esgueira = &geo.Place{ID: "aveiro/aveiro/esgueira", District: "Aveiro", Municipality: "Aveiro", Parish: "Esgueira"}
+	aradas   = &geo.Place{ID: "aveiro/aveiro/aradas", District: "Aveiro", Municipality: "Aveiro", Parish: "Aradas"}
+	aveiro   = &geo.Place{ID: "aveiro/aveiro", District: "Aveiro", Municipality: "Aveiro"}
+	district = &geo.Place{ID: "aveiro", District: "Aveiro"}
+)
+
+// Function that returns a record of 100 m² seen days before now
+func record(n int, place *geo.Place, typology string, perM2 int, days int, now time.Time) listing.Record {
+	return listing.Record{
+		Listing:  listing.Listing{Portal: "idealista", Title: "Casa " + typology, Typology: typology, Price: perM2 * 100, Area: 100, Link: fmt.Sprintf("https://www.idealista.pt/imovel/%d/", n), Place: place},
+		Key:      fmt.Sprintf("idealista|www.idealista.pt/imovel/%d", n),
+		LastSeen: now.AddDate(0, 0, -days),
+	}
+}
+
+// Test the Compare function
+func TestCompare(t *testing.T) {
+	now := time.Date(2024, 9, 30, 9, 0, 0, 0, time.UTC)
+	records := []listing.Record{
+		// Three T3 in Esgueira, the old one is out of the window
+		record(1, esgueira, "T3", 2000, 10, now),
+		record(2, esgueira, "T3", 2200, 20, now),
+		record(3, esgueira, "T3", 2400, 30, now),
+		record(4, esgueira, "T3", 9000, 400, now),
+		// A T3 in Aradas and T2 around Aveiro
+		record(5, aradas, "T3", 1800, 5, now),
+		record(6, aradas, "T2", 2500, 5, now),
+		record(7, aveiro, "T2", 2600, 5, now),
+	}
+	// The same house on another portal is counted once
+	dup := record(8, aradas, "T2", 100, 5, now)
+	dup.Group = records[5].Key
+	records = append(records, dup)
+
+	c := Config{}
+	c.Defaults()
+	ix := c.Build(records, now)
+
+	tests := []struct {
+		name    string
+		listing listing.Listing
+		want    string
+		wantOk  bool
+	}{
+		{
+			"parish and typology",
+			listing.Listing{Portal: "CasaYes", Title: "Moradia T3", Typology: "T3", Price: 198000, Area: 100, Link: "https://casayes.pt/1", Place: esgueira},
+			"10% below Esgueira T3 median", true,
+		},
+		{
+			// Without itself Esgueira has two, Aveiro has 2000, 2400 and 1800
+			"known listing falls back to the municipality",
+			records[1].Listing,
+			"10% above Aveiro T3 median", true,
+		},
+		{
+			"municipality and every typology",
+			listing.Listing{Portal: "CasaYes", Title: "Moradia T4", Typology: "T4", Price: 230000, Area: 100, Link: "https://casayes.pt/2", Place: aradas},
+			"at the Aveiro median", true,
+		},
+		{
+			"unknown area",
+			listing.Listing{Portal: "CasaYes", Title: "Moradia T3", Typology: "T3", Price: 198000, Link: "https://casayes.pt/3", Place: esgueira},
+			"", false,
+		},
+		{
+			"only the district",
+			listing.Listing{Portal: "CasaYes", Title: "Moradia T3", Typology: "T3", Price: 198000, Area: 100, Link: "https://casayes.pt/4", Place: district},
+			"", false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := ix.Compare(tt.listing)
+			if ok != tt.wantOk {
+				t.Fatalf("expected a comparison %v, got %v", tt.wantOk, got)
+			}
+			if ok && got.String() != tt.want {
+				t.Errorf("expected %q, got %q (%+v)", tt.want, got.String(), got)
+			}
+		})
+	}
+}
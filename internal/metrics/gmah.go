@@ -0,0 +1,21 @@
+package metrics
+
+// Metrics exposed by gmah
+var (
+	Runs = NewCounter("gmah_runs_total",
+		"Runs that finished by trigger and outcome.", "trigger", "outcome")
+	RunDuration = NewHistogram("gmah_run_duration_seconds",
+		"Duration of the runs by trigger and outcome.", DurationBuckets, "trigger", "outcome")
+	LastSuccessfulRun = NewGauge("gmah_last_successful_run_timestamp_seconds",
+		"Unix time of the end of the last run that did not fail.")
+	IMAPFetchDuration = NewHistogram("gmah_imap_fetch_duration_seconds",
+		"Time to log in and fetch the unread messages by outcome.", DurationBuckets, "outcome")
+	Messages = NewCounter("gmah_messages_processed_total",
+		"Messages fetched from the mailbox.")
+	Listings = NewCounter("gmah_listings_extracted_total",
+		"Listings extracted from the messages by portal.", "portal")
+	ParseFailures = NewCounter("gmah_parse_failures_total",
+		"Messages where a link, a price or a title could not be extracted by portal.", "portal")
+	Notifications = NewCounter("gmah_notifications_total",
+		"Notification deliveries by notifier and result.", "notifier", "result")
+)
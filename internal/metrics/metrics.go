@@ -0,0 +1,126 @@
+// Package metrics exposes the gmah metrics to Prometheus with client_golang
+// the metrics take their label values in the order of their labels, like Runs.Inc("cron", "done")
+package metrics
+
+import (
+	"net/http"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	dto "github.com/prometheus/client_model/go"
+)
+
+// Registry holds metrics and serves them in the Prometheus text format
+type Registry struct {
+	reg *prometheus.Registry
+}
+
+// NewRegistry returns an empty registry
+func NewRegistry() *Registry {
+	return &Registry{reg: prometheus.NewRegistry()}
+}
+
+// Default is the registry exposed by gmah at /metrics, with the Go runtime and process metrics
+var Default = func() *Registry {
+	r := NewRegistry()
+	r.reg.MustRegister(collectors.NewGoCollector(), collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}))
+	return r
+}()
+
+// Handler serves the registry to Prometheus
+func (r *Registry) Handler() http.HandlerFunc {
+	return promhttp.HandlerFor(r.reg, promhttp.HandlerOpts{}).ServeHTTP
+}
+
+// Counter is a value that only goes up
+type Counter struct {
+	vec *prometheus.CounterVec
+}
+
+// NewCounter registers a counter in the default registry
+func NewCounter(name, help string, labels ...string) *Counter {
+	return Default.NewCounter(name, help, labels...)
+}
+
+// NewCounter registers a counter in the registry, names must be unique
+func (r *Registry) NewCounter(name, help string, labels ...string) *Counter {
+	c := &Counter{prometheus.NewCounterVec(prometheus.CounterOpts{Name: name, Help: help}, labels)}
+	r.reg.MustRegister(c.vec)
+	return c
+}
+
+// Add increases the counter with the given label values by delta, which must not be negative
+func (c *Counter) Add(delta float64, labels ...string) {
+	c.vec.WithLabelValues(labels...).Add(delta)
+}
+
+// Inc increases the counter with the given label values by one
+func (c *Counter) Inc(labels ...string) {
+	c.vec.WithLabelValues(labels...).Inc()
+}
+
+// Value returns the current value of the counter
+func (c *Counter) Value(labels ...string) float64 {
+	var m dto.Metric
+	if err := c.vec.WithLabelValues(labels...).Write(&m); err != nil {
+		return 0
+	}
+	return m.GetCounter().GetValue()
+}
+
+// Gauge is a value that can go up and down
+type Gauge struct {
+	vec *prometheus.GaugeVec
+}
+
+// NewGauge registers a gauge in the default registry
+func NewGauge(name, help string, labels ...string) *Gauge {
+	return Default.NewGauge(name, help, labels...)
+}
+
+// NewGauge registers a gauge in the registry, names must be unique
+func (r *Registry) NewGauge(name, help string, labels ...string) *Gauge {
+	g := &Gauge{prometheus.NewGaugeVec(prometheus.GaugeOpts{Name: name, Help: help}, labels)}
+	r.reg.MustRegister(g.vec)
+	return g
+}
+
+// Set sets the gauge with the given label values
+func (g *Gauge) Set(value float64, labels ...string) {
+	g.vec.WithLabelValues(labels...).Set(value)
+}
+
+// Value returns the current value of the gauge
+func (g *Gauge) Value(labels ...string) float64 {
+	var m dto.Metric
+	if err := g.vec.WithLabelValues(labels...).Write(&m); err != nil {
+		return 0
+	}
+	return m.GetGauge().GetValue()
+}
+
+// Histogram counts observations in cumulative buckets
+type Histogram struct {
+	vec *prometheus.HistogramVec
+}
+
+// DurationBuckets fit things that take from a few milliseconds to a few minutes, in seconds
+var DurationBuckets = []float64{0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60, 120, 300}
+
+// NewHistogram registers a histogram in the default registry
+func NewHistogram(name, help string, buckets []float64, labels ...string) *Histogram {
+	return Default.NewHistogram(name, help, buckets, labels...)
+}
+
+// NewHistogram registers a histogram in the registry, names must be unique
+func (r *Registry) NewHistogram(name, help string, buckets []float64, labels ...string) *Histogram {
+	h := &Histogram{prometheus.NewHistogramVec(prometheus.HistogramOpts{Name: name, Help: help, Buckets: buckets}, labels)}
+	r.reg.MustRegister(h.vec)
+	return h
+}
+
+// Observe adds an observation to the histogram with the given label values
+func (h *Histogram) Observe(value float64, labels ...string) {
+	h.vec.WithLabelValues(labels...).Observe(value)
+}
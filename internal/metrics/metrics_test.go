@@ -0,0 +1,62 @@
+package metrics
+
+import (
+	"net/http/httptest"
+	"testing"
+
+	"github.com/prometheus/common/expfmt"
+)
+
+// Test the Handler function
+func TestHandler(t *testing.T) {
+	r := NewRegistry()
+	runs := r.NewCounter("test_runs_total", "Runs.", "trigger", "outcome")
+	last := r.NewGauge("test_last_timestamp_seconds", "Last run.")
+	duration := r.NewHistogram("test_duration_seconds", "Duration.", []float64{0.5, 1}, "trigger")
+
+	runs.Inc("cron", "done")
+	runs.Inc("cron", "done")
+	runs.Inc("demand", `fa"il`)
+	last.Set(1700000000)
+	duration.Observe(0.2, "cron")
+	duration.Observe(0.7, "cron")
+	duration.Observe(3, "cron")
+
+	want := `# HELP test_duration_seconds Duration.
+# TYPE test_duration_seconds histogram
+test_duration_seconds_bucket{trigger="cron",le="0.5"} 1
+test_duration_seconds_bucket{trigger="cron",le="1"} 2
+test_duration_seconds_bucket{trigger="cron",le="+Inf"} 3
+test_duration_seconds_sum{trigger="cron"} 3.9
+test_duration_seconds_count{trigger="cron"} 3
+# HELP test_last_timestamp_seconds Last run.
+# TYPE test_last_timestamp_seconds gauge
+test_last_timestamp_seconds 1.7e+09
+# HELP test_runs_total Runs.
+# TYPE test_runs_total counter
+test_runs_total{outcome="done",trigger="cron"} 2
+test_runs_total{outcome="fa\"il",trigger="demand"} 1
+`
+
+	w := httptest.NewRecorder()
+	r.Handler()(w, httptest.NewRequest("GET", "/metrics", nil))
+	if w.Code != 200 || w.Body.String() != want {
+		t.Errorf("unexpected exposition (%d):\n%s\nwant:\n%s", w.Code, w.Body.String(), want)
+	}
+
+	// Prometheus must be able to read back what was served
+	var parser expfmt.TextParser
+	families, err := parser.TextToMetricFamilies(w.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(families) != 3 {
+		t.Errorf("expected 3 metric families, got %d", len(families))
+	}
+	if got := runs.Value("cron", "done"); got != 2 {
+		t.Errorf("expected 2 cron runs, got %v", got)
+	}
+	if got := last.Value(); got != 1700000000 {
+		t.Errorf("expected last run 1700000000, got %v", got)
+	}
+}
@@ -0,0 +1,130 @@
+package photos
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"math/bits"
+	"os"
+	"path/filepath"
+	"strconv"
+)
+
+// Hash is the perceptual hash of a photo, the same photo resized or recompressed by
+// another portal gets a hash a few bits away while different photos are far apart
+type Hash struct {
+	// A has a bit per cell of an 8x8 grid, set when the cell is brighter than the mean
+	A uint64
+	// D has a bit per cell of an 8x8 grid, set when the cell is brighter than the one on its right
+	D uint64
+}
+
+// Distances between hashes, out of 128 bits
+const (
+	// SameDistance is the farthest two hashes of the same photo are
+	SameDistance = 12
+	// NearDistance is the farthest two hashes of a photo cropped or retouched are,
+	// it is only a match with other signals
+	NearDistance = 24
+)
+
+// String returns the hash as 32 hex characters
+func (h Hash) String() string {
+	return fmt.Sprintf("%016x%016x", h.A, h.D)
+}
+
+// ParseHash parses a hash written by String
+func ParseHash(s string) (Hash, error) {
+	if len(s) != 32 {
+		return Hash{}, fmt.Errorf("photo hash %q must have 32 hex characters", s)
+	}
+	a, err := strconv.ParseUint(s[:16], 16, 64)
+	if err != nil {
+		return Hash{}, err
+	}
+	d, err := strconv.ParseUint(s[16:], 16, 64)
+	if err != nil {
+		return Hash{}, err
+	}
+	return Hash{A: a, D: d}, nil
+}
+
+// Distance returns how many bits of the two hashes are different
+func (h Hash) Distance(o Hash) int {
+	return bits.OnesCount64(h.A^o.A) + bits.OnesCount64(h.D^o.D)
+}
+
+// Function that returns the brightness of a w x h grid over the image, every cell is the average of its pixels
+func grid(img image.Image, w, h int) []float64 {
+	b := img.Bounds()
+	cells := make([]float64, w*h)
+	for y := 0; y < h; y++ {
+		y0 := b.Min.Y + y*b.Dy()/h
+		y1 := b.Min.Y + (y+1)*b.Dy()/h
+		if y1 == y0 {
+			y1++
+		}
+		for x := 0; x < w; x++ {
+			x0 := b.Min.X + x*b.Dx()/w
+			x1 := b.Min.X + (x+1)*b.Dx()/w
+			if x1 == x0 {
+				x1++
+			}
+			var sum float64
+			for sy := y0; sy < y1; sy++ {
+				for sx := x0; sx < x1; sx++ {
+					r, g, bl, _ := img.At(sx, sy).RGBA()
+					sum += 0.299*float64(r) + 0.587*float64(g) + 0.114*float64(bl)
+				}
+			}
+			cells[y*w+x] = sum / float64((y1-y0)*(x1-x0))
+		}
+	}
+	return cells
+}
+
+// Compute returns the aHash and the dHash of an image
+func Compute(img image.Image) Hash {
+	var h Hash
+
+	cells := grid(img, 8, 8)
+	var mean float64
+	for _, c := range cells {
+		mean += c
+	}
+	mean /= float64(len(cells))
+	for i, c := range cells {
+		if c > mean {
+			h.A |= 1 << uint(i)
+		}
+	}
+
+	cells = grid(img, 9, 8)
+	for y := 0; y < 8; y++ {
+		for x := 0; x < 8; x++ {
+			if cells[y*9+x] > cells[y*9+x+1] {
+				h.D |= 1 << uint(y*8+x)
+			}
+		}
+	}
+	return h
+}
+
+// HashOf decodes a jpeg, png or gif photo and returns its hash
+func HashOf(photo []byte) (Hash, error) {
+	img, _, err := image.Decode(bytes.NewReader(photo))
+	if err != nil {
+		return Hash{}, ErrNotImage
+	}
+	return Compute(img), nil
+}
+
+// Hash returns the hash of the cached thumbnail of a photo URL
+// hashing the thumbnail instead of the photo gives the same hash for every size a portal serves
+func (c *Cache) Hash(url string) (Hash, error) {
+	thumb, err := os.ReadFile(filepath.Join(c.store.Dir(), Dir, Name(url)))
+	if err != nil {
+		return Hash{}, err
+	}
+	return HashOf(thumb)
+}
@@ -0,0 +1,317 @@
+package photos
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	_ "image/gif"
+	"image/jpeg"
+	_ "image/png"
+	"io"
+	"io/fs"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/BrunoTeixeira1996/gmah/internal/store"
+)
+
+// Dir is the directory inside the data directory where the thumbnails are written
+const Dir = "thumbnails"
+
+// Limits of the photos that are downloaded
+const (
+	// MaxBytes is the biggest photo that is downloaded
+	MaxBytes = 10 << 20
+	// MaxPixels keeps a tiny file that decodes to a huge image from using all the memory
+	MaxPixels = 40_000_000
+	// Width of the thumbnails, the height keeps the aspect ratio
+	Width = 320
+	// Timeout of a single download
+	Timeout = 20 * time.Second
+	// Parallel is how many photos are downloaded at the same time
+	Parallel = 4
+	// CacheLimit is how many bytes of thumbnails are kept, the least recently used go first past it
+	CacheLimit = 200 << 20
+)
+
+var (
+	// ErrTooBig is returned when the photo is bigger than the limits
+	ErrTooBig = errors.New("photo is too big")
+	// ErrNotImage is returned when the server does not answer with a supported image
+	ErrNotImage = errors.New("not a jpeg, png or gif image")
+	// ErrHostNotAllowed is returned for a photo, or a redirect, outside the hosts of the cache
+	ErrHostNotAllowed = errors.New("photo host is not allowed")
+)
+
+// Cache downloads the photos of the listings and keeps a small copy of them
+type Cache struct {
+	store  *store.Store
+	client *http.Client
+	hosts  []string
+	limit  int64
+}
+
+// NewCache returns a cache that writes to the thumbnails directory of the store
+// only the photos of hosts and their subdomains are downloaded, so an email can't
+// make gmah fetch an address of the LAN
+// client is used for the downloads, http.DefaultClient when nil
+func NewCache(s *store.Store, client *http.Client, hosts []string) *Cache {
+	if client == nil {
+		client = http.DefaultClient
+	}
+	c := &Cache{store: s, hosts: hosts, limit: CacheLimit}
+
+	// A photo of an allowed host can't redirect somewhere else either
+	checked := *client
+	checked.CheckRedirect = func(req *http.Request, via []*http.Request) error {
+		if !c.allowed(req.URL) {
+			return ErrHostNotAllowed
+		}
+		if client.CheckRedirect != nil {
+			return client.CheckRedirect(req, via)
+		}
+		if len(via) >= 10 {
+			return errors.New("stopped after 10 redirects")
+		}
+		return nil
+	}
+	c.client = &checked
+	return c
+}
+
+// Function that tells if u is an http or https URL of one of the hosts of the cache
+func (c *Cache) allowed(u *url.URL) bool {
+	if u.Scheme != "https" && u.Scheme != "http" {
+		return false
+	}
+	host := strings.ToLower(u.Hostname())
+	isIP := net.ParseIP(host) != nil
+	for _, h := range c.hosts {
+		if host == h || (!isIP && strings.HasSuffix(host, "."+h)) {
+			return true
+		}
+	}
+	return false
+}
+
+// Name returns the file name of the thumbnail of a photo URL
+func Name(url string) string {
+	sum := sha256.Sum256([]byte(url))
+	return hex.EncodeToString(sum[:12]) + ".jpg"
+}
+
+// Path returns the URL path where the server shows the thumbnail of a photo URL
+func Path(url string) string {
+	return "/" + Dir + "/" + Name(url)
+}
+
+// Get returns the path of the thumbnail of the photo URL, downloading and resizing the photo the first time
+func (c *Cache) Get(ctx context.Context, photo string) (string, error) {
+	name := Name(photo)
+	path := filepath.Join(c.store.Dir(), Dir, name)
+	if _, err := os.Stat(path); err == nil {
+		// The modification time tells prune which thumbnails were used last
+		now := time.Now()
+		os.Chtimes(path, now, now)
+		return Path(photo), nil
+	}
+
+	u, err := url.Parse(photo)
+	if err != nil {
+		return "", err
+	}
+	if !c.allowed(u) {
+		return "", ErrHostNotAllowed
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, Timeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, "GET", photo, nil)
+	if err != nil {
+		return "", err
+	}
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("photo answered %s", resp.Status)
+	}
+	if resp.ContentLength > MaxBytes {
+		return "", ErrTooBig
+	}
+	if ct := resp.Header.Get("Content-Type"); ct != "" && !strings.HasPrefix(ct, "image/") {
+		return "", ErrNotImage
+	}
+
+	b, err := io.ReadAll(io.LimitReader(resp.Body, MaxBytes+1))
+	if err != nil {
+		return "", err
+	}
+	if len(b) > MaxBytes {
+		return "", ErrTooBig
+	}
+
+	thumb, err := Thumbnail(b, Width)
+	if err != nil {
+		return "", err
+	}
+	if err := c.store.WriteFile(Dir+"/"+name, thumb); err != nil {
+		return "", err
+	}
+	return Path(photo), nil
+}
+
+// GetAll returns the thumbnail path of every photo URL that could be downloaded
+// the failures are logged, a listing without thumbnail still shows its text
+func (c *Cache) GetAll(ctx context.Context, logger *slog.Logger, urls []string) map[string]string {
+	var (
+		mu     sync.Mutex
+		wg     sync.WaitGroup
+		thumbs = map[string]string{}
+		slots  = make(chan struct{}, Parallel)
+	)
+	for _, url := range urls {
+		if url == "" {
+			continue
+		}
+		wg.Add(1)
+		slots <- struct{}{}
+		go func(url string) {
+			defer wg.Done()
+			defer func() { <-slots }()
+			path, err := c.Get(ctx, url)
+			if err != nil {
+				logger.Warn("Error while getting the photo", "url", url, "err", err)
+				return
+			}
+			mu.Lock()
+			thumbs[url] = path
+			mu.Unlock()
+		}(url)
+	}
+	wg.Wait()
+
+	if err := c.prune(); err != nil {
+		logger.Warn("Error while removing old thumbnails", "err", err)
+	}
+	return thumbs
+}
+
+// Function that removes the least recently used thumbnails until they fit in the limit of the cache
+func (c *Cache) prune() error {
+	dir := filepath.Join(c.store.Dir(), Dir)
+	entries, err := os.ReadDir(dir)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	type file struct {
+		name string
+		size int64
+		used time.Time
+	}
+	var (
+		files []file
+		total int64
+	)
+	for _, e := range entries {
+		info, err := e.Info()
+		if err != nil || !info.Mode().IsRegular() {
+			continue
+		}
+		files = append(files, file{e.Name(), info.Size(), info.ModTime()})
+		total += info.Size()
+	}
+	if total <= c.limit {
+		return nil
+	}
+
+	sort.Slice(files, func(i, j int) bool { return files[i].used.Before(files[j].used) })
+	for _, f := range files {
+		if total <= c.limit {
+			break
+		}
+		if err := os.Remove(filepath.Join(dir, f.name)); err != nil {
+			return err
+		}
+		total -= f.size
+	}
+	return nil
+}
+
+// Thumbnail decodes a jpeg, png or gif photo and returns it as a jpeg at most width pixels wide
+func Thumbnail(photo []byte, width int) ([]byte, error) {
+	cfg, _, err := image.DecodeConfig(bytes.NewReader(photo))
+	if err != nil {
+		return nil, ErrNotImage
+	}
+	if cfg.Width*cfg.Height > MaxPixels {
+		return nil, ErrTooBig
+	}
+	img, _, err := image.Decode(bytes.NewReader(photo))
+	if err != nil {
+		return nil, err
+	}
+
+	var out bytes.Buffer
+	if err := jpeg.Encode(&out, resize(img, width), &jpeg.Options{Quality: 80}); err != nil {
+		return nil, err
+	}
+	return out.Bytes(), nil
+}
+
+// Function that scales the image down to width, every pixel is the average of the pixels it covers
+// images that are already smaller are kept as they are
+func resize(src image.Image, width int) image.Image {
+	b := src.Bounds()
+	if b.Dx() <= width {
+		return src
+	}
+	height := b.Dy() * width / b.Dx()
+	if height < 1 {
+		height = 1
+	}
+
+	dst := image.NewRGBA(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		y0 := b.Min.Y + y*b.Dy()/height
+		y1 := b.Min.Y + (y+1)*b.Dy()/height
+		for x := 0; x < width; x++ {
+			x0 := b.Min.X + x*b.Dx()/width
+			x1 := b.Min.X + (x+1)*b.Dx()/width
+
+			var r, g, bl, a, n uint64
+			for sy := y0; sy < y1; sy++ {
+				for sx := x0; sx < x1; sx++ {
+					cr, cg, cb, ca := src.At(sx, sy).RGBA()
+					r, g, bl, a = r+uint64(cr), g+uint64(cg), bl+uint64(cb), a+uint64(ca)
+					n++
+				}
+			}
+			if n == 0 {
+				continue
+			}
+			dst.SetRGBA(x, y, color.RGBA{uint8(r / n >> 8), uint8(g / n >> 8), uint8(bl / n >> 8), uint8(a / n >> 8)})
+		}
+	}
+	return dst
+}
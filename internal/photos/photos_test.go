@@ -0,0 +1,218 @@
+package photos
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/BrunoTeixeira1996/gmah/internal/store"
+)
+
+// Test the Get function against a local server
+func TestGet(t *testing.T) {
+	img := image.NewRGBA(image.Rect(0, 0, 1000, 500))
+	for y := 0; y < 500; y++ {
+		for x := 0; x < 1000; x++ {
+			img.Set(x, y, color.RGBA{uint8(x), uint8(y), 100, 255})
+		}
+	}
+	var photo bytes.Buffer
+	if err := png.Encode(&photo, img); err != nil {
+		t.Fatal(err)
+	}
+
+	var hits atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits.Add(1)
+		switch r.URL.Path {
+		case "/photo.png":
+			w.Header().Set("Content-Type", "image/png")
+			w.Write(photo.Bytes())
+		case "/page.html":
+			w.Header().Set("Content-Type", "text/html")
+			w.Write([]byte("<html></html>"))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer srv.Close()
+
+	st, err := store.Open(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := NewCache(st, srv.Client(), []string{"127.0.0.1"})
+
+	path, err := c.Get(context.Background(), srv.URL+"/photo.png")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if path != Path(srv.URL+"/photo.png") {
+		t.Errorf("unexpected path %s", path)
+	}
+
+	f, err := os.Open(filepath.Join(st.Dir(), Dir, Name(srv.URL+"/photo.png")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	thumb, err := jpeg.DecodeConfig(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if thumb.Width != Width || thumb.Height != Width/2 {
+		t.Errorf("expected a %dx%d thumbnail, got %dx%d", Width, Width/2, thumb.Width, thumb.Height)
+	}
+
+	// The second time comes from the cache
+	if _, err := c.Get(context.Background(), srv.URL+"/photo.png"); err != nil || hits.Load() != 1 {
+		t.Errorf("expected the cached thumbnail, got %d requests (%v)", hits.Load(), err)
+	}
+
+	if _, err := c.Get(context.Background(), srv.URL+"/page.html"); err != ErrNotImage {
+		t.Errorf("expected ErrNotImage, got %v", err)
+	}
+	if _, err := c.Get(context.Background(), srv.URL+"/missing.jpg"); err == nil {
+		t.Error("expected an error for a missing photo")
+	}
+
+	thumbs := c.GetAll(context.Background(), slog.Default(), []string{srv.URL + "/photo.png", srv.URL + "/missing.jpg", ""})
+	if len(thumbs) != 1 {
+		t.Errorf("expected 1 thumbnail, got %v", thumbs)
+	}
+}
+
+// Test that the cache only downloads from its hosts, redirects included
+func TestGetHosts(t *testing.T) {
+	var hits atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits.Add(1)
+		// localhost is another host than 127.0.0.1 for the cache
+		http.Redirect(w, r, "http://localhost/photo.png", http.StatusFound)
+	}))
+	defer srv.Close()
+
+	st, err := store.Open(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		hosts    []string
+		url      string
+		wantHits int32
+	}{
+		{"LAN address", []string{"i.casayes.pt"}, srv.URL + "/photo.png", 0},
+		{"address that only ends the same", []string{"0.0.1"}, srv.URL + "/photo.png", 0},
+		{"host that only ends the same", []string{"casayes.pt"}, "https://notcasayes.pt/photo.png", 0},
+		{"not http", []string{"127.0.0.1"}, "file:///etc/passwd", 0},
+		{"redirect to another host", []string{"127.0.0.1"}, srv.URL + "/photo.png", 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			hits.Store(0)
+			c := NewCache(st, srv.Client(), tt.hosts)
+			if _, err := c.Get(context.Background(), tt.url); !errors.Is(err, ErrHostNotAllowed) {
+				t.Errorf("expected ErrHostNotAllowed, got %v", err)
+			}
+			if hits.Load() != tt.wantHits {
+				t.Errorf("expected %d requests, got %d", tt.wantHits, hits.Load())
+			}
+		})
+	}
+}
+
+// Test the prune function
+func TestPrune(t *testing.T) {
+	st, err := store.Open(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := NewCache(st, nil, nil)
+	c.limit = 250
+
+	// Three thumbnails of 100 bytes, b was used last
+	old := time.Now().Add(-time.Hour)
+	for i, name := range []string{"a.jpg", "b.jpg", "c.jpg"} {
+		if err := st.WriteFile(Dir+"/"+name, bytes.Repeat([]byte{1}, 100)); err != nil {
+			t.Fatal(err)
+		}
+		used := old.Add(time.Duration(i) * time.Minute)
+		if name == "b.jpg" {
+			used = time.Now()
+		}
+		if err := os.Chtimes(filepath.Join(st.Dir(), Dir, name), used, used); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := c.prune(); err != nil {
+		t.Fatal(err)
+	}
+	for name, want := range map[string]bool{"a.jpg": false, "b.jpg": true, "c.jpg": true} {
+		_, err := os.Stat(filepath.Join(st.Dir(), Dir, name))
+		if (err == nil) != want {
+			t.Errorf("expected %s kept %v, got %v", name, want, err)
+		}
+	}
+}
+
+// Test the Compute function with copies and other photos
+func TestCompute(t *testing.T) {
+	house := image.NewRGBA(image.Rect(0, 0, 800, 600))
+	for y := 0; y < 600; y++ {
+		for x := 0; x < 800; x++ {
+			c := color.RGBA{uint8(x / 4), uint8(y / 3), 80, 255}
+			// A dark door in the middle of the wall
+			if x > 350 && x < 450 && y > 300 {
+				c = color.RGBA{40, 20, 10, 255}
+			}
+			house.Set(x, y, c)
+		}
+	}
+	other := image.NewRGBA(image.Rect(0, 0, 800, 600))
+	for y := 0; y < 600; y++ {
+		for x := 0; x < 800; x++ {
+			other.Set(x, y, color.RGBA{uint8(255 - y/3), uint8((x * y) % 256), uint8(x / 4), 255})
+		}
+	}
+	// The same photo as a smaller jpeg, like another portal serves it
+	var small bytes.Buffer
+	if err := jpeg.Encode(&small, resize(house, 200), &jpeg.Options{Quality: 50}); err != nil {
+		t.Fatal(err)
+	}
+	recompressed, err := HashOf(small.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	h := Compute(house)
+	if d := h.Distance(recompressed); d > SameDistance {
+		t.Errorf("expected the copy to be at most %d bits away, got %d", SameDistance, d)
+	}
+	if d := h.Distance(Compute(other)); d <= NearDistance {
+		t.Errorf("expected the other photo to be more than %d bits away, got %d", NearDistance, d)
+	}
+
+	parsed, err := ParseHash(h.String())
+	if err != nil || parsed != h {
+		t.Errorf("expected %v back, got %v (%v)", h, parsed, err)
+	}
+	if _, err := ParseHash("abc"); err == nil {
+		t.Error("expected an error for a short hash")
+	}
+}
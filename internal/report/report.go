@@ -0,0 +1,253 @@
+package report
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/BrunoTeixeira1996/gmah/internal/listing"
+)
+
+// Kind of report
+type Kind string
+
+const (
+	Weekly  Kind = "weekly"
+	Monthly Kind = "monthly"
+)
+
+// GoneAfter is how long a listing that used to be re-announced can stay quiet before it counts as disappeared
+const GoneAfter = 14 * 24 * time.Hour
+
+// MaxPriceDrops is how many price drops a report shows
+const MaxPriceDrops = 10
+
+// Period is the span of time covered by a report, End is excluded
+type Period struct {
+	Kind  Kind      `json:"kind"`
+	ID    string    `json:"id"`
+	Start time.Time `json:"start"`
+	End   time.Time `json:"end"`
+}
+
+// Week returns the week, from Monday to Sunday, that has t
+func Week(t time.Time) Period {
+	day := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, t.Location())
+	// Monday is the first day of the week
+	start := day.AddDate(0, 0, -((int(day.Weekday()) + 6) % 7))
+	year, week := start.ISOWeek()
+	return Period{Kind: Weekly, ID: fmt.Sprintf("%d-W%02d", year, week), Start: start, End: start.AddDate(0, 0, 7)}
+}
+
+// Month returns the month that has t
+func Month(t time.Time) Period {
+	start := time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, t.Location())
+	return Period{Kind: Monthly, ID: start.Format("2006-01"), Start: start, End: start.AddDate(0, 1, 0)}
+}
+
+// Previous returns the period right before p
+func (p Period) Previous() Period {
+	if p.Kind == Monthly {
+		return Month(p.Start.AddDate(0, -1, 0))
+	}
+	return Week(p.Start.AddDate(0, 0, -7))
+}
+
+// LastDay returns the last day of the period, for display
+func (p Period) LastDay() time.Time {
+	return p.End.AddDate(0, 0, -1)
+}
+
+// Parse returns the period of an ID like 2024-W39 or 2024-09
+func Parse(kind Kind, id string, loc *time.Location) (Period, error) {
+	switch kind {
+	case Weekly:
+		var year, week int
+		if _, err := fmt.Sscanf(id, "%d-W%d", &year, &week); err != nil || week < 1 || week > 53 {
+			return Period{}, fmt.Errorf("week %q is not like 2024-W39", id)
+		}
+		// The 4th of January is always in the first ISO week
+		p := Week(time.Date(year, 1, 4, 0, 0, 0, 0, loc).AddDate(0, 0, 7*(week-1)))
+		if p.ID != id && p.ID != fmt.Sprintf("%d-W%02d", year, week) {
+			return Period{}, fmt.Errorf("%d has no week %d", year, week)
+		}
+		return p, nil
+	case Monthly:
+		t, err := time.ParseInLocation("2006-01", id, loc)
+		if err != nil {
+			return Period{}, fmt.Errorf("month %q is not like 2024-09", id)
+		}
+		return Month(t), nil
+	}
+	return Period{}, fmt.Errorf("unknown report kind %q", kind)
+}
+
+// Count is how many listings have a name
+type Count struct {
+	Name  string `json:"name"`
+	Count int    `json:"count"`
+}
+
+// Typology has the medians of the new listings of a typology
+type Typology struct {
+	Typology string `json:"typology"`
+	Count    int    `json:"count"`
+	// MedianPrice and MedianPricePerM2 are 0 when no listing had them
+	MedianPrice      int `json:"median_price"`
+	MedianPricePerM2 int `json:"median_price_per_m2"`
+}
+
+// PriceDrop is a listing whose asking price went down during the period
+type PriceDrop struct {
+	listing.Record
+	From    int     `json:"from"`
+	To      int     `json:"to"`
+	Percent float64 `json:"percent"`
+}
+
+// Report summarizes the listings of a period
+type Report struct {
+	Period         Period           `json:"period"`
+	New            int              `json:"new"`
+	ByPortal       []Count          `json:"by_portal"`
+	ByMunicipality []Count          `json:"by_municipality"`
+	Typologies     []Typology       `json:"typologies"`
+	PriceDrops     []PriceDrop      `json:"price_drops"`
+	Disappeared    []listing.Record `json:"disappeared"`
+	Shortlist      []listing.Record `json:"shortlist"`
+	GeneratedAt    time.Time        `json:"generated_at"`
+}
+
+// Function that sorts the counts, biggest first
+func sortedCounts(m map[string]int) []Count {
+	counts := []Count{}
+	for name, n := range m {
+		counts = append(counts, Count{name, n})
+	}
+	sort.Slice(counts, func(i, j int) bool {
+		if counts[i].Count != counts[j].Count {
+			return counts[i].Count > counts[j].Count
+		}
+		return counts[i].Name < counts[j].Name
+	})
+	return counts
+}
+
+// Median returns the median of the values, 0 when there are none
+func Median(values []int) int {
+	if len(values) == 0 {
+		return 0
+	}
+	sorted := append([]int(nil), values...)
+	sort.Ints(sorted)
+	mid := len(sorted) / 2
+	if len(sorted)%2 == 1 {
+		return sorted[mid]
+	}
+	return (sorted[mid-1] + sorted[mid]) / 2
+}
+
+// Function that returns the typology number for sorting, listings without one go last
+func typologyOrder(t string) int {
+	n, err := strconv.Atoi(strings.TrimPrefix(t, "T"))
+	if err != nil {
+		return 1 << 30
+	}
+	return n
+}
+
+// Build computes the report of the period from the catalog records
+func Build(records []listing.Record, p Period, now time.Time) Report {
+	r := Report{Period: p, GeneratedAt: now, Disappeared: []listing.Record{}, Shortlist: []listing.Record{}, PriceDrops: []PriceDrop{}}
+
+	portals := map[string]int{}
+	municipalities := map[string]int{}
+	prices := map[string][]int{}
+	perM2 := map[string][]int{}
+	counts := map[string]int{}
+
+	in := func(t time.Time) bool { return !t.Before(p.Start) && t.Before(p.End) }
+	for _, rec := range records {
+		if in(rec.FirstSeen) {
+			r.New++
+			portals[rec.Portal]++
+			municipalities[rec.Municipality()]++
+
+			typology := rec.Typology
+			if typology == "" {
+				typology = "other"
+			}
+			counts[typology]++
+			if rec.Price > 0 {
+				prices[typology] = append(prices[typology], rec.Price)
+				if rec.Area > 0 {
+					perM2[typology] = append(perM2[typology], rec.Price/rec.Area)
+				}
+			}
+		}
+
+		// The price when the period started, or the first one seen during it
+		before := rec.PriceAt(p.Start.Add(-time.Nanosecond))
+		if before == 0 {
+			for _, pp := range rec.Prices {
+				if in(pp.At) {
+					before = pp.Price
+					break
+				}
+			}
+		}
+		after := rec.PriceAt(p.End.Add(-time.Nanosecond))
+		if before > 0 && after > 0 && after < before {
+			r.PriceDrops = append(r.PriceDrops, PriceDrop{Record: rec, From: before, To: after, Percent: float64(before-after) / float64(before) * 100})
+		}
+
+		// Listings that kept being announced and then went quiet
+		if rec.TimesSeen > 1 && in(rec.LastSeen.Add(GoneAfter)) && !now.Before(rec.LastSeen.Add(GoneAfter)) {
+			r.Disappeared = append(r.Disappeared, rec)
+		}
+
+		if rec.Status != "" {
+			r.Shortlist = append(r.Shortlist, rec)
+		}
+	}
+
+	r.ByPortal = sortedCounts(portals)
+	r.ByMunicipality = sortedCounts(municipalities)
+
+	r.Typologies = []Typology{}
+	for typology, n := range counts {
+		r.Typologies = append(r.Typologies, Typology{
+			Typology:         typology,
+			Count:            n,
+			MedianPrice:      Median(prices[typology]),
+			MedianPricePerM2: Median(perM2[typology]),
+		})
+	}
+	sort.Slice(r.Typologies, func(i, j int) bool {
+		return typologyOrder(r.Typologies[i].Typology) < typologyOrder(r.Typologies[j].Typology)
+	})
+
+	sort.Slice(r.PriceDrops, func(i, j int) bool {
+		return r.PriceDrops[i].From-r.PriceDrops[i].To > r.PriceDrops[j].From-r.PriceDrops[j].To
+	})
+	if len(r.PriceDrops) > MaxPriceDrops {
+		r.PriceDrops = r.PriceDrops[:MaxPriceDrops]
+	}
+
+	sort.Slice(r.Disappeared, func(i, j int) bool { return r.Disappeared[i].LastSeen.After(r.Disappeared[j].LastSeen) })
+	sort.Slice(r.Shortlist, func(i, j int) bool {
+		if r.Shortlist[i].Status != r.Shortlist[j].Status {
+			return r.Shortlist[i].Status < r.Shortlist[j].Status
+		}
+		return r.Shortlist[i].Key < r.Shortlist[j].Key
+	})
+
+	return r
+}
+
+// Summary is the one line text used in the notification
+func (r Report) Summary() string {
+	return fmt.Sprintf("%d new listings, %d price drops, %d disappeared, %d shortlisted", r.New, len(r.PriceDrops), len(r.Disappeared), len(r.Shortlist))
+}
@@ -0,0 +1,104 @@
+package report
+
+import (
+	"testing"
+	"time"
+
+	"github.com/BrunoTeixeira1996/gmah/internal/listing"
+)
+
+// Test the Parse function
+func TestParse(t *testing.T) {
+	tests := []struct {
+		name      string
+		kind      Kind
+		id        string
+		wantStart string
+		wantEnd   string
+		wantErr   bool
+	}{
+		{"week", Weekly, "2024-W39", "2024-09-23", "2024-09-30", false},
+		{"first week", Weekly, "2025-W01", "2024-12-30", "2025-01-06", false},
+		{"month", Monthly, "2024-09", "2024-09-01", "2024-10-01", false},
+		{"december", Monthly, "2024-12", "2024-12-01", "2025-01-01", false},
+		{"week 53 of a short year", Weekly, "2023-W53", "", "", true},
+		{"malformed week", Weekly, "2024-39", "", "", true},
+		{"malformed month", Monthly, "09-2024", "", "", true},
+		{"unknown kind", Kind("daily"), "2024-09-24", "", "", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p, err := Parse(tt.kind, tt.id, time.UTC)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("expected error %v, got %v", tt.wantErr, err)
+			}
+			if err != nil {
+				return
+			}
+			if got := p.Start.Format("2006-01-02"); got != tt.wantStart {
+				t.Errorf("expected start %s, got %s", tt.wantStart, got)
+			}
+			if got := p.End.Format("2006-01-02"); got != tt.wantEnd {
+				t.Errorf("expected end %s, got %s", tt.wantEnd, got)
+			}
+			if p.ID != tt.id {
+				t.Errorf("expected id %s, got %s", tt.id, p.ID)
+			}
+		})
+	}
+}
+
+// Test the Build function
+func TestBuild(t *testing.T) {
+	day := func(d int) time.Time { return time.Date(2024, 9, d, 23, 59, 0, 0, time.UTC) }
+	p := Week(day(24))
+
+	records := []listing.Record{
+		// New this week and its price went down
+		{
+			Listing:   listing.Listing{Portal: "idealista", Typology: "T3", Price: 190000, Area: 100, Location: "Esgueira, Aveiro"},
+			Key:       "a",
+			FirstSeen: day(23), LastSeen: day(25), TimesSeen: 2,
+			Prices: []listing.PricePoint{{At: day(23), Price: 200000}, {At: day(25), Price: 190000}},
+		},
+		// New this week, shortlisted
+		{
+			Listing:   listing.Listing{Portal: "idealista", Typology: "T3", Price: 250000, Area: 125, Location: "Aveiro"},
+			Key:       "b",
+			FirstSeen: day(24), LastSeen: day(24), TimesSeen: 1,
+			Status: "visit",
+		},
+		// Old listing that stopped being announced two weeks ago
+		{
+			Listing:   listing.Listing{Portal: "CasaYes", Typology: "T2", Price: 150000, Location: "Ílhavo"},
+			Key:       "c",
+			FirstSeen: day(1), LastSeen: day(10), TimesSeen: 3,
+		},
+	}
+
+	r := Build(records, p, day(29))
+
+	if r.New != 2 {
+		t.Errorf("expected 2 new listings, got %d", r.New)
+	}
+	if len(r.ByPortal) != 1 || r.ByPortal[0] != (Count{"idealista", 2}) {
+		t.Errorf("expected 2 idealista listings, got %v", r.ByPortal)
+	}
+	if len(r.ByMunicipality) != 1 || r.ByMunicipality[0] != (Count{"Aveiro", 2}) {
+		t.Errorf("expected 2 listings in Aveiro, got %v", r.ByMunicipality)
+	}
+	want := Typology{Typology: "T3", Count: 2, MedianPrice: 220000, MedianPricePerM2: 1950}
+	if len(r.Typologies) != 1 || r.Typologies[0] != want {
+		t.Errorf("expected %v, got %v", want, r.Typologies)
+	}
+	if len(r.PriceDrops) != 1 || r.PriceDrops[0].From != 200000 || r.PriceDrops[0].To != 190000 {
+		t.Errorf("expected a drop from 200000 to 190000, got %v", r.PriceDrops)
+	}
+	if len(r.Disappeared) != 1 || r.Disappeared[0].Key != "c" {
+		t.Errorf("expected c to have disappeared, got %v", r.Disappeared)
+	}
+	if len(r.Shortlist) != 1 || r.Shortlist[0].Key != "b" {
+		t.Errorf("expected b in the shortlist, got %v", r.Shortlist)
+	}
+}
@@ -0,0 +1,245 @@
+package requests
+
+import (
+	"fmt"
+	"html"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/BrunoTeixeira1996/gmah/internal/listing"
+)
+
+// Message size limits of the backends, 0 means no limit
+const (
+	telegramLimit = 4096
+	ntfyLimit     = 4096
+	gotifyLimit   = 0
+	relayLimit    = telegramLimit
+	smtpLimit     = 0
+	webhookLimit  = 0
+)
+
+// FormatPrice writes a price the way the portals do (205 000 €)
+func FormatPrice(price int) string {
+	s := strconv.Itoa(price)
+	var b strings.Builder
+	for i, c := range s {
+		if i > 0 && (len(s)-i)%3 == 0 {
+			b.WriteByte(' ')
+		}
+		b.WriteRune(c)
+	}
+	return b.String() + " €"
+}
+
+// Function that returns the known details of a listing in a single line
+func listingDetails(l listing.Listing) string {
+	var details []string
+	if l.Typology != "" {
+		details = append(details, l.Typology)
+	}
+	if l.Area != 0 {
+		details = append(details, fmt.Sprintf("%d m²", l.Area))
+	}
+	if l.Price != 0 {
+		details = append(details, FormatPrice(l.Price))
+	}
+	if l.Location != "" {
+		details = append(details, l.Location)
+	}
+	if l.Market != nil {
+		details = append(details, l.Market.String())
+	}
+	if len(l.ScoreParts) > 0 {
+		details = append(details, fmt.Sprintf("score %d", l.Score))
+	}
+	return strings.Join(details, " · ")
+}
+
+// Function that renders one listing, as HTML when asHTML is set
+// baseURL is the address of the gmah server that has the thumbnails
+func renderListing(l listing.Listing, asHTML bool, baseURL string) string {
+	title := l.Title
+	if title == "" {
+		title = "(no title)"
+	}
+	details := listingDetails(l)
+
+	if asHTML {
+		s := "• " + html.EscapeString(title)
+		if l.Link != "" {
+			s = fmt.Sprintf("• <a href=\"%s\">%s</a>", html.EscapeString(l.Link), html.EscapeString(title))
+		}
+		if details != "" {
+			s += "\n  " + html.EscapeString(details)
+		}
+		// The thumbnail is on the gmah server, it can only be linked when its address is known
+		if l.Thumbnail != "" && baseURL != "" {
+			s += fmt.Sprintf(" <a href=\"%s\">📷</a>", html.EscapeString(baseURL+l.Thumbnail))
+		}
+		return s + "\n"
+	}
+
+	s := "• " + title
+	if details != "" {
+		s += "\n  " + details
+	}
+	if l.Link != "" {
+		s += "\n  " + l.Link
+	}
+	return s + "\n"
+}
+
+// Function that groups the listings per portal keeping the order inside each portal
+// the portal with the best score comes first, then the portals by name
+func groupByPortal(listings []listing.Listing) ([]string, map[string][]listing.Listing) {
+	groups := map[string][]listing.Listing{}
+	best := map[string]int{}
+	var portals []string
+	for _, l := range listings {
+		if _, ok := groups[l.Portal]; !ok {
+			portals = append(portals, l.Portal)
+			best[l.Portal] = l.Score
+		}
+		if l.Score > best[l.Portal] {
+			best[l.Portal] = l.Score
+		}
+		groups[l.Portal] = append(groups[l.Portal], l)
+	}
+	sort.Slice(portals, func(i, j int) bool {
+		if best[portals[i]] != best[portals[j]] {
+			return best[portals[i]] > best[portals[j]]
+		}
+		return portals[i] < portals[j]
+	})
+	return portals, groups
+}
+
+// render builds the text of a message with the listings grouped per portal
+// when limit is set the listings that don't fit are replaced by a "and N more" line
+// but the page link is always kept at the end
+func render(m Message, limit int, asHTML bool) string {
+	esc := func(s string) string { return s }
+	bold := func(s string) string { return s }
+	if asHTML {
+		esc = html.EscapeString
+		bold = func(s string) string { return "<b>" + html.EscapeString(s) + "</b>" }
+	}
+
+	var lines []headerLine
+	if m.Title != "" {
+		lines = append(lines, headerLine{m.Title, bold})
+	}
+	if m.Text != "" {
+		lines = append(lines, headerLine{m.Text, esc})
+	}
+	for _, e := range m.Errors {
+		where := e.Stage
+		if e.Portal != "" {
+			where += " " + e.Portal
+		}
+		lines = append(lines, headerLine{fmt.Sprintf("⚠ %s: %s", where, e.Message), esc})
+	}
+
+	footer := ""
+	if m.Link != "" {
+		footer = "\n" + esc(m.Link) + "\n"
+		if asHTML {
+			label := "Daily page"
+			switch m.Kind {
+			case KindParser:
+				label = "Run report"
+			case KindReport:
+				label = "Report"
+			}
+			footer = fmt.Sprintf("\n<a href=\"%s\">%s</a>\n", html.EscapeString(m.Link), label)
+		}
+	}
+
+	more := func(n int) string { return fmt.Sprintf("\n… and %d more\n", n) }
+
+	// The header is cut line by line, and inside a line only its text is cut, so the markup stays valid
+	// and there is always room for the page link and for the count of the listings left out
+	var header strings.Builder
+	room := limit - len(footer)
+	if len(m.Listings) > 0 {
+		room -= len(more(len(m.Listings)))
+	}
+	for i, l := range lines {
+		line := l.format(l.text) + "\n"
+		if limit <= 0 || header.Len()+len(line) <= room {
+			header.WriteString(line)
+			continue
+		}
+		rest := ""
+		if left := len(lines) - i - 1; left > 0 {
+			rest = esc(fmt.Sprintf("… and %d more lines", left)) + "\n"
+		}
+		header.WriteString(l.shorten(room - header.Len() - len(rest)))
+		if header.Len()+len(rest) <= room {
+			header.WriteString(rest)
+		}
+		break
+	}
+
+	var body strings.Builder
+	portals, groups := groupByPortal(m.Listings)
+	left := len(m.Listings)
+
+out:
+	for _, p := range portals {
+		section := "\n" + bold(p) + "\n"
+		for i, l := range groups[p] {
+			entry := renderListing(l, asHTML, m.BaseURL)
+			if i == 0 {
+				entry = section + entry
+			}
+
+			if limit > 0 {
+				reserve := 0
+				if left > 1 {
+					reserve = len(more(left - 1))
+				}
+				if header.Len()+body.Len()+len(entry)+reserve+len(footer) > limit {
+					break out
+				}
+			}
+			body.WriteString(entry)
+			left--
+		}
+	}
+
+	if left > 0 {
+		body.WriteString(more(left))
+	}
+
+	return strings.TrimSpace(header.String() + body.String() + footer)
+}
+
+// headerLine is a line above the listings, format escapes its text and adds the markup
+type headerLine struct {
+	text   string
+	format func(string) string
+}
+
+// Function that returns the line with as much of its text as fits in size bytes, ending with "…",
+// or nothing when not even that fits
+func (l headerLine) shorten(size int) string {
+	runes := []rune(l.text)
+	line := func(n int) string { return l.format(string(runes[:n])+"…") + "\n" }
+	if len(line(0)) > size {
+		return ""
+	}
+	// The longest text that fits, escaping makes the length of a line grow with its text
+	lo, hi := 0, len(runes)
+	for lo < hi {
+		mid := (lo + hi + 1) / 2
+		if len(line(mid)) <= size {
+			lo = mid
+		} else {
+			hi = mid - 1
+		}
+	}
+	return line(lo)
+}
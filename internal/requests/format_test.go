@@ -0,0 +1,163 @@
+package requests
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/BrunoTeixeira1996/gmah/internal/listing"
+)
+
+// Test the render function
+func TestRender(t *testing.T) {
+	var many []listing.Listing
+	for i := 0; i < 200; i++ {
+		many = append(many, listing.Listing{
+			Portal:   []string{"idealista", "CasaYes"}[i%2],
+			Title:    fmt.Sprintf("Moradia T3 numero %d", i),
+			Typology: "T3",
+			Price:    200000 + i,
+			Area:     120,
+			Location: "Esgueira Aveiro",
+			Link:     fmt.Sprintf("https://example.com/imovel/%d", i),
+		})
+	}
+
+	tests := []struct {
+		name     string
+		listings []listing.Listing
+		limit    int
+		asHTML   bool
+		want     []string
+		wantMore bool
+	}{
+		{
+			name:     "details and portals",
+			listings: many[:2],
+			want:     []string{"\nCasaYes\n• Moradia T3 numero 1\n  T3 · 120 m² · 200 001 € · Esgueira Aveiro\n  https://example.com/imovel/1", "\nidealista\n"},
+		},
+		{
+			name:     "truncated plain text",
+			listings: many,
+			limit:    ntfyLimit,
+			wantMore: true,
+		},
+		{
+			name:     "truncated html",
+			listings: many,
+			limit:    telegramLimit,
+			asHTML:   true,
+			want:     []string{`<b>CasaYes</b>`, `<a href="https://example.com/imovel/1">Moradia T3 numero 1</a>`},
+			wantMore: true,
+		},
+		{
+			name: "best score first",
+			listings: []listing.Listing{
+				{Portal: "CasaYes", Title: "Moradia T3", Score: 40, ScoreParts: []listing.ScorePart{{Rule: "typology", Points: 40, Detail: "T3"}}},
+				{Portal: "idealista", Title: "Moradia T4", Score: 75, ScoreParts: []listing.ScorePart{{Rule: "typology", Points: 75, Detail: "T4"}}},
+			},
+			want: []string{"\nidealista\n• Moradia T4\n  score 75\n\nCasaYes\n"},
+		},
+		{
+			name: "market comparison",
+			listings: []listing.Listing{
+				{Portal: "idealista", Title: "Moradia T3", Typology: "T3", Market: &listing.Comparison{Area: "Aveiro", Typology: "T3", Median: 2000, Sample: 12, Deviation: -0.123}},
+			},
+			want: []string{"\n  T3 · 12% below Aveiro T3 median\n"},
+		},
+		{
+			name:     "thumbnail",
+			listings: []listing.Listing{{Portal: "idealista", Title: "Moradia T3", Thumbnail: "/thumbnails/abc.jpg"}},
+			asHTML:   true,
+			want:     []string{`<a href="http://localhost:9090/thumbnails/abc.jpg">📷</a>`},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := Message{Title: "gmah 2024-09-24", Text: "Got 200 new messages", Link: "http://localhost:9090/dump/2024-09-24_serve.html", Listings: tt.listings, BaseURL: "http://localhost:9090"}
+			got := render(m, tt.limit, tt.asHTML)
+
+			if tt.limit > 0 && len(got) > tt.limit {
+				t.Errorf("expected at most %d bytes, got %d", tt.limit, len(got))
+			}
+			if !strings.Contains(got[len(got)-100:], "2024-09-24_serve.html") {
+				t.Errorf("expected the page link at the end, got %s", got[len(got)-100:])
+			}
+			if strings.Contains(got, "more\n") != tt.wantMore {
+				t.Errorf("expected truncation to be %v, got %s", tt.wantMore, got)
+			}
+			for _, w := range tt.want {
+				if !strings.Contains(got, w) {
+					t.Errorf("expected %q in %s", w, got)
+				}
+			}
+		})
+	}
+}
+
+// Test the render function with a header longer than the limit
+func TestRenderLongHeader(t *testing.T) {
+	var errs []PayloadError
+	for i := 0; i < 100; i++ {
+		errs = append(errs, PayloadError{Stage: "parse", Portal: "idealista", Message: fmt.Sprintf("no <price> & no area in message %d", i)})
+	}
+	tests := []struct {
+		name   string
+		m      Message
+		asHTML bool
+		want   string
+	}{
+		{
+			name:   "long text in html",
+			m:      Message{Title: "gmah 2024-09-24", Text: strings.Repeat("Moradia <T3> & jardim ", 500)},
+			asHTML: true,
+			want:   "<b>gmah 2024-09-24</b>\nMoradia &lt;T3&gt; &amp; jardim",
+		},
+		{
+			name:   "long title in html",
+			m:      Message{Title: strings.Repeat("T3 & T4 ", 1000), Text: "Got 3 new messages"},
+			asHTML: true,
+			want:   "<b>T3 &amp; T4",
+		},
+		{
+			name: "many errors",
+			m:    Message{Title: "gmah 2024-09-24", Text: "Got 3 new messages", Errors: errs},
+			want: "more lines\n",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.m.Link = "http://localhost:9090/dump/2024-09-24_serve.html"
+			tt.m.Listings = []listing.Listing{{Portal: "idealista", Title: "Moradia T3"}}
+			got := render(tt.m, telegramLimit, tt.asHTML)
+
+			if len(got) > telegramLimit {
+				t.Errorf("expected at most %d bytes, got %d", telegramLimit, len(got))
+			}
+			if !strings.HasSuffix(got, "2024-09-24_serve.html\">Daily page</a>") && !strings.HasSuffix(got, "2024-09-24_serve.html") {
+				t.Errorf("expected the page link at the end, got %s", got[len(got)-100:])
+			}
+			if !strings.Contains(got, "… and 1 more") {
+				t.Errorf("expected the listing to be counted, got %s", got[len(got)-100:])
+			}
+			if !strings.Contains(got, tt.want) {
+				t.Errorf("expected %q in %s", tt.want, got[:100])
+			}
+			// Only whole tags and entities
+			if tt.asHTML && (strings.Count(got, "<b>") != strings.Count(got, "</b>") || strings.Count(got, "&") != strings.Count(got, "&amp;")+strings.Count(got, "&lt;")+strings.Count(got, "&gt;")) {
+				t.Errorf("expected whole tags and entities, got %s", got[len(got)-200:])
+			}
+		})
+	}
+}
+
+// Test the FormatPrice function
+func TestFormatPrice(t *testing.T) {
+	for price, want := range map[int]string{950: "950 €", 205000: "205 000 €", 1250000: "1 250 000 €"} {
+		if got := FormatPrice(price); got != want {
+			t.Errorf("expected %s, got %s", want, got)
+		}
+	}
+}
@@ -0,0 +1,32 @@
+package requests
+
+// Gotify pushes a message to a Gotify application
+type Gotify struct {
+	name   string
+	server string
+	token  string
+}
+
+func (g *Gotify) Name() string { return g.name }
+
+func (g *Gotify) Notify(m Message) error {
+	body := struct {
+		Title    string                 `json:"title"`
+		Message  string                 `json:"message"`
+		Priority int                    `json:"priority"`
+		Extras   map[string]interface{} `json:"extras,omitempty"`
+	}{
+		Title:    m.Title,
+		Message:  render(m, gotifyLimit, false),
+		Priority: 5,
+	}
+	if m.Link != "" {
+		body.Extras = map[string]interface{}{
+			"client::notification": map[string]interface{}{
+				"click": map[string]string{"url": m.Link},
+			},
+		}
+	}
+
+	return postJSON(g.server+"/message", body, m, "", map[string]string{"X-Gotify-Key": g.token})
+}
@@ -0,0 +1,223 @@
+package requests
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/BrunoTeixeira1996/gmah/internal/listing"
+)
+
+// Kinds of messages sent to the notifiers
+const (
+	KindDaily  = "daily"
+	KindLookup = "lookup"
+	KindAlert  = "alert"
+	KindParser = "parser"
+	KindReport = "report"
+)
+
+// Message is what gets sent to every notifier
+// each backend decides how to render it
+type Message struct {
+	// ID is used as idempotency key, it is set by the outbox
+	ID       string            `json:"id,omitempty"`
+	Kind     string            `json:"kind"`
+	Title    string            `json:"title"`
+	Text     string            `json:"text"`
+	Link     string            `json:"link,omitempty"`
+	Date     string            `json:"date"`
+	Count    int               `json:"count"`
+	Search   string            `json:"search,omitempty"`
+	Mode     string            `json:"mode,omitempty"`
+	Listings []listing.Listing `json:"listings,omitempty"`
+	Summary  *RunSummary       `json:"summary,omitempty"`
+	Errors   []PayloadError    `json:"errors,omitempty"`
+	// RunID is the run that sent the message, it is only used in the logs
+	RunID string `json:"run_id,omitempty"`
+	// BaseURL is the address of the gmah server, the thumbnails of the listings are linked from it
+	BaseURL string `json:"base_url,omitempty"`
+}
+
+// Notifier delivers a message to a single backend
+type Notifier interface {
+	Name() string
+	Notify(m Message) error
+}
+
+// NotifierConfig is the config of one notifier, only the fields used by its type are needed
+type NotifierConfig struct {
+	Name     string   `json:"name"`
+	Type     string   `json:"type"`
+	URL      string   `json:"url"`
+	Token    string   `json:"token"`
+	ChatID   string   `json:"chat_id"`
+	Topic    string   `json:"topic"`
+	Host     string   `json:"host"`
+	Username string   `json:"username"`
+	Password string   `json:"password"`
+	From     string   `json:"from"`
+	To       []string `json:"to"`
+	// Secret signs the requests of the relay and webhook notifiers
+	Secret string `json:"secret"`
+}
+
+// New builds the notifier described by the config
+func New(c NotifierConfig) (Notifier, error) {
+	if c.Name == "" {
+		return nil, fmt.Errorf("notifier without name")
+	}
+
+	required := func(fields map[string]string) error {
+		for k, v := range fields {
+			if v == "" {
+				return fmt.Errorf("notifier %q of type %s needs %s", c.Name, c.Type, k)
+			}
+		}
+		return nil
+	}
+
+	switch c.Type {
+	case "relay":
+		if err := required(map[string]string{"url": c.URL}); err != nil {
+			return nil, err
+		}
+		return &Relay{name: c.Name, url: c.URL, secret: c.Secret}, nil
+	case "telegram":
+		if err := required(map[string]string{"token": c.Token, "chat_id": c.ChatID}); err != nil {
+			return nil, err
+		}
+		api := c.URL
+		if api == "" {
+			api = "https://api.telegram.org"
+		}
+		return &Telegram{name: c.Name, api: strings.TrimSuffix(api, "/"), token: c.Token, chatID: c.ChatID}, nil
+	case "ntfy":
+		if err := required(map[string]string{"topic": c.Topic}); err != nil {
+			return nil, err
+		}
+		server := c.URL
+		if server == "" {
+			server = "https://ntfy.sh"
+		}
+		return &Ntfy{name: c.Name, server: strings.TrimSuffix(server, "/"), topic: c.Topic, token: c.Token}, nil
+	case "gotify":
+		if err := required(map[string]string{"url": c.URL, "token": c.Token}); err != nil {
+			return nil, err
+		}
+		return &Gotify{name: c.Name, server: strings.TrimSuffix(c.URL, "/"), token: c.Token}, nil
+	case "webhook":
+		if err := required(map[string]string{"url": c.URL}); err != nil {
+			return nil, err
+		}
+		return &Webhook{name: c.Name, url: c.URL, secret: c.Secret}, nil
+	case "smtp":
+		if err := required(map[string]string{"host": c.Host, "from": c.From}); err != nil {
+			return nil, err
+		}
+		if len(c.To) == 0 {
+			return nil, fmt.Errorf("notifier %q of type smtp needs to", c.Name)
+		}
+		return &SMTP{name: c.Name, host: c.Host, username: c.Username, password: c.Password, from: c.From, to: c.To}, nil
+	}
+
+	return nil, fmt.Errorf("notifier %q has unknown type %q", c.Name, c.Type)
+}
+
+// Multi sends the same message to several notifiers
+type Multi []Notifier
+
+func (m Multi) Name() string {
+	var names []string
+	for _, n := range m {
+		names = append(names, n.Name())
+	}
+	return strings.Join(names, ",")
+}
+
+// Notify tries every notifier even if some of them fail
+func (m Multi) Notify(msg Message) error {
+	var errs []string
+	for _, n := range m {
+		if err := n.Notify(msg); err != nil {
+			errs = append(errs, fmt.Sprintf("%s: %v", n.Name(), err))
+		}
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("%s", strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+var httpClient = &http.Client{Timeout: 30 * time.Second}
+
+// Sign returns the HMAC-SHA256 of the timestamp and body
+// receivers should compute it and compare with the X-Gmah-Signature header
+func Sign(secret string, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// Function that POSTs a JSON body and expects a 2xx answer
+func postJSON(endpoint string, v interface{}, m Message, secret string, headers map[string]string) error {
+	body, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return post(endpoint, "application/json", body, m, secret, headers)
+}
+
+// Function that POSTs a body with the idempotency key of the message
+// and signs it when there is a secret
+func post(endpoint string, contentType string, body []byte, m Message, secret string, headers map[string]string) error {
+	r, err := http.NewRequest("POST", endpoint, bytes.NewReader(body))
+	if err != nil {
+		return withoutURL(err)
+	}
+	r.Header.Add("Content-Type", contentType)
+	for k, v := range headers {
+		r.Header.Set(k, v)
+	}
+	if m.ID != "" {
+		r.Header.Set("Idempotency-Key", m.ID)
+	}
+	if secret != "" {
+		timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+		r.Header.Set("X-Gmah-Timestamp", timestamp)
+		r.Header.Set("X-Gmah-Signature", Sign(secret, timestamp, body))
+	}
+
+	res, err := httpClient.Do(r)
+	if err != nil {
+		return fmt.Errorf("Error while posting to %s: %w", r.URL.Host, withoutURL(err))
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		return fmt.Errorf("Got %d status while posting to %s", res.StatusCode, r.URL.Host)
+	}
+
+	return nil
+}
+
+// Function that returns the cause of a request error without its URL, the URL of Telegram has the
+// bot token and the errors end up in the outbox and in the logs
+func withoutURL(err error) error {
+	var ue *url.Error
+	if errors.As(err, &ue) {
+		return ue.Err
+	}
+	return err
+}
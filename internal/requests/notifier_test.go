@@ -0,0 +1,330 @@
+package requests
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/BrunoTeixeira1996/gmah/internal/listing"
+)
+
+var testMessage = Message{
+	Kind:   KindAlert,
+	Title:  "gmah alert: T3 Aveiro",
+	Text:   "1 new listings matched T3 Aveiro",
+	Link:   "http://localhost:9090/dump/2024-09-24_serve.html",
+	Date:   "2024-09-24",
+	Count:  1,
+	Search: "T3 Aveiro",
+	Mode:   "digest",
+	Listings: []listing.Listing{
+		{Portal: "CasaYes", Title: "Moradia T3 Esgueira Aveiro", Price: 205000, Link: "https://casayes.pt/1"},
+	},
+}
+
+// Captures the last request received by a httptest server
+type captured struct {
+	path   string
+	header http.Header
+	body   string
+}
+
+func newCaptureServer(t *testing.T, status int) (*httptest.Server, *captured) {
+	c := &captured{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := io.ReadAll(r.Body)
+		c.path = r.URL.Path
+		c.header = r.Header
+		c.body = string(b)
+		w.WriteHeader(status)
+	}))
+	t.Cleanup(srv.Close)
+	return srv, c
+}
+
+// Test every HTTP based notifier against a local server
+func TestHTTPNotifiers(t *testing.T) {
+	tests := []struct {
+		name     string
+		config   func(url string) NotifierConfig
+		wantPath string
+		check    func(t *testing.T, c *captured)
+	}{
+		{
+			name: "relay",
+			config: func(url string) NotifierConfig {
+				return NotifierConfig{Name: "relay", Type: "relay", URL: url + "/gmah"}
+			},
+			wantPath: "/gmah",
+			check: func(t *testing.T, c *captured) {
+				var got Payload
+				if err := json.Unmarshal([]byte(c.body), &got); err != nil {
+					t.Fatal(err)
+				}
+				if got.Version != PayloadVersion || got.Search != "T3 Aveiro" || len(got.Listings) != 1 {
+					t.Errorf("unexpected relay body %s", c.body)
+				}
+				// The bot in the LAN still reads the fields from before the payload
+				var legacy map[string]interface{}
+				if err := json.Unmarshal([]byte(c.body), &legacy); err != nil {
+					t.Fatal(err)
+				}
+				if legacy["lookup"] != "false" || legacy["count"] != "1" || legacy["link"] != testMessage.Link || legacy["Error"] != nil {
+					t.Errorf("expected the legacy fields in the relay body %s", c.body)
+				}
+			},
+		},
+		{
+			name: "telegram",
+			config: func(url string) NotifierConfig {
+				return NotifierConfig{Name: "tg", Type: "telegram", URL: url, Token: "123:abc", ChatID: "42"}
+			},
+			wantPath: "/bot123:abc/sendMessage",
+			check: func(t *testing.T, c *captured) {
+				var got struct {
+					ChatID      string `json:"chat_id"`
+					Text        string `json:"text"`
+					ParseMode   string `json:"parse_mode"`
+					ReplyMarkup struct {
+						InlineKeyboard [][]struct {
+							URL string `json:"url"`
+						} `json:"inline_keyboard"`
+					} `json:"reply_markup"`
+				}
+				if err := json.Unmarshal([]byte(c.body), &got); err != nil {
+					t.Fatal(err)
+				}
+				if got.ChatID != "42" || got.ParseMode != "HTML" {
+					t.Errorf("unexpected telegram body %s", c.body)
+				}
+				if !strings.Contains(got.Text, `<a href="https://casayes.pt/1">Moradia T3 Esgueira Aveiro</a>`) {
+					t.Errorf("expected listing link in text, got %s", got.Text)
+				}
+				if len(got.ReplyMarkup.InlineKeyboard) != 1 || got.ReplyMarkup.InlineKeyboard[0][0].URL != testMessage.Link {
+					t.Errorf("expected inline button with page link, got %s", c.body)
+				}
+			},
+		},
+		{
+			name: "ntfy",
+			config: func(url string) NotifierConfig {
+				return NotifierConfig{Name: "ntfy", Type: "ntfy", URL: url, Topic: "casas"}
+			},
+			wantPath: "/casas",
+			check: func(t *testing.T, c *captured) {
+				if c.header.Get("Title") != testMessage.Title || c.header.Get("Click") != testMessage.Link {
+					t.Errorf("unexpected ntfy headers %v", c.header)
+				}
+				if !strings.Contains(c.body, "https://casayes.pt/1") {
+					t.Errorf("expected listing link in body, got %s", c.body)
+				}
+			},
+		},
+		{
+			name: "gotify",
+			config: func(url string) NotifierConfig {
+				return NotifierConfig{Name: "gotify", Type: "gotify", URL: url, Token: "secret"}
+			},
+			wantPath: "/message",
+			check: func(t *testing.T, c *captured) {
+				if c.header.Get("X-Gotify-Key") != "secret" {
+					t.Errorf("expected gotify token header, got %v", c.header)
+				}
+				if !strings.Contains(c.body, testMessage.Title) {
+					t.Errorf("expected title in body, got %s", c.body)
+				}
+			},
+		},
+		{
+			name: "webhook",
+			config: func(url string) NotifierConfig {
+				return NotifierConfig{Name: "hook", Type: "webhook", URL: url + "/hook"}
+			},
+			wantPath: "/hook",
+			check: func(t *testing.T, c *captured) {
+				var got struct {
+					Kind     string            `json:"kind"`
+					Listings []listing.Listing `json:"listings"`
+				}
+				if err := json.Unmarshal([]byte(c.body), &got); err != nil {
+					t.Fatal(err)
+				}
+				if got.Kind != KindAlert || len(got.Listings) != 1 {
+					t.Errorf("unexpected webhook body %s", c.body)
+				}
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv, c := newCaptureServer(t, http.StatusOK)
+
+			n, err := New(tt.config(srv.URL))
+			if err != nil {
+				t.Fatalf("New() returned an error: %v", err)
+			}
+			if err := n.Notify(testMessage); err != nil {
+				t.Fatalf("Notify() returned an error: %v", err)
+			}
+
+			if c.path != tt.wantPath {
+				t.Errorf("expected path to be %s, got %s", tt.wantPath, c.path)
+			}
+			tt.check(t, c)
+		})
+	}
+}
+
+// Test that a non 2xx answer is reported with its status code
+func TestNotifyStatusError(t *testing.T) {
+	srv, _ := newCaptureServer(t, http.StatusBadGateway)
+
+	n, err := New(NotifierConfig{Name: "hook", Type: "webhook", URL: srv.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = n.Notify(testMessage)
+	if err == nil || !strings.Contains(err.Error(), "502") {
+		t.Errorf("expected error with 502 status, got %v", err)
+	}
+}
+
+// Test that an error of the request does not have the URL with the Telegram token
+func TestNotifyTransportError(t *testing.T) {
+	srv := httptest.NewServer(http.NotFoundHandler())
+	srv.Close()
+
+	n, err := New(NotifierConfig{Name: "telegram", Type: "telegram", Token: "123:secret-token", ChatID: "42", URL: srv.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = n.Notify(testMessage)
+	if err == nil || strings.Contains(err.Error(), "secret-token") {
+		t.Errorf("expected an error without the token, got %v", err)
+	}
+}
+
+// Test that Multi keeps going when one of the notifiers fails
+func TestMulti(t *testing.T) {
+	bad, _ := newCaptureServer(t, http.StatusInternalServerError)
+	good, c := newCaptureServer(t, http.StatusOK)
+
+	m := Multi{
+		&Webhook{name: "bad", url: bad.URL},
+		&Webhook{name: "good", url: good.URL},
+	}
+
+	err := m.Notify(testMessage)
+	if err == nil || !strings.HasPrefix(err.Error(), "bad:") {
+		t.Errorf("expected error from bad notifier, got %v", err)
+	}
+	if c.body == "" {
+		t.Errorf("expected good notifier to be called")
+	}
+}
+
+// Starts a minimal SMTP server that accepts one message and returns its DATA
+func newSMTPServer(t *testing.T) (string, chan string) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+
+	data := make(chan string, 1)
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		r := bufio.NewReader(conn)
+		fmt.Fprint(conn, "220 localhost ESMTP\r\n")
+		for {
+			line, err := r.ReadString('\n')
+			if err != nil {
+				return
+			}
+			cmd := strings.ToUpper(strings.TrimSpace(line))
+			switch {
+			case strings.HasPrefix(cmd, "EHLO"), strings.HasPrefix(cmd, "HELO"):
+				fmt.Fprint(conn, "250 localhost\r\n")
+			case strings.HasPrefix(cmd, "DATA"):
+				fmt.Fprint(conn, "354 go ahead\r\n")
+				var b strings.Builder
+				for {
+					l, err := r.ReadString('\n')
+					if err != nil || l == ".\r\n" {
+						break
+					}
+					b.WriteString(l)
+				}
+				data <- b.String()
+				fmt.Fprint(conn, "250 OK\r\n")
+			case strings.HasPrefix(cmd, "QUIT"):
+				fmt.Fprint(conn, "221 bye\r\n")
+				return
+			default:
+				fmt.Fprint(conn, "250 OK\r\n")
+			}
+		}
+	}()
+
+	return l.Addr().String(), data
+}
+
+// Test the SMTP notifier against a local server
+func TestSMTP(t *testing.T) {
+	addr, data := newSMTPServer(t)
+
+	n, err := New(NotifierConfig{Name: "mail", Type: "smtp", Host: addr, From: "gmah@localhost", To: []string{"me@localhost"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := n.Notify(testMessage); err != nil {
+		t.Fatalf("Notify() returned an error: %v", err)
+	}
+
+	got := <-data
+	if !strings.Contains(got, "To: me@localhost") || !strings.Contains(got, "https://casayes.pt/1") {
+		t.Errorf("unexpected email %s", got)
+	}
+}
+
+// Test that the errors and the summary reach the payload
+func TestPayloadErrors(t *testing.T) {
+	srv, c := newCaptureServer(t, http.StatusOK)
+
+	summary := RunSummary{Messages: 0, Portals: map[string]int{}, Failed: true}
+	errs := []PayloadError{
+		{Stage: StageIMAP, Message: "Invalid credentials (Failure)"},
+		{Stage: StageParse, Portal: "idealista", Message: `no link found in "Novo anúncio"`},
+	}
+	if err := NotifyNewDay(&Relay{name: "relay", url: srv.URL}, summary, nil, "http://localhost:9090", errs); err != nil {
+		t.Fatal(err)
+	}
+
+	var got Payload
+	if err := json.Unmarshal([]byte(c.body), &got); err != nil {
+		t.Fatal(err)
+	}
+	if got.Kind != KindDaily || got.Summary == nil || !got.Summary.Failed {
+		t.Errorf("expected failed daily summary, got %s", c.body)
+	}
+	if len(got.Errors) != 2 || got.Errors[0] != errs[0] || got.Errors[1] != errs[1] {
+		t.Errorf("expected errors %v, got %v", errs, got.Errors)
+	}
+	if !strings.Contains(got.Text, "⚠ imap: Invalid credentials (Failure)") {
+		t.Errorf("expected errors in text, got %s", got.Text)
+	}
+}
@@ -0,0 +1,27 @@
+package requests
+
+// Ntfy publishes to a ntfy topic
+type Ntfy struct {
+	name   string
+	server string
+	topic  string
+	token  string
+}
+
+func (n *Ntfy) Name() string { return n.name }
+
+func (n *Ntfy) Notify(m Message) error {
+	headers := map[string]string{
+		"Title": m.Title,
+		"Tags":  "house",
+	}
+	if m.Link != "" {
+		headers["Click"] = m.Link
+		headers["Actions"] = "view, Open page, " + m.Link
+	}
+	if n.token != "" {
+		headers["Authorization"] = "Bearer " + n.token
+	}
+
+	return post(n.server+"/"+n.topic, "text/plain; charset=utf-8", []byte(render(m, ntfyLimit, false)), m, "", headers)
+}
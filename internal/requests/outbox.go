@@ -0,0 +1,332 @@
+package requests
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/BrunoTeixeira1996/gmah/internal/metrics"
+	"github.com/BrunoTeixeira1996/gmah/internal/store"
+)
+
+const (
+	outboxDocument = "outbox"
+	// Retries wait 1m, 2m, 4m, ... up to 6h, a delivery is given up after maxAttempts
+	outboxBaseDelay = time.Minute
+	outboxMaxDelay  = 6 * time.Hour
+	maxAttempts     = 20
+	// A delivery that was given up is removed after outboxRetention, or before with Delete
+	outboxRetention = 30 * 24 * time.Hour
+)
+
+// ErrUnknownDelivery is returned when an ID is not in the outbox
+var ErrUnknownDelivery = errors.New("unknown delivery")
+
+// Delivery is a message waiting to be sent to one notifier
+type Delivery struct {
+	ID          string    `json:"id"`
+	Notifier    string    `json:"notifier"`
+	Message     Message   `json:"message"`
+	CreatedAt   time.Time `json:"created_at"`
+	Attempts    int       `json:"attempts"`
+	NextAttempt time.Time `json:"next_attempt"`
+	LastError   string    `json:"last_error,omitempty"`
+	// GaveUp is set after maxAttempts, the delivery is kept for outboxRetention so it shows up in the outbox view
+	GaveUp   bool      `json:"gave_up"`
+	GaveUpAt time.Time `json:"gave_up_at,omitempty"`
+
+	inFlight bool
+}
+
+// Outbox persists every notification until its notifier accepts it
+type Outbox struct {
+	mu         sync.Mutex
+	store      *store.Store
+	notifiers  map[string]Notifier
+	deliveries []*Delivery
+	now        func() time.Time
+}
+
+// NewOutbox loads the pending deliveries from the store
+func NewOutbox(s *store.Store, notifiers map[string]Notifier) (*Outbox, error) {
+	o := &Outbox{
+		store:     s,
+		notifiers: notifiers,
+		now:       time.Now,
+	}
+	if err := s.Load(outboxDocument, &o.deliveries); err != nil {
+		return nil, err
+	}
+	// Deliveries given up before GaveUpAt existed are kept for a full retention
+	for _, d := range o.deliveries {
+		if d.GaveUp && d.GaveUpAt.IsZero() {
+			d.GaveUpAt = o.now()
+		}
+	}
+	return o, nil
+}
+
+// Function that returns a random idempotency key
+func newID() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		// crypto/rand never fails on the platforms we run on
+		panic(err)
+	}
+	return hex.EncodeToString(b)
+}
+
+// Function that returns how long to wait after a given number of attempts
+func backoff(attempts int) time.Duration {
+	d := outboxBaseDelay
+	for i := 1; i < attempts; i++ {
+		d *= 2
+		if d >= outboxMaxDelay {
+			return outboxMaxDelay
+		}
+	}
+	return d
+}
+
+// must be called with o.mu held
+func (o *Outbox) save() error {
+	return o.store.Save(outboxDocument, o.deliveries)
+}
+
+// Enqueue stores one delivery per notifier and tries to send them right away
+// the returned error is the one of the first attempt, failed deliveries are retried later
+func (o *Outbox) Enqueue(names []string, m Message) error {
+	o.mu.Lock()
+	var batch []*Delivery
+	for _, name := range names {
+		if _, ok := o.notifiers[name]; !ok {
+			o.mu.Unlock()
+			return fmt.Errorf("unknown notifier %q", name)
+		}
+		d := &Delivery{
+			ID:          newID(),
+			Notifier:    name,
+			Message:     m,
+			CreatedAt:   o.now(),
+			NextAttempt: o.now(),
+		}
+		d.Message.ID = d.ID
+		d.inFlight = true
+		batch = append(batch, d)
+	}
+	o.deliveries = append(o.deliveries, batch...)
+	if err := o.save(); err != nil {
+		slog.Error("Error while saving the outbox", "run_id", m.RunID, "err", err)
+	}
+	o.mu.Unlock()
+
+	var errs []string
+	for _, d := range batch {
+		if err := o.deliver(d); err != nil {
+			errs = append(errs, fmt.Sprintf("%s: %v (will retry)", d.Notifier, err))
+		}
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("%s", strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+// Function that sends a delivery and updates the outbox with the outcome
+// the caller marks the delivery as in flight so it is never sent twice at the same time
+func (o *Outbox) deliver(d *Delivery) error {
+	o.mu.Lock()
+	n := o.notifiers[d.Notifier]
+	m := d.Message
+	o.mu.Unlock()
+
+	err := n.Notify(m)
+
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	logger := slog.With("run_id", m.RunID, "delivery", d.ID, "notifier", d.Notifier)
+
+	d.inFlight = false
+	d.Attempts++
+	if err == nil {
+		metrics.Notifications.Inc(d.Notifier, "sent")
+		logger.Info("Delivered notification", "kind", m.Kind, "attempt", d.Attempts)
+		for i, other := range o.deliveries {
+			if other == d {
+				o.deliveries = append(o.deliveries[:i], o.deliveries[i+1:]...)
+				break
+			}
+		}
+	} else {
+		metrics.Notifications.Inc(d.Notifier, "failed")
+		// The outbox is served to readers, it only keeps the cause and never the URL of the request
+		err = withoutURL(err)
+		logger.Warn("Error while delivering notification", "kind", m.Kind, "attempt", d.Attempts, "err", err)
+		d.LastError = err.Error()
+		d.NextAttempt = o.now().Add(backoff(d.Attempts))
+		if d.Attempts >= maxAttempts {
+			d.GaveUp = true
+			d.GaveUpAt = o.now()
+			logger.Error("Giving up on delivery", "attempts", d.Attempts, "err", err)
+		}
+	}
+
+	if serr := o.save(); serr != nil {
+		logger.Error("Error while saving the outbox", "err", serr)
+	}
+
+	return err
+}
+
+// Function that removes the deliveries given up more than outboxRetention ago, it tells if any was
+// must be called with o.mu held
+func (o *Outbox) prune() bool {
+	kept := o.deliveries[:0]
+	for _, d := range o.deliveries {
+		if d.GaveUp && o.now().Sub(d.GaveUpAt) > outboxRetention {
+			continue
+		}
+		kept = append(kept, d)
+	}
+	pruned := len(kept) < len(o.deliveries)
+	o.deliveries = kept
+	return pruned
+}
+
+// Delete removes a delivery from the outbox, to acknowledge one that was given up
+func (o *Outbox) Delete(id string) error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	for i, d := range o.deliveries {
+		if d.ID == id {
+			o.deliveries = append(o.deliveries[:i], o.deliveries[i+1:]...)
+			return o.save()
+		}
+	}
+	return ErrUnknownDelivery
+}
+
+// Retry sends every delivery whose next attempt is due and removes the old ones that were given up
+func (o *Outbox) Retry() {
+	o.mu.Lock()
+	if o.prune() {
+		if err := o.save(); err != nil {
+			slog.Error("Error while saving the outbox", "err", err)
+		}
+	}
+	var due []*Delivery
+	for _, d := range o.deliveries {
+		if d.GaveUp || d.inFlight || o.now().Before(d.NextAttempt) {
+			continue
+		}
+		// A notifier removed from the config can't be retried
+		if _, ok := o.notifiers[d.Notifier]; !ok {
+			continue
+		}
+		d.inFlight = true
+		due = append(due, d)
+	}
+	o.mu.Unlock()
+
+	// deliver logs the outcome of every retry
+	for _, d := range due {
+		o.deliver(d)
+	}
+}
+
+// Run retries the due deliveries every interval until stop is closed
+func (o *Outbox) Run(interval time.Duration, stop <-chan struct{}) {
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-t.C:
+			o.Retry()
+		}
+	}
+}
+
+// Flush tries once more every delivery that was not given up, without waiting for its backoff
+// it stops early when ctx is done and returns how many deliveries are still pending,
+// they stay in the store and are retried after the next start
+func (o *Outbox) Flush(ctx context.Context) int {
+	o.mu.Lock()
+	var pending []*Delivery
+	for _, d := range o.deliveries {
+		if d.GaveUp || d.inFlight {
+			continue
+		}
+		if _, ok := o.notifiers[d.Notifier]; !ok {
+			continue
+		}
+		d.inFlight = true
+		pending = append(pending, d)
+	}
+	o.mu.Unlock()
+
+	left := 0
+	for _, d := range pending {
+		if ctx.Err() != nil {
+			o.mu.Lock()
+			d.inFlight = false
+			o.mu.Unlock()
+			left++
+			continue
+		}
+		if err := o.deliver(d); err != nil {
+			left++
+		}
+	}
+	return left
+}
+
+// Pending returns a copy of the deliveries not yet accepted, oldest first
+func (o *Outbox) Pending() []Delivery {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	pending := []Delivery{}
+	for _, d := range o.deliveries {
+		pending = append(pending, *d)
+	}
+	sort.Slice(pending, func(i, j int) bool { return pending[i].CreatedAt.Before(pending[j].CreatedAt) })
+	return pending
+}
+
+// Notifier returns a Notifier that queues the messages for the named notifiers
+func (o *Outbox) Notifier(names ...string) Notifier {
+	return &queued{outbox: o, names: names}
+}
+
+// RunNotifier is like Notifier but tags the messages with the run that sent them
+func (o *Outbox) RunNotifier(runID string, names ...string) Notifier {
+	return &queued{outbox: o, names: names, runID: runID}
+}
+
+type queued struct {
+	outbox *Outbox
+	names  []string
+	runID  string
+}
+
+func (q *queued) Name() string {
+	return "outbox(" + strings.Join(q.names, ",") + ")"
+}
+
+func (q *queued) Notify(m Message) error {
+	if q.runID != "" {
+		m.RunID = q.runID
+	}
+	return q.outbox.Enqueue(q.names, m)
+}
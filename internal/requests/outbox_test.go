@@ -0,0 +1,253 @@
+package requests
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/BrunoTeixeira1996/gmah/internal/store"
+)
+
+// Test the backoff function
+func TestBackoff(t *testing.T) {
+	tests := map[int]time.Duration{
+		1:  time.Minute,
+		2:  2 * time.Minute,
+		5:  16 * time.Minute,
+		10: outboxMaxDelay,
+	}
+	for attempts, want := range tests {
+		if got := backoff(attempts); got != want {
+			t.Errorf("expected backoff(%d) to be %s, got %s", attempts, want, got)
+		}
+	}
+}
+
+// Test that a failed delivery survives a restart and is retried
+// with the same idempotency key and a valid signature
+func TestOutboxRetry(t *testing.T) {
+	var (
+		mu    sync.Mutex
+		fail  = true
+		keys  []string
+		valid = true
+	)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := io.ReadAll(r.Body)
+		mu.Lock()
+		defer mu.Unlock()
+		keys = append(keys, r.Header.Get("Idempotency-Key"))
+		if r.Header.Get("X-Gmah-Signature") != Sign("shh", r.Header.Get("X-Gmah-Timestamp"), b) {
+			valid = false
+		}
+		if fail {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer srv.Close()
+
+	st, err := store.Open(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	notifiers := map[string]Notifier{"hook": &Webhook{name: "hook", url: srv.URL, secret: "shh"}}
+
+	o, err := NewOutbox(st, notifiers)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := o.Notifier("hook").Notify(testMessage); err == nil {
+		t.Fatalf("expected first attempt to fail")
+	}
+
+	// Reload the outbox like gmah does after a restart
+	o, err = NewOutbox(st, notifiers)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pending := o.Pending()
+	if len(pending) != 1 || pending[0].Attempts != 1 || pending[0].LastError == "" {
+		t.Fatalf("expected one pending delivery with one attempt, got %+v", pending)
+	}
+
+	// Not due yet
+	o.Retry()
+	if len(keys) != 1 {
+		t.Fatalf("expected no retry before the backoff, got %d requests", len(keys))
+	}
+
+	o.now = func() time.Time { return time.Now().Add(time.Hour) }
+	mu.Lock()
+	fail = false
+	mu.Unlock()
+	o.Retry()
+
+	if len(o.Pending()) != 0 {
+		t.Errorf("expected outbox to be empty, got %+v", o.Pending())
+	}
+	if len(keys) != 2 || keys[0] == "" || keys[0] != keys[1] {
+		t.Errorf("expected the same idempotency key on every attempt, got %v", keys)
+	}
+	if !valid {
+		t.Errorf("expected every request to be signed")
+	}
+}
+
+// Test that a failed delivery keeps its error without the Telegram token, the outbox is served to readers
+func TestOutboxErrorWithoutToken(t *testing.T) {
+	srv := httptest.NewServer(http.NotFoundHandler())
+	srv.Close()
+
+	st, err := store.Open(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	notifiers := map[string]Notifier{"telegram": &Telegram{name: "telegram", api: srv.URL, token: "123:secret-token", chatID: "42"}}
+	o, err := NewOutbox(st, notifiers)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := o.Notifier("telegram").Notify(testMessage); err == nil {
+		t.Fatalf("expected the delivery to fail")
+	}
+
+	pending := o.Pending()
+	if len(pending) != 1 || pending[0].LastError == "" || strings.Contains(pending[0].LastError, "secret-token") {
+		t.Errorf("expected an error without the token, got %+v", pending)
+	}
+	b, err := os.ReadFile(filepath.Join(st.Dir(), outboxDocument+".json"))
+	if err != nil || strings.Contains(string(b), "secret-token") {
+		t.Errorf("expected a saved outbox without the token (%v)", err)
+	}
+}
+
+// Notifier that fails with the error of a request
+type failingNotifier struct{ err error }
+
+func (f failingNotifier) Name() string           { return "failing" }
+func (f failingNotifier) Notify(m Message) error { return f.err }
+
+// Test that the outbox keeps the cause of an error and not the URL of the request
+func TestOutboxErrorWithoutURL(t *testing.T) {
+	st, err := store.Open(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	notifiers := map[string]Notifier{"failing": failingNotifier{&url.Error{Op: "Post", URL: "https://api.telegram.org/bot123:secret-token/sendMessage", Err: errors.New("connection refused")}}}
+	o, err := NewOutbox(st, notifiers)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := o.Notifier("failing").Notify(testMessage); err == nil || strings.Contains(err.Error(), "secret-token") {
+		t.Fatalf("expected an error without the URL, got %v", err)
+	}
+	if pending := o.Pending(); len(pending) != 1 || pending[0].LastError != "connection refused" {
+		t.Errorf("expected only the cause in the outbox, got %+v", pending)
+	}
+}
+
+// Test that Flush sends the pending deliveries without waiting for the backoff
+func TestOutboxFlush(t *testing.T) {
+	var (
+		mu   sync.Mutex
+		fail = true
+	)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		if fail {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer srv.Close()
+
+	st, err := store.Open(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	o, err := NewOutbox(st, map[string]Notifier{"hook": &Webhook{name: "hook", url: srv.URL}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := o.Notifier("hook").Notify(testMessage); err == nil {
+		t.Fatalf("expected first attempt to fail")
+	}
+
+	// A done context leaves everything in the outbox
+	done, cancel := context.WithCancel(context.Background())
+	cancel()
+	if left := o.Flush(done); left != 1 {
+		t.Fatalf("expected 1 pending delivery, got %d", left)
+	}
+
+	mu.Lock()
+	fail = false
+	mu.Unlock()
+	if left := o.Flush(context.Background()); left != 0 || len(o.Pending()) != 0 {
+		t.Errorf("expected the outbox to be empty, got %d left", left)
+	}
+}
+
+// Test that the deliveries given up are removed after the retention or with Delete
+func TestOutboxPrune(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer srv.Close()
+
+	st, err := store.Open(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	o, err := NewOutbox(st, map[string]Notifier{"hook": &Webhook{name: "hook", url: srv.URL}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 2; i++ {
+		o.Notifier("hook").Notify(testMessage)
+	}
+
+	// Every retry is due an hour later until both are given up
+	now := time.Now()
+	for i := 1; i < maxAttempts; i++ {
+		now = now.Add(outboxMaxDelay)
+		o.now = func() time.Time { return now }
+		o.Retry()
+	}
+	pending := o.Pending()
+	if len(pending) != 2 || !pending[0].GaveUp || !pending[1].GaveUp {
+		t.Fatalf("expected 2 deliveries given up, got %+v", pending)
+	}
+
+	if err := o.Delete(pending[0].ID); err != nil {
+		t.Fatal(err)
+	}
+	if err := o.Delete(pending[0].ID); err != ErrUnknownDelivery {
+		t.Errorf("expected the delivery to be gone, got %v", err)
+	}
+
+	// Still kept the day after, gone after the retention even after a restart
+	o.now = func() time.Time { return now.Add(24 * time.Hour) }
+	o.Retry()
+	if len(o.Pending()) != 1 {
+		t.Fatalf("expected 1 delivery, got %d", len(o.Pending()))
+	}
+	o.now = func() time.Time { return now.Add(outboxRetention + time.Hour) }
+	o.Retry()
+	if o, err = NewOutbox(st, nil); err != nil || len(o.Pending()) != 0 {
+		t.Errorf("expected the outbox to be empty, got %d (%v)", len(o.Pending()), err)
+	}
+}
@@ -0,0 +1,111 @@
+package requests
+
+import (
+	"strconv"
+
+	"github.com/BrunoTeixeira1996/gmah/internal/listing"
+)
+
+// PayloadVersion is bumped every time a field of Payload changes meaning or is removed
+const PayloadVersion = 1
+
+// Stages where a run can fail
+const (
+	StageIMAP   = "imap"
+	StageParse  = "parse"
+	StageRender = "render"
+	StageLookup = "lookup"
+)
+
+// PayloadError describes one failure, Portal is only set when the failure is specific to one
+type PayloadError struct {
+	Stage   string `json:"stage"`
+	Message string `json:"message"`
+	Portal  string `json:"portal,omitempty"`
+}
+
+// RunSummary sums up a run for the daily message
+type RunSummary struct {
+	Messages   int            `json:"messages"`
+	Listings   int            `json:"listings"`
+	New        int            `json:"new"`
+	Duplicates int            `json:"duplicates"`
+	Portals    map[string]int `json:"portals"`
+	Failed     bool           `json:"failed"`
+}
+
+// Payload is the JSON sent by the relay and webhook notifiers, kind is one of the Kind constants
+// and stage one of the Stage constants
+//
+//	{
+//	  "version": 1,
+//	  "kind": "daily" | "lookup" | "alert" | "parser" | "report",
+//	  "date": "2006-01-02",
+//	  "title": "...", "text": "...", "link": "...",
+//	  "search": "...", "mode": "instant" | "digest",
+//	  "listings": [{"portal": "...", "title": "...", ...}],
+//	  "summary": {"messages": 3, "listings": 3, "portals": {"idealista": 2}, "failed": false},
+//	  "errors": [{"stage": "imap" | "parse" | "render" | "lookup", "message": "...", "portal": "..."}]
+//	}
+type Payload struct {
+	Version  int               `json:"version"`
+	Kind     string            `json:"kind"`
+	Date     string            `json:"date"`
+	Title    string            `json:"title"`
+	Text     string            `json:"text"`
+	Link     string            `json:"link,omitempty"`
+	Search   string            `json:"search,omitempty"`
+	Mode     string            `json:"mode,omitempty"`
+	Listings []listing.Listing `json:"listings"`
+	Summary  *RunSummary       `json:"summary,omitempty"`
+	Errors   []PayloadError    `json:"errors"`
+}
+
+// NewPayload builds the payload of a message with its text rendered up to limit
+func NewPayload(m Message, limit int) Payload {
+	p := Payload{
+		Version:  PayloadVersion,
+		Kind:     m.Kind,
+		Date:     m.Date,
+		Title:    m.Title,
+		Text:     render(m, limit, false),
+		Link:     m.Link,
+		Search:   m.Search,
+		Mode:     m.Mode,
+		Listings: m.Listings,
+		Summary:  m.Summary,
+		Errors:   m.Errors,
+	}
+	// Always send arrays so receivers don't need to care about null
+	if p.Listings == nil {
+		p.Listings = []listing.Listing{}
+	}
+	if p.Errors == nil {
+		p.Errors = []PayloadError{}
+	}
+	return p
+}
+
+// RelayPayload is the payload with the fields the telegram bot in the LAN read before it existed,
+// date and link are shared with the payload
+type RelayPayload struct {
+	Payload
+	// Lookup is "true" for the lookups and "false" for everything else
+	Lookup string `json:"lookup"`
+	// Count is the number of new messages, empty for the lookups
+	Count string `json:"count"`
+	// Error is what the error of the old messages was encoded to, {} when there are errors and null otherwise
+	Error *struct{} `json:"Error"`
+}
+
+// NewRelayPayload builds the payload of a message with the old fields of the relay
+func NewRelayPayload(m Message, limit int) RelayPayload {
+	p := RelayPayload{Payload: NewPayload(m, limit), Lookup: "false", Count: strconv.Itoa(m.Count)}
+	if m.Kind == KindLookup {
+		p.Lookup, p.Count = "true", ""
+	}
+	if len(m.Errors) > 0 {
+		p.Error = &struct{}{}
+	}
+	return p
+}
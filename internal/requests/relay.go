@@ -0,0 +1,14 @@
+package requests
+
+// Relay posts the payload to the telegram bot relay in the LAN, with the old fields the bot reads
+type Relay struct {
+	name   string
+	url    string
+	secret string
+}
+
+func (r *Relay) Name() string { return r.name }
+
+func (r *Relay) Notify(m Message) error {
+	return postJSON(r.url, NewRelayPayload(m, relayLimit), m, r.secret, nil)
+}
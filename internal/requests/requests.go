@@ -1,100 +1,111 @@
 package requests
 
 import (
-	"bytes"
-	"encoding/json"
-	"log"
-	"net/http"
+	"fmt"
+	"sort"
+	"strings"
 	"time"
+
+	"github.com/BrunoTeixeira1996/gmah/internal/listing"
 )
 
-// Notifies telegram bot with link for the current day
-func NotifyTelegramBot(newMessages string, isGokrazy bool, err error) error {
-	var link string
-	url := "http://192.168.30.21:8000/gmah"
+// Function that returns the link of the page for a given file
+func pageLink(baseURL string, fileName string) string {
+	return baseURL + "/dump/" + fileName
+}
 
-	if isGokrazy {
-		link = "http://192.168.30.12:9090/dump/" + time.Now().Format("2006-01-02") + "_serve.html"
-	} else {
-		link = "http://localhost:9090/dump/" + time.Now().Format("2006-01-02") + "_serve.html"
-	}
+// Notifies with the listings, the summary and the failures of the current day
+// baseURL is the address of the gmah server, like http://192.168.1.10:9090
+func NotifyNewDay(n Notifier, summary RunSummary, listings []listing.Listing, baseURL string, errs []PayloadError) error {
+	date := time.Now().Format("2006-01-02")
 
-	newDay := struct {
-		Lookup string `json:"lookup"`
-		Date   string `json:"date"`
-		Link   string `json:"link"`
-		Count  string `json:"count"`
-		Error  error
-	}{
-		Lookup: "false",
-		Date:   time.Now().Format("2006-01-02"),
-		Link:   link,
-		Count:  newMessages,
-		Error:  err,
+	text := fmt.Sprintf("Got %d new messages", summary.Messages)
+	if len(errs) > 0 {
+		text += fmt.Sprintf(" (%d errors)", len(errs))
 	}
 
-	var buffer bytes.Buffer
-	json.NewEncoder(&buffer).Encode(&newDay)
-
-	r, err := http.NewRequest("POST", url, &buffer)
-	if err != nil {
-		return err
-	}
-	r.Header.Add("Content-Type", "application/json")
+	return n.Notify(Message{
+		Kind:     KindDaily,
+		Title:    "gmah " + date,
+		Text:     text,
+		Link:     pageLink(baseURL, date+"_serve.html"),
+		Date:     date,
+		Count:    summary.Messages,
+		Listings: listings,
+		Summary:  &summary,
+		Errors:   errs,
+		BaseURL:  baseURL,
+	})
+}
 
-	client := &http.Client{}
-	res, err := client.Do(r)
-	if err != nil {
-		return err
+// Notifies about a lookup of the days described by label
+// link points to the page with the days that were found
+func NotifyLookup(n Notifier, label string, found int, link string) error {
+	m := Message{
+		Kind:  KindLookup,
+		Title: "gmah lookup " + label,
+		Date:  time.Now().Format("2006-01-02"),
+		Count: found,
 	}
-	defer res.Body.Close()
-
-	if res.StatusCode != http.StatusOK {
-		log.Println("Got 500 status while notifying telegram bot")
+	if found == 0 {
+		err := fmt.Sprintf("Could not find any page from %s", label)
+		m.Text = err
+		m.Errors = []PayloadError{{Stage: StageLookup, Message: err}}
+	} else {
+		m.Text = fmt.Sprintf("Found %d daily pages from %s", found, label)
+		m.Link = link
 	}
 
-	return nil
+	return n.Notify(m)
 }
 
-// Notifies telegram bot about a specific date lookup
-// send the error in lookUp anonymous struct if any
-func NotifyTelegramBotAboutSpecificLookup(wantFileName string, date string, err error) error {
-	url := "http://192.168.30.21:8000/gmah"
-	link := "http://192.168.30.12:9090/dump/" + wantFileName
+// Notifies about listings that matched a saved search
+func NotifySavedSearch(n Notifier, name string, mode string, listings []listing.Listing, baseURL string) error {
+	date := time.Now().Format("2006-01-02")
+
+	return n.Notify(Message{
+		Kind:     KindAlert,
+		Title:    "gmah alert: " + name,
+		Text:     fmt.Sprintf("%d new listings matched %s", len(listings), name),
+		Link:     pageLink(baseURL, date+"_serve.html"),
+		Date:     date,
+		Count:    len(listings),
+		Search:   name,
+		Mode:     mode,
+		Listings: listings,
+		BaseURL:  baseURL,
+	})
+}
 
-	lookUp := struct {
-		Lookup string `json:"lookup"`
-		Date   string `json:"date"`
-		Link   string `json:"link"`
-		Count  string `json:"count"`
-		Error  error
-	}{
-		Lookup: "true",
-		Date:   date,
-		Link:   link,
-		Count:  "",
-		Error:  err,
+// Notifies that the emails of a portal stopped having some of the fields
+// missing counts the emails without each field, the link points to the run report with the samples
+func NotifyParserBreakage(n Notifier, portal string, messages int, missing map[string]int, rate float64, runID string, baseURL string) error {
+	var fields []string
+	for f, count := range missing {
+		fields = append(fields, fmt.Sprintf("%s (%d)", f, count))
 	}
+	sort.Strings(fields)
 
-	var buffer bytes.Buffer
-	json.NewEncoder(&buffer).Encode(&lookUp)
+	detail := fmt.Sprintf("missing %s in %d emails, %.0f%% were complete before this run", strings.Join(fields, ", "), messages, rate*100)
 
-	r, err := http.NewRequest("POST", url, &buffer)
-	if err != nil {
-		return err
-	}
-	r.Header.Add("Content-Type", "application/json")
-
-	client := &http.Client{}
-	res, err := client.Do(r)
-	if err != nil {
-		return err
-	}
-	defer res.Body.Close()
-
-	if res.StatusCode != http.StatusOK {
-		log.Println("Got 500 status while notifying telegram bot")
-	}
+	return n.Notify(Message{
+		Kind:   KindParser,
+		Title:  "gmah parser broken: " + portal,
+		Text:   "The emails of " + portal + " changed, the offending message is attached to the run report",
+		Link:   baseURL + "/runs/" + runID,
+		Date:   time.Now().Format("2006-01-02"),
+		Count:  messages,
+		Errors: []PayloadError{{Stage: StageParse, Portal: portal, Message: detail}},
+	})
+}
 
-	return nil
+// NotifyReport sends the summary of a weekly or monthly report with the link to its page
+func NotifyReport(n Notifier, kind string, period string, summary string, link string) error {
+	return n.Notify(Message{
+		Kind:  KindReport,
+		Title: fmt.Sprintf("gmah %s report %s", kind, period),
+		Text:  summary,
+		Link:  link,
+		Date:  time.Now().Format("2006-01-02"),
+	})
 }
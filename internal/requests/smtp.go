@@ -0,0 +1,47 @@
+package requests
+
+import (
+	"bytes"
+	"fmt"
+	"mime"
+	"net"
+	"net/smtp"
+	"strings"
+	"time"
+)
+
+// SMTP sends the message as a plain text email
+type SMTP struct {
+	name     string
+	host     string
+	username string
+	password string
+	from     string
+	to       []string
+}
+
+func (s *SMTP) Name() string { return s.name }
+
+func (s *SMTP) Notify(m Message) error {
+	var auth smtp.Auth
+	if s.username != "" {
+		hostname, _, err := net.SplitHostPort(s.host)
+		if err != nil {
+			return err
+		}
+		auth = smtp.PlainAuth("", s.username, s.password, hostname)
+	}
+
+	var body bytes.Buffer
+	fmt.Fprintf(&body, "From: %s\r\n", s.from)
+	fmt.Fprintf(&body, "To: %s\r\n", strings.Join(s.to, ", "))
+	fmt.Fprintf(&body, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", m.Title))
+	fmt.Fprintf(&body, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	body.WriteString("MIME-Version: 1.0\r\n")
+	body.WriteString("Content-Type: text/plain; charset=utf-8\r\n")
+	body.WriteString("\r\n")
+	body.WriteString(strings.ReplaceAll(render(m, smtpLimit, false), "\n", "\r\n"))
+	body.WriteString("\r\n")
+
+	return smtp.SendMail(s.host, auth, s.from, s.to, body.Bytes())
+}
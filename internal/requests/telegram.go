@@ -0,0 +1,40 @@
+package requests
+
+// Telegram talks directly to the Telegram Bot API
+type Telegram struct {
+	name   string
+	api    string
+	token  string
+	chatID string
+}
+
+func (t *Telegram) Name() string { return t.name }
+
+func (t *Telegram) Notify(m Message) error {
+	type button struct {
+		Text string `json:"text"`
+		URL  string `json:"url"`
+	}
+	type markup struct {
+		InlineKeyboard [][]button `json:"inline_keyboard"`
+	}
+
+	body := struct {
+		ChatID                string  `json:"chat_id"`
+		Text                  string  `json:"text"`
+		ParseMode             string  `json:"parse_mode"`
+		DisableWebPagePreview bool    `json:"disable_web_page_preview"`
+		ReplyMarkup           *markup `json:"reply_markup,omitempty"`
+	}{
+		ChatID:                t.chatID,
+		Text:                  render(m, telegramLimit, true),
+		ParseMode:             "HTML",
+		DisableWebPagePreview: true,
+	}
+
+	if m.Link != "" {
+		body.ReplyMarkup = &markup{InlineKeyboard: [][]button{{{Text: "Open page", URL: m.Link}}}}
+	}
+
+	return postJSON(t.api+"/bot"+t.token+"/sendMessage", body, m, "", nil)
+}
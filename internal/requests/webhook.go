@@ -0,0 +1,14 @@
+package requests
+
+// Webhook posts the payload to any URL
+type Webhook struct {
+	name   string
+	url    string
+	secret string
+}
+
+func (w *Webhook) Name() string { return w.name }
+
+func (w *Webhook) Notify(m Message) error {
+	return postJSON(w.url, NewPayload(m, webhookLimit), m, w.secret, nil)
+}
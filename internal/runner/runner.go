@@ -0,0 +1,285 @@
+package runner
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"log/slog"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/BrunoTeixeira1996/gmah/internal/extraction"
+	"github.com/BrunoTeixeira1996/gmah/internal/metrics"
+	"github.com/BrunoTeixeira1996/gmah/internal/requests"
+	"github.com/BrunoTeixeira1996/gmah/internal/store"
+)
+
+// Trigger is what asked for a run
+type Trigger string
+
+const (
+	TriggerCron   Trigger = "cron"
+	TriggerDemand Trigger = "demand"
+	// TriggerStartup is a catch-up run for a cron run missed while gmah was down
+	TriggerStartup Trigger = "startup"
+	// TriggerDebug is the single run of -debug on the test mailbox, it never counts as the daily run
+	TriggerDebug Trigger = "debug"
+)
+
+// Status of a run
+type Status string
+
+const (
+	StatusQueued  Status = "queued"
+	StatusRunning Status = "running"
+	StatusDone    Status = "done"
+	StatusFailed  Status = "failed"
+)
+
+// Result is what a run produced
+type Result struct {
+	Summary  requests.RunSummary     `json:"summary"`
+	Errors   []requests.PayloadError `json:"errors"`
+	Warnings []string                `json:"warnings"`
+	// Samples are the messages the parsers failed on
+	Samples []extraction.Sample `json:"samples,omitempty"`
+}
+
+// Run is one execution of the lookup
+type Run struct {
+	ID         string    `json:"id"`
+	Trigger    Trigger   `json:"trigger"`
+	Status     Status    `json:"status"`
+	QueuedAt   time.Time `json:"queued_at"`
+	StartedAt  time.Time `json:"started_at,omitempty"`
+	FinishedAt time.Time `json:"finished_at,omitempty"`
+	Result     *Result   `json:"result,omitempty"`
+	Error      string    `json:"error,omitempty"`
+
+	done chan struct{}
+}
+
+// Func performs the run, a returned error marks the run as failed
+type Func func(run Run) (Result, error)
+
+const runsDocument = "runs"
+
+// Coordinator makes sure only one run happens at a time
+// cron and /demand both go through it so they never race on IMAP or on the output file
+// finished runs are kept in the store for the retention period
+type Coordinator struct {
+	mu        sync.Mutex
+	fn        Func
+	store     *store.Store
+	retention time.Duration
+	runs      map[string]*Run
+	queued    *Run
+	wake      chan struct{}
+}
+
+// New returns a coordinator that executes fn, Loop must be running for the runs to start
+func New(fn Func, s *store.Store, retention time.Duration) (*Coordinator, error) {
+	c := &Coordinator{
+		fn:        fn,
+		store:     s,
+		retention: retention,
+		runs:      map[string]*Run{},
+		wake:      make(chan struct{}, 1),
+	}
+
+	var history []*Run
+	if err := s.Load(runsDocument, &history); err != nil {
+		return nil, err
+	}
+	for _, r := range history {
+		r.done = make(chan struct{})
+		// A run that was in progress when gmah stopped never finished
+		if r.Status == StatusQueued || r.Status == StatusRunning {
+			r.Status = StatusFailed
+			r.Error = "interrupted"
+			r.FinishedAt = r.QueuedAt
+		}
+		close(r.done)
+		c.runs[r.ID] = r
+
+		if r.Status == StatusDone && float64(r.FinishedAt.Unix()) > metrics.LastSuccessfulRun.Value() {
+			metrics.LastSuccessfulRun.Set(float64(r.FinishedAt.Unix()))
+		}
+	}
+
+	return c, nil
+}
+
+// must be called with c.mu held
+func (c *Coordinator) save() error {
+	cutoff := time.Now().Add(-c.retention)
+	var history []*Run
+	for id, r := range c.runs {
+		if c.retention > 0 && !r.FinishedAt.IsZero() && r.FinishedAt.Before(cutoff) {
+			delete(c.runs, id)
+			continue
+		}
+		history = append(history, r)
+	}
+	sort.Slice(history, func(i, j int) bool { return history[i].QueuedAt.Before(history[j].QueuedAt) })
+	return c.store.Save(runsDocument, history)
+}
+
+// List returns every run kept, newest first
+func (c *Coordinator) List() []Run {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	runs := make([]Run, 0, len(c.runs))
+	for _, r := range c.runs {
+		runs = append(runs, *r)
+	}
+	sort.Slice(runs, func(i, j int) bool { return runs[i].QueuedAt.After(runs[j].QueuedAt) })
+	return runs
+}
+
+// Last returns the newest run started by one of the triggers
+func (c *Coordinator) Last(triggers ...Trigger) (Run, bool) {
+	for _, r := range c.List() {
+		for _, t := range triggers {
+			if r.Trigger == t {
+				return r, true
+			}
+		}
+	}
+	return Run{}, false
+}
+
+// ConsecutiveFailures returns how many of the newest finished runs failed in a row
+func (c *Coordinator) ConsecutiveFailures() int {
+	n := 0
+	for _, r := range c.List() {
+		if r.Status == StatusQueued || r.Status == StatusRunning {
+			continue
+		}
+		if r.Status != StatusFailed {
+			break
+		}
+		n++
+	}
+	return n
+}
+
+// Function that returns a run ID sortable by the time it was queued
+func newRunID(t time.Time) string {
+	b := make([]byte, 4)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return t.Format("20060102-150405") + "-" + hex.EncodeToString(b)
+}
+
+// Enqueue asks for a run and returns it
+// if a run is already waiting to start that one is returned instead and deduped is true
+func (c *Coordinator) Enqueue(trigger Trigger) (run Run, deduped bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.queued != nil {
+		return *c.queued, true
+	}
+
+	now := time.Now()
+	r := &Run{
+		ID:       newRunID(now),
+		Trigger:  trigger,
+		Status:   StatusQueued,
+		QueuedAt: now,
+		done:     make(chan struct{}),
+	}
+	c.queued = r
+	c.runs[r.ID] = r
+
+	select {
+	case c.wake <- struct{}{}:
+	default:
+	}
+
+	return *r, false
+}
+
+// Get returns a copy of the run with the given ID
+func (c *Coordinator) Get(id string) (Run, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	r, ok := c.runs[id]
+	if !ok {
+		return Run{}, false
+	}
+	return *r, true
+}
+
+// Wait blocks until the run with the given ID finishes and returns it
+func (c *Coordinator) Wait(id string) (Run, bool) {
+	c.mu.Lock()
+	r, ok := c.runs[id]
+	c.mu.Unlock()
+	if !ok {
+		return Run{}, false
+	}
+
+	<-r.done
+	return c.Get(id)
+}
+
+// Loop executes the queued runs one at a time until stop is closed
+func (c *Coordinator) Loop(stop <-chan struct{}) {
+	for {
+		select {
+		case <-stop:
+			return
+		case <-c.wake:
+		}
+
+		var run Run
+		c.mu.Lock()
+		r := c.queued
+		c.queued = nil
+		if r != nil {
+			r.Status = StatusRunning
+			r.StartedAt = time.Now()
+			run = *r
+			if err := c.save(); err != nil {
+				slog.Error("Error while saving the runs", "run_id", r.ID, "err", err)
+			}
+		}
+		c.mu.Unlock()
+
+		if r == nil {
+			continue
+		}
+
+		logger := slog.With("run_id", run.ID)
+		logger.Info("Starting run", "trigger", run.Trigger)
+		result, err := c.fn(run)
+
+		c.mu.Lock()
+		r.FinishedAt = time.Now()
+		r.Result = &result
+		r.Status = StatusDone
+		if err != nil {
+			r.Status = StatusFailed
+			r.Error = err.Error()
+		}
+		run = *r
+		close(r.done)
+		if err := c.save(); err != nil {
+			logger.Error("Error while saving the runs", "err", err)
+		}
+		c.mu.Unlock()
+
+		metrics.Runs.Inc(string(run.Trigger), string(run.Status))
+		metrics.RunDuration.Observe(run.FinishedAt.Sub(run.StartedAt).Seconds(), string(run.Trigger), string(run.Status))
+		if run.Status == StatusDone {
+			metrics.LastSuccessfulRun.Set(float64(run.FinishedAt.Unix()))
+		}
+
+		logger.Info("Finished run", "status", run.Status, "duration", run.FinishedAt.Sub(run.StartedAt), "error", run.Error)
+	}
+}
@@ -0,0 +1,137 @@
+package runner
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/BrunoTeixeira1996/gmah/internal/store"
+)
+
+// Test that concurrent requests are deduplicated and runs never overlap
+func TestCoordinator(t *testing.T) {
+	var (
+		mu      sync.Mutex
+		running int
+		overlap bool
+		calls   int
+	)
+	release := make(chan struct{})
+	started := make(chan struct{}, 10)
+
+	st, err := store.Open(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c, err := New(func(run Run) (Result, error) {
+		mu.Lock()
+		running++
+		calls++
+		if running > 1 {
+			overlap = true
+		}
+		n := calls
+		mu.Unlock()
+
+		started <- struct{}{}
+		<-release
+
+		mu.Lock()
+		running--
+		mu.Unlock()
+
+		if n == 2 {
+			return Result{}, fmt.Errorf("login failed")
+		}
+		return Result{}, nil
+	}, st, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stop := make(chan struct{})
+	defer close(stop)
+	go c.Loop(stop)
+
+	first, deduped := c.Enqueue(TriggerCron)
+	if deduped || first.Status != StatusQueued {
+		t.Fatalf("expected a new queued run, got %+v (deduped %v)", first, deduped)
+	}
+	<-started
+
+	// first is running, so a new run is queued and the next requests join it
+	second, deduped := c.Enqueue(TriggerDebug)
+	if deduped || second.ID == first.ID {
+		t.Fatalf("expected a second run to be queued, got %+v", second)
+	}
+	third, deduped := c.Enqueue(TriggerDemand)
+	if !deduped || third.ID != second.ID {
+		t.Fatalf("expected the third request to join the second run, got %+v", third)
+	}
+
+	if r, _ := c.Get(first.ID); r.Status != StatusRunning {
+		t.Errorf("expected first run to be running, got %s", r.Status)
+	}
+
+	release <- struct{}{}
+	<-started
+	release <- struct{}{}
+
+	r, ok := c.Wait(second.ID)
+	if !ok || r.Status != StatusFailed || r.Error != "login failed" {
+		t.Errorf("expected second run to fail, got %+v", r)
+	}
+	if r, _ := c.Get(first.ID); r.Status != StatusDone {
+		t.Errorf("expected first run to be done, got %s", r.Status)
+	}
+	if overlap {
+		t.Errorf("runs overlapped")
+	}
+	if calls != 2 {
+		t.Errorf("expected 2 runs, got %d", calls)
+	}
+
+	// The history survives a restart
+	c, err = New(nil, st, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if runs := c.List(); len(runs) != 2 || runs[0].ID != second.ID {
+		t.Errorf("expected 2 runs newest first in the history, got %+v", runs)
+	}
+	// The newer debug run is not the daily run
+	if last, ok := c.Last(TriggerCron, TriggerStartup); !ok || last.ID != first.ID {
+		t.Errorf("expected last cron run to be %s, got %+v", first.ID, last)
+	}
+	if n := c.ConsecutiveFailures(); n != 1 {
+		t.Errorf("expected 1 consecutive failure, got %d", n)
+	}
+}
+
+// Test that old runs are dropped after the retention period
+func TestRetention(t *testing.T) {
+	st, err := store.Open(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	old := []Run{{ID: "20200101-235900-00000000", Trigger: TriggerCron, Status: StatusDone, FinishedAt: time.Now().AddDate(0, 0, -100)}}
+	if err := st.Save(runsDocument, old); err != nil {
+		t.Fatal(err)
+	}
+
+	c, err := New(func(run Run) (Result, error) { return Result{}, nil }, st, 90*24*time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stop := make(chan struct{})
+	defer close(stop)
+	go c.Loop(stop)
+
+	r, _ := c.Enqueue(TriggerDemand)
+	c.Wait(r.ID)
+
+	if runs := c.List(); len(runs) != 1 || runs[0].ID != r.ID {
+		t.Errorf("expected only the new run to be kept, got %+v", runs)
+	}
+}
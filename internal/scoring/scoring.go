@@ -0,0 +1,185 @@
+package scoring
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+
+	"github.com/BrunoTeixeira1996/gmah/internal/geo"
+	"github.com/BrunoTeixeira1996/gmah/internal/listing"
+	"github.com/BrunoTeixeira1996/gmah/internal/requests"
+)
+
+// Config is the scoring section of the config file, every rule is optional
+// the rules with a weight give all of it to a listing on the good side of the reference,
+// nothing past the bad side and a linear share in between
+type Config struct {
+	// Budget is the price a listing gets PriceWeight at 80% of, nothing at 120%
+	Budget      int     `json:"budget"`
+	PriceWeight float64 `json:"price_weight"`
+	// PricePerM2 is the price per m² a listing gets PricePerM2Weight at 80% of, nothing at 120%
+	PricePerM2       int     `json:"price_per_m2"`
+	PricePerM2Weight float64 `json:"price_per_m2_weight"`
+	// MinArea is the area a listing gets AreaWeight from, nothing at 75% of it
+	MinArea    int     `json:"min_area"`
+	AreaWeight float64 `json:"area_weight"`
+	// Typologies, Keywords and Portals give their points to the listings that have them, they can be negative
+	Typologies map[string]float64 `json:"typologies"`
+	Keywords   map[string]float64 `json:"keywords"`
+	Portals    map[string]float64 `json:"portals"`
+	Distances  []Distance         `json:"distances"`
+}
+
+// Distance gives Weight to the listings at most Km from a point of interest of the config, nothing at twice that
+type Distance struct {
+	POI    string  `json:"poi"`
+	Km     float64 `json:"km"`
+	Weight float64 `json:"weight"`
+	// point is set by Resolve
+	point *geo.POI
+}
+
+// Enabled tells if any rule is set, without rules the listings have no score
+func (c Config) Enabled() bool {
+	return c.PriceWeight != 0 || c.PricePerM2Weight != 0 || c.AreaWeight != 0 ||
+		len(c.Typologies) > 0 || len(c.Keywords) > 0 || len(c.Portals) > 0 || len(c.Distances) > 0
+}
+
+// Resolve checks the rules and finds the points of interest of the distances
+func (c *Config) Resolve(pois []geo.POI) error {
+	weighted := []struct {
+		name      string
+		weight    float64
+		reference int
+	}{
+		{"price_weight", c.PriceWeight, c.Budget},
+		{"price_per_m2_weight", c.PricePerM2Weight, c.PricePerM2},
+		{"area_weight", c.AreaWeight, c.MinArea},
+	}
+	for _, w := range weighted {
+		if w.weight < 0 {
+			return fmt.Errorf("scoring %s must not be negative", w.name)
+		}
+		if w.weight > 0 && w.reference <= 0 {
+			return fmt.Errorf("scoring %s needs its reference value", w.name)
+		}
+	}
+
+	for i := range c.Distances {
+		d := &c.Distances[i]
+		if d.Km <= 0 || d.Weight < 0 {
+			return fmt.Errorf("scoring distance to %q needs a positive km and weight", d.POI)
+		}
+		p, ok := geo.FindPOI(pois, d.POI)
+		if !ok {
+			return fmt.Errorf("scoring uses unknown point of interest %q", d.POI)
+		}
+		d.point = &p
+	}
+	return nil
+}
+
+// Function that returns 1 when v is on the full side, 0 past zero and the share in between
+// full can be bigger or smaller than zero
+func ramp(v, full, zero float64) float64 {
+	share := (v - zero) / (full - zero)
+	return math.Max(0, math.Min(1, share))
+}
+
+// Function that rounds the points of a part to one decimal
+func round(f float64) float64 {
+	return math.Round(f*10) / 10
+}
+
+// Function that returns the points of the keys of m in s, ignoring case and accents
+func matching(m map[string]float64, s string) []string {
+	var keys []string
+	for k := range m {
+		if strings.Contains(" "+geo.Normalize(s)+" ", " "+geo.Normalize(k)+" ") {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// Score returns the score of a listing and the parts it is made of
+func (c Config) Score(l listing.Listing) (int, []listing.ScorePart) {
+	var parts []listing.ScorePart
+	add := func(rule string, points float64, detail string) {
+		parts = append(parts, listing.ScorePart{Rule: rule, Points: round(points), Detail: detail})
+	}
+
+	if c.PriceWeight > 0 {
+		if l.Price == 0 {
+			add("price", 0, "unknown price")
+		} else {
+			budget := float64(c.Budget)
+			add("price", c.PriceWeight*ramp(float64(l.Price), 0.8*budget, 1.2*budget),
+				fmt.Sprintf("%s is %.0f%% of the budget", requests.FormatPrice(l.Price), 100*float64(l.Price)/budget))
+		}
+	}
+
+	if c.PricePerM2Weight > 0 {
+		if l.Price == 0 || l.Area == 0 {
+			add("price per m²", 0, "unknown price per m²")
+		} else {
+			perM2 := l.Price / l.Area
+			target := float64(c.PricePerM2)
+			add("price per m²", c.PricePerM2Weight*ramp(float64(perM2), 0.8*target, 1.2*target),
+				fmt.Sprintf("%s per m² for %s", requests.FormatPrice(perM2), requests.FormatPrice(c.PricePerM2)))
+		}
+	}
+
+	if c.AreaWeight > 0 {
+		if l.Area == 0 {
+			add("area", 0, "unknown area")
+		} else {
+			min := float64(c.MinArea)
+			add("area", c.AreaWeight*ramp(float64(l.Area), min, 0.75*min), fmt.Sprintf("%d m² for %d m²", l.Area, c.MinArea))
+		}
+	}
+
+	for _, t := range matching(c.Typologies, l.Typology) {
+		add("typology", c.Typologies[t], t)
+	}
+
+	for _, d := range c.Distances {
+		if d.point == nil {
+			continue
+		}
+		var km float64
+		ok := false
+		if l.Place != nil {
+			km, ok = l.Place.DistanceTo(*d.point)
+		}
+		if !ok {
+			add("distance", 0, "unknown distance to "+d.point.Name)
+			continue
+		}
+		add("distance", d.Weight*ramp(km, d.Km, 2*d.Km), fmt.Sprintf("%.1f km from %s", km, d.point.Name))
+	}
+
+	for _, k := range matching(c.Keywords, l.Title) {
+		add("keyword", c.Keywords[k], k)
+	}
+
+	for _, p := range matching(c.Portals, l.Portal) {
+		add("portal", c.Portals[p], p)
+	}
+
+	var total float64
+	for _, p := range parts {
+		total += p.Points
+	}
+	return int(math.Round(total)), parts
+}
+
+// Apply sets the score of a listing, it is left empty when no rule is set
+func (c Config) Apply(l *listing.Listing) {
+	l.Score, l.ScoreParts = 0, nil
+	if c.Enabled() {
+		l.Score, l.ScoreParts = c.Score(*l)
+	}
+}
@@ -0,0 +1,93 @@
+package scoring
+
+import (
+	"testing"
+
+	"github.com/BrunoTeixeira1996/gmah/internal/geo"
+	"github.com/BrunoTeixeira1996/gmah/internal/listing"
+)
+
+// Test the Score function
+func TestScore(t *testing.T) {
+	pois := []geo.POI{{Name: "Work", Lat: 40.6302, Lon: -8.6575}}
+	c := Config{
+		Budget: 250000, PriceWeight: 30,
+		PricePerM2: 2000, PricePerM2Weight: 20,
+		MinArea: 100, AreaWeight: 10,
+		Typologies: map[string]float64{"T3": 10, "T2": -5},
+		Keywords:   map[string]float64{"garagem": 5, "jardim": 5},
+		Portals:    map[string]float64{"idealista": 2},
+		Distances:  []Distance{{POI: "work", Km: 5, Weight: 20}},
+	}
+	if err := c.Resolve(pois); err != nil {
+		t.Fatal(err)
+	}
+	esgueira := &geo.Place{District: "Aveiro", Municipality: "Aveiro", Parish: "Esgueira", Lat: 40.65, Lon: -8.63}
+	anadia := &geo.Place{District: "Aveiro", Municipality: "Anadia", Lat: 40.4386, Lon: -8.4356}
+	district := &geo.Place{District: "Aveiro", Lat: 40.6405, Lon: -8.6538}
+
+	tests := []struct {
+		name      string
+		listing   listing.Listing
+		wantScore int
+		wantParts int
+	}{
+		{
+			// price 30, 1800 per m² is 20 * 0.75, area 10, T3 10, 3.2 km 20, garagem 5, idealista 2
+			"cheap house near work",
+			listing.Listing{Portal: "idealista", Title: "Moradia T3 com garagem", Typology: "T3", Price: 198000, Area: 110, Place: esgueira},
+			92, 7,
+		},
+		{
+			// price 30 * 0.5, 3000 per m² 0, area 0, T2 -5, 28 km 0
+			"small flat far away",
+			listing.Listing{Portal: "CasaYes", Title: "Apartamento T2", Typology: "T2", Price: 250000, Area: 75, Place: anadia},
+			10, 5,
+		},
+		{
+			// Only the district is known, its seat is not the distance to work
+			"house somewhere in the district",
+			listing.Listing{Portal: "idealista", Title: "Moradia T3 com garagem", Typology: "T3", Price: 198000, Area: 110, Place: district},
+			72, 7,
+		},
+		{
+			// Unknown values give nothing but are still explained
+			"nothing known",
+			listing.Listing{Portal: "Imovirtual", Title: "Moradia com jardim"},
+			5, 5,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			score, parts := c.Score(tt.listing)
+			if score != tt.wantScore || len(parts) != tt.wantParts {
+				t.Errorf("expected %d from %d parts, got %d from %v", tt.wantScore, tt.wantParts, score, parts)
+			}
+		})
+	}
+}
+
+// Test the Resolve function
+func TestResolve(t *testing.T) {
+	pois := []geo.POI{{Name: "Work", Lat: 40.6302, Lon: -8.6575}}
+	tests := []struct {
+		name    string
+		config  Config
+		wantErr bool
+	}{
+		{"empty", Config{}, false},
+		{"price without budget", Config{PriceWeight: 10}, true},
+		{"negative weight", Config{MinArea: 100, AreaWeight: -1}, true},
+		{"unknown point of interest", Config{Distances: []Distance{{POI: "School", Km: 5, Weight: 10}}}, true},
+		{"distance without km", Config{Distances: []Distance{{POI: "Work", Weight: 10}}}, true},
+		{"everything", Config{Budget: 1, PriceWeight: 1, Distances: []Distance{{POI: "Work", Km: 5, Weight: 10}}}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.config.Resolve(pois); (err != nil) != tt.wantErr {
+				t.Errorf("expected error %v, got %v", tt.wantErr, err)
+			}
+		})
+	}
+}
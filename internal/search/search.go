@@ -0,0 +1,204 @@
+package search
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/BrunoTeixeira1996/gmah/internal/geo"
+	"github.com/BrunoTeixeira1996/gmah/internal/listing"
+)
+
+// Mode tells when the matches of a saved search are sent
+type Mode string
+
+const (
+	// Instant sends one alert per matching listing
+	Instant Mode = "instant"
+	// Digest sends one alert with all the matching listings of the run
+	Digest Mode = "digest"
+)
+
+// SavedSearch is a named set of rules evaluated against every new listing
+// zero values mean the rule is not used
+type SavedSearch struct {
+	Name       string   `json:"name"`
+	Typologies []string `json:"typologies"`
+	MinPrice   int      `json:"min_price"`
+	MaxPrice   int      `json:"max_price"`
+	MinArea    int      `json:"min_area"`
+	MaxArea    int      `json:"max_area"`
+	Locations  []string `json:"locations"`
+	Keywords   []string `json:"keywords"`
+	Portals    []string `json:"portals"`
+	// Within are the points of interest the listing must be close to
+	Within []Within `json:"within"`
+	// MinScore is the lowest score of the listings, it needs the scoring of the config
+	MinScore int      `json:"min_score"`
+	Mode     Mode     `json:"mode"`
+	Channels []string `json:"channels"`
+}
+
+// Within is the rule "within Km km of POI", the point of interest is one of the config
+type Within struct {
+	POI string  `json:"poi"`
+	Km  float64 `json:"km"`
+	// point is set by Resolve
+	point *geo.POI
+}
+
+// Resolve finds the point of interest of the rule
+func (w *Within) Resolve(pois []geo.POI) error {
+	if w.Km <= 0 {
+		return fmt.Errorf("within %q needs a positive km", w.POI)
+	}
+	p, ok := geo.FindPOI(pois, w.POI)
+	if !ok {
+		return fmt.Errorf("unknown point of interest %q", w.POI)
+	}
+	w.point = &p
+	return nil
+}
+
+// Match reports whether the listing was located within the distance of the point of interest
+// a listing the gazetteer did not find or only found its district, or a rule that was not resolved, never matches
+func (w Within) Match(l listing.Listing) bool {
+	if w.point == nil || l.Place == nil {
+		return false
+	}
+	km, ok := l.Place.DistanceTo(*w.point)
+	return ok && km <= w.Km
+}
+
+// Matches holds the listings that matched a saved search
+type Matches struct {
+	Search   SavedSearch
+	Listings []listing.Listing
+}
+
+var foldRegex = regexp.MustCompile(`[^a-z0-9 ]+`)
+
+// fold prepares a string for comparison the same way the email snippets are cleaned,
+// otherwise "Glória" would never match the "Glria" that comes from the snippet
+func fold(s string) string {
+	return strings.Join(strings.Fields(foldRegex.ReplaceAllString(strings.ToLower(s), "")), " ")
+}
+
+func containsFold(values []string, want string) bool {
+	for _, v := range values {
+		if fold(v) == fold(want) {
+			return true
+		}
+	}
+	return false
+}
+
+// Validate checks if the saved search is usable
+func (s SavedSearch) Validate() error {
+	if s.Name == "" {
+		return fmt.Errorf("saved search without name")
+	}
+
+	switch s.Mode {
+	case Instant, Digest:
+	default:
+		return fmt.Errorf("saved search %q has invalid mode %q (use %q or %q)", s.Name, s.Mode, Instant, Digest)
+	}
+
+	if s.MaxPrice != 0 && s.MinPrice > s.MaxPrice {
+		return fmt.Errorf("saved search %q has min_price bigger than max_price", s.Name)
+	}
+
+	if s.MaxArea != 0 && s.MinArea > s.MaxArea {
+		return fmt.Errorf("saved search %q has min_area bigger than max_area", s.Name)
+	}
+
+	return nil
+}
+
+// Match reports whether a listing satisfies every rule of the saved search
+// a rule about price or area never matches a listing where that value is unknown
+func (s SavedSearch) Match(l listing.Listing) bool {
+	if len(s.Portals) > 0 && !containsFold(s.Portals, l.Portal) {
+		return false
+	}
+
+	if len(s.Typologies) > 0 && !containsFold(s.Typologies, l.Typology) {
+		return false
+	}
+
+	if (s.MinPrice != 0 || s.MaxPrice != 0) && l.Price == 0 {
+		return false
+	}
+	if s.MinPrice != 0 && l.Price < s.MinPrice {
+		return false
+	}
+	if s.MaxPrice != 0 && l.Price > s.MaxPrice {
+		return false
+	}
+
+	if (s.MinArea != 0 || s.MaxArea != 0) && l.Area == 0 {
+		return false
+	}
+	if s.MinArea != 0 && l.Area < s.MinArea {
+		return false
+	}
+	if s.MaxArea != 0 && l.Area > s.MaxArea {
+		return false
+	}
+
+	if len(s.Locations) > 0 {
+		where := l.Location
+		if where == "" {
+			where = l.Title
+		}
+		found := false
+		for _, loc := range s.Locations {
+			if strings.Contains(fold(where), fold(loc)) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+
+	if s.MinScore != 0 && l.Score < s.MinScore {
+		return false
+	}
+
+	for _, w := range s.Within {
+		if !w.Match(l) {
+			return false
+		}
+	}
+
+	for _, k := range s.Keywords {
+		if !strings.Contains(fold(l.Title), fold(k)) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// Evaluate returns, for every saved search with at least one match, the matching listings
+// best score first
+func Evaluate(searches []SavedSearch, listings []listing.Listing) []Matches {
+	var matches []Matches
+	for _, s := range searches {
+		m := Matches{Search: s}
+		for _, l := range listings {
+			if s.Match(l) {
+				m.Listings = append(m.Listings, l)
+			}
+		}
+		if len(m.Listings) > 0 {
+			sort.SliceStable(m.Listings, func(i, j int) bool { return m.Listings[i].Score > m.Listings[j].Score })
+			matches = append(matches, m)
+		}
+	}
+	return matches
+}
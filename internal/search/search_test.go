@@ -0,0 +1,119 @@
+package search
+
+import (
+	"testing"
+
+	"github.com/BrunoTeixeira1996/gmah/internal/geo"
+	"github.com/BrunoTeixeira1996/gmah/internal/listing"
+)
+
+// Test the Match function
+func TestMatch(t *testing.T) {
+	t3Aveiro := SavedSearch{
+		Name:       "T3 Aveiro under 250k with at least 100m2",
+		Typologies: []string{"T3"},
+		MaxPrice:   250000,
+		MinArea:    100,
+		Locations:  []string{"Aveiro"},
+		Mode:       Instant,
+	}
+
+	// University of Aveiro
+	pois := []geo.POI{{Name: "Work", Lat: 40.6302, Lon: -8.6575}}
+	nearWork := SavedSearch{Name: "Near work", Within: []Within{{POI: "work", Km: 5}}, Mode: Digest}
+	if err := nearWork.Within[0].Resolve(pois); err != nil {
+		t.Fatal(err)
+	}
+	esgueira := &geo.Place{ID: "aveiro/aveiro/esgueira", District: "Aveiro", Municipality: "Aveiro", Parish: "Esgueira", Lat: 40.65, Lon: -8.63}
+	anadia := &geo.Place{ID: "aveiro/anadia", District: "Aveiro", Municipality: "Anadia", Lat: 40.4386, Lon: -8.4356}
+	// The seat of the district is close to work but the listing can be anywhere in it
+	district := &geo.Place{ID: "aveiro", District: "Aveiro", Lat: 40.6405, Lon: -8.6538}
+
+	tests := []struct {
+		name    string
+		search  SavedSearch
+		listing listing.Listing
+		want    bool
+	}{
+		{
+			name:    "all rules match",
+			search:  t3Aveiro,
+			listing: listing.Listing{Portal: "CasaYes", Title: "Moradia T3 Esgueira Aveiro", Typology: "T3", Price: 205000, Area: 119, Location: "Esgueira Aveiro"},
+			want:    true,
+		},
+		{
+			name:    "too expensive",
+			search:  t3Aveiro,
+			listing: listing.Listing{Typology: "T3", Price: 260000, Area: 119, Location: "Esgueira Aveiro"},
+			want:    false,
+		},
+		{
+			name:    "unknown area",
+			search:  t3Aveiro,
+			listing: listing.Listing{Typology: "T3", Price: 205000, Location: "Esgueira Aveiro"},
+			want:    false,
+		},
+		{
+			name:    "wrong typology",
+			search:  t3Aveiro,
+			listing: listing.Listing{Typology: "T2", Price: 205000, Area: 119, Location: "Esgueira Aveiro"},
+			want:    false,
+		},
+		{
+			name:    "accents are ignored like in the snippets",
+			search:  SavedSearch{Name: "Gloria", Locations: []string{"Glória e Vera Cruz"}, Mode: Digest},
+			listing: listing.Listing{Title: "Apartamento T3 venda em Glria e Vera Cruz", Location: "Glria e Vera Cruz"},
+			want:    true,
+		},
+		{
+			name:    "keywords and portals",
+			search:  SavedSearch{Name: "Moradias", Keywords: []string{"moradia"}, Portals: []string{"imovirtual"}, Mode: Digest},
+			listing: listing.Listing{Portal: "Imovirtual", Title: "Moradia T3 para venda em Anadia"},
+			want:    true,
+		},
+		{
+			name:    "within 5 km of work",
+			search:  nearWork,
+			listing: listing.Listing{Title: "Moradia T3 Esgueira", Place: esgueira},
+			want:    true,
+		},
+		{
+			name:    "too far from work",
+			search:  nearWork,
+			listing: listing.Listing{Title: "Moradia T3 Anadia", Place: anadia},
+			want:    false,
+		},
+		{
+			name:    "only the district known",
+			search:  nearWork,
+			listing: listing.Listing{Title: "Moradia T3 distrito de Aveiro", Place: district},
+			want:    false,
+		},
+		{
+			name:    "unknown place",
+			search:  nearWork,
+			listing: listing.Listing{Title: "Moradia T3"},
+			want:    false,
+		},
+		{
+			name:    "score under the minimum",
+			search:  SavedSearch{Name: "Good ones", MinScore: 60, Mode: Digest},
+			listing: listing.Listing{Title: "Moradia T3", Score: 55},
+			want:    false,
+		},
+		{
+			name:    "unresolved point of interest",
+			search:  SavedSearch{Name: "Near school", Within: []Within{{POI: "School", Km: 5}}, Mode: Digest},
+			listing: listing.Listing{Title: "Moradia T3 Esgueira", Place: esgueira},
+			want:    false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.search.Match(tt.listing); got != tt.want {
+				t.Errorf("expected Match to be %v, got %v", tt.want, got)
+			}
+		})
+	}
+}
@@ -0,0 +1,276 @@
+package serve
+
+import (
+	"embed"
+	"errors"
+	"fmt"
+	"html/template"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"sync"
+
+	"github.com/BrunoTeixeira1996/gmah/internal/export"
+	"github.com/BrunoTeixeira1996/gmah/internal/extraction"
+	"github.com/BrunoTeixeira1996/gmah/internal/geo"
+	"github.com/BrunoTeixeira1996/gmah/internal/listing"
+	"github.com/BrunoTeixeira1996/gmah/internal/logging"
+	"github.com/BrunoTeixeira1996/gmah/internal/lookup"
+	"github.com/BrunoTeixeira1996/gmah/internal/mapview"
+	"github.com/BrunoTeixeira1996/gmah/internal/report"
+	"github.com/BrunoTeixeira1996/gmah/internal/requests"
+	"github.com/BrunoTeixeira1996/gmah/internal/runner"
+)
+
+// The default templates and stylesheet
+//
+//go:embed templates static
+var embedded embed.FS
+
+// Pages that use the layout of templates/layout.html
+var pageNames = []string{"index.html", "runs.html", "run.html", "parsers.html", "logs.html", "days.html", "reports.html", "report.html", "login.html", "map.html"}
+
+// The daily page is written to a file and has its own layout
+const dailyName = "serve_template.html"
+
+var funcs = template.FuncMap{
+	"percent": func(f float64) string { return fmt.Sprintf("%.1f%%", f*100) },
+	"dec":     func(i int) int { return i - 1 },
+	"add":     func(a, b int) int { return a + b },
+	"price":   requests.FormatPrice,
+	// km is the distance from a listing to a point of interest, empty when the listing has no place
+	"km": func(r listing.Record, p geo.POI) string {
+		if km, ok := export.Distance(r, p); ok {
+			return fmt.Sprintf("%.1f km", km)
+		}
+		return ""
+	},
+	"div": func(a, b int) int {
+		if b == 0 {
+			return 0
+		}
+		return a / b
+	},
+}
+
+// overlay reads files from dir first and falls back to base
+type overlay struct {
+	dir  fs.FS
+	base fs.FS
+}
+
+func (o overlay) Open(name string) (fs.File, error) {
+	if o.dir != nil {
+		f, err := o.dir.Open(name)
+		if err == nil {
+			return f, nil
+		}
+		if !errors.Is(err, fs.ErrNotExist) {
+			return nil, err
+		}
+	}
+	return o.base.Open(name)
+}
+
+// Templates are the parsed pages and the files they use
+type Templates struct {
+	files fs.FS
+	pages map[string]*template.Template
+	dir   string
+	// Source tells where the templates came from
+	Source string
+}
+
+// Load parses the embedded templates, the files inside overrideDir replace the embedded ones
+// overrideDir mirrors the embedded layout: templates/*.html and static/*
+func Load(overrideDir string) (*Templates, error) {
+	t := &Templates{files: overlay{base: embedded}, pages: map[string]*template.Template{}, dir: overrideDir, Source: "embedded"}
+	if overrideDir != "" {
+		if _, err := os.Stat(overrideDir); err != nil {
+			return nil, err
+		}
+		t.files = overlay{dir: os.DirFS(overrideDir), base: embedded}
+		t.Source = "embedded with overrides from " + overrideDir
+	}
+
+	for _, name := range pageNames {
+		page, err := template.New(name).Funcs(funcs).ParseFS(t.files, "templates/layout.html", "templates/"+name)
+		if err != nil {
+			return nil, err
+		}
+		t.pages[name] = page
+	}
+
+	daily, err := template.New(dailyName).Funcs(funcs).ParseFS(t.files, "templates/"+dailyName)
+	if err != nil {
+		return nil, err
+	}
+	t.pages[dailyName] = daily
+
+	return t, nil
+}
+
+// Check parses the templates again from where they came from
+// it fails when the override dir was removed or a template in it no longer parses
+func (t *Templates) Check() error {
+	if t.dir == "" {
+		return nil
+	}
+	_, err := Load(t.dir)
+	return err
+}
+
+var (
+	mu      sync.RWMutex
+	current = mustLoadDefault()
+)
+
+func mustLoadDefault() *Templates {
+	t, err := Load("")
+	if err != nil {
+		panic(err)
+	}
+	return t
+}
+
+// Use makes every page render with t
+func Use(t *Templates) {
+	mu.Lock()
+	defer mu.Unlock()
+	current = t
+}
+
+// Current returns the templates in use
+func Current() *Templates {
+	mu.RLock()
+	defer mu.RUnlock()
+	return current
+}
+
+// Static serves the stylesheet and the other static files
+func Static() http.Handler {
+	static, err := fs.Sub(Current().files, "static")
+	if err != nil {
+		panic(err)
+	}
+	return http.FileServer(http.FS(static))
+}
+
+// Function that renders the page name with data
+func render(w io.Writer, name string, data interface{}) error {
+	return Current().pages[name].ExecuteTemplate(w, name, data)
+}
+
+// IndexPage writes the homepage
+func IndexPage(w io.Writer) error {
+	return render(w, "index.html", nil)
+}
+
+// RunsPage writes the run history
+func RunsPage(w io.Writer, runs []runner.Run) error {
+	return render(w, "runs.html", runs)
+}
+
+// RunPage writes the report of a single run
+func RunPage(w io.Writer, run runner.Run) error {
+	return render(w, "run.html", run)
+}
+
+// ParsersPage writes the extraction success rate of every portal
+func ParsersPage(w io.Writer, stats []extraction.Stats) error {
+	return render(w, "parsers.html", stats)
+}
+
+// DaysPage writes the links to the daily pages of a range of days
+func DaysPage(w io.Writer, days []lookup.Day) error {
+	return render(w, "days.html", days)
+}
+
+// LogsPage writes the recent logs
+func LogsPage(w io.Writer, entries []logging.Entry) error {
+	return render(w, "logs.html", struct {
+		Entries []logging.Entry
+		Levels  []string
+	}{entries, []string{"debug", "info", "warn", "error"}})
+}
+
+// ReportsPage writes the links to the recent weekly and monthly reports
+func ReportsPage(w io.Writer, weeks []report.Period, months []report.Period) error {
+	return render(w, "reports.html", struct {
+		Weeks  []report.Period
+		Months []report.Period
+	}{weeks, months})
+}
+
+// ReportPage writes a weekly or monthly report
+func ReportPage(w io.Writer, r report.Report) error {
+	return render(w, "report.html", r)
+}
+
+// MapFilter is the form above the map
+type MapFilter struct {
+	// Query has the parameters of the page
+	Query url.Values
+	// Searches are the names of the saved searches
+	Searches []string
+	POIs     []geo.POI
+}
+
+// Get returns the query parameter name
+func (f MapFilter) Get(name string) string {
+	return f.Query.Get(name)
+}
+
+// SortURL returns the page with the same filters sorted by
+func (f MapFilter) SortURL(by string) string {
+	q := url.Values{}
+	for k, v := range f.Query {
+		q[k] = v
+	}
+	q.Set("sort", by)
+	return "/map?" + q.Encode()
+}
+
+// ZoomURL returns the page with the same filters zoomed around lat and lon
+func (f MapFilter) ZoomURL(lat, lon float64, zoom int) string {
+	q := url.Values{}
+	for k, v := range f.Query {
+		q[k] = v
+	}
+	q.Set("zoom", strconv.Itoa(zoom))
+	q.Set("lat", strconv.FormatFloat(lat, 'f', 5, 64))
+	q.Set("lon", strconv.FormatFloat(lon, 'f', 5, 64))
+	return "/map?" + q.Encode()
+}
+
+// AllURL returns the page with the same filters showing every listing
+func (f MapFilter) AllURL() string {
+	q := url.Values{}
+	for k, v := range f.Query {
+		q[k] = v
+	}
+	q.Del("zoom")
+	q.Del("lat")
+	q.Del("lon")
+	return "/map?" + q.Encode()
+}
+
+// MapPage writes the map of the listings and the table of the ones that are on it
+func MapPage(w io.Writer, m mapview.Map, f MapFilter, records []listing.Record) error {
+	return render(w, "map.html", struct {
+		Map     mapview.Map
+		Filter  MapFilter
+		Records []listing.Record
+	}{m, f, records})
+}
+
+// LoginPage writes the login form, next is where to go after logging in
+func LoginPage(w io.Writer, next string, errMsg string) error {
+	return render(w, "login.html", struct {
+		Next  string
+		Error string
+	}{next, errMsg})
+}
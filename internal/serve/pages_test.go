@@ -0,0 +1,110 @@
+package serve
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// Test the Load function
+func TestLoad(t *testing.T) {
+	tests := []struct {
+		name      string
+		overrides map[string]string
+		wantErr   bool
+		wantIndex string
+		wantCSS   string
+	}{
+		{"embedded", nil, false, "Daily pages", "item-poster"},
+		{"custom index", map[string]string{"templates/index.html": `{{template "header" .}}My gmah{{template "footer" .}}`}, false, "My gmah", "item-poster"},
+		{"custom stylesheet", map[string]string{"static/style.css": "body { color: red; }"}, false, "Daily pages", "color: red"},
+		{"broken template", map[string]string{"templates/run.html": `{{template "header" .}}{{if}}`}, true, "", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := ""
+			if tt.overrides != nil {
+				dir = t.TempDir()
+				for name, content := range tt.overrides {
+					path := filepath.Join(dir, name)
+					if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+						t.Fatal(err)
+					}
+					if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+						t.Fatal(err)
+					}
+				}
+			}
+
+			templates, err := Load(dir)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("expected error %v, got %v", tt.wantErr, err)
+			}
+			if err != nil {
+				return
+			}
+
+			var b bytes.Buffer
+			if err := templates.pages["index.html"].ExecuteTemplate(&b, "index.html", nil); err != nil || !strings.Contains(b.String(), tt.wantIndex) {
+				t.Errorf("expected index to contain %q, got %q (%v)", tt.wantIndex, b.String(), err)
+			}
+
+			css, err := templates.files.Open("static/style.css")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer css.Close()
+			b.Reset()
+			b.ReadFrom(css)
+			if !strings.Contains(b.String(), tt.wantCSS) {
+				t.Errorf("expected stylesheet to contain %q, got %q", tt.wantCSS, b.String())
+			}
+		})
+	}
+}
+
+// Test the Check function
+func TestCheck(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "templates", "index.html")
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte(`{{template "header" .}}My gmah{{template "footer" .}}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	templates, err := Load(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := templates.Check(); err != nil {
+		t.Errorf("expected the templates to check, got %v", err)
+	}
+
+	// Someone broke the template after gmah started
+	if err := os.WriteFile(path, []byte(`{{template "header" .}}{{if}}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := templates.Check(); err == nil {
+		t.Error("expected the broken template to fail the check")
+	}
+
+	if err := os.RemoveAll(dir); err != nil {
+		t.Fatal(err)
+	}
+	if err := templates.Check(); err == nil {
+		t.Error("expected the missing override dir to fail the check")
+	}
+
+	embedded, err := Load("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := embedded.Check(); err != nil {
+		t.Errorf("expected the embedded templates to check, got %v", err)
+	}
+}
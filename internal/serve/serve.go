@@ -3,8 +3,9 @@ package serve
 import (
 	"bufio"
 	"bytes"
+	"log/slog"
 	"os"
-	"text/template"
+	"path/filepath"
 	"time"
 
 	"github.com/BrunoTeixeira1996/gmah/internal/email"
@@ -21,6 +22,7 @@ func writeTemplateToFile(outputPath string, outTemp bytes.Buffer) error {
 	if err != nil {
 		return err
 	}
+	defer f.Close()
 	w := bufio.NewWriter(f)
 	if _, err := w.WriteString(string(outTemp.Bytes())); err != nil {
 		return err
@@ -33,31 +35,24 @@ func writeTemplateToFile(outputPath string, outTemp bytes.Buffer) error {
 	return nil
 }
 
-func CreateHTMLFile(emails []email.EmailTemplate, htmlLocation string, isGokrazy bool) error {
+// CreateHTMLFile writes the daily page with the emails of the run to htmlLocation
+func CreateHTMLFile(logger *slog.Logger, emails []email.EmailTemplate, htmlLocation string) error {
 	serve := &Serve{}
-	// FIXME: This is breaking gokrazy conf
-	tmpl := "serve_template.html"
-	if isGokrazy {
-		tmpl = "/perm/home/gmah/serve_template.html"
-	}
-	templ, err := template.New("serve_template.html").ParseFiles(tmpl)
-	if err != nil {
-		return err
-	}
 
 	var outTemp bytes.Buffer
 	// This is the struct that is written in the html template
 	serve.Date = time.Now().Format("2006-01-02")
 	serve.Emails = emails
-	if err := templ.Execute(&outTemp, serve); err != nil {
+	if err := render(&outTemp, dailyName, serve); err != nil {
 		return err
 	}
 
 	fileName := time.Now().Format("2006-01-02") + "_serve.html"
-	outputPath := htmlLocation + fileName
+	outputPath := filepath.Join(htmlLocation, fileName)
 	if err := writeTemplateToFile(outputPath, outTemp); err != nil {
 		return err
 	}
+	logger.Info("Wrote daily page", "path", outputPath, "emails", len(emails))
 
 	return nil
 }
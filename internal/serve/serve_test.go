@@ -0,0 +1,27 @@
+package serve
+
+import (
+	"log/slog"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/BrunoTeixeira1996/gmah/internal/email"
+)
+
+// Test the CreateHTMLFile function with and without a trailing slash in the dump directory
+func TestCreateHTMLFile(t *testing.T) {
+	for _, suffix := range []string{"", "/"} {
+		dir := t.TempDir()
+		emails := []email.EmailTemplate{{From: "CasaYes", Snippet: "Moradia T3 Esgueira Aveiro", Link: "https://casayes.pt/1"}}
+		if err := CreateHTMLFile(slog.Default(), emails, dir+suffix); err != nil {
+			t.Fatal(err)
+		}
+		// The lookups read the page from the same path
+		path := filepath.Join(dir, time.Now().Format("2006-01-02")+"_serve.html")
+		if _, err := os.Stat(path); err != nil {
+			t.Errorf("expected the daily page at %s with %q after the directory (%v)", path, suffix, err)
+		}
+	}
+}
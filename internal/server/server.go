@@ -0,0 +1,229 @@
+package server
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// TLS modes
+const (
+	TLSOff        = "off"
+	TLSFiles      = "files"
+	TLSSelfSigned = "self-signed"
+)
+
+// Timeouts of the server, long enough for an export and short enough to drop stuck clients
+const (
+	ReadHeaderTimeout = 10 * time.Second
+	ReadTimeout       = 30 * time.Second
+	WriteTimeout      = 2 * time.Minute
+	IdleTimeout       = 2 * time.Minute
+)
+
+// Config is the server section of the config
+type Config struct {
+	// Listen is the address of the server, defaults to :9090
+	Listen string `json:"listen"`
+	// TLS is off, files (CertFile and KeyFile) or self-signed, defaults to off
+	TLS      string `json:"tls"`
+	CertFile string `json:"cert_file"`
+	KeyFile  string `json:"key_file"`
+	// CertDir keeps the self-signed certificate, defaults to tls/ inside the data directory
+	CertDir string `json:"cert_dir"`
+	// Hosts are the names and IPs of the self-signed certificate, defaults to the hostname and the local IPs
+	Hosts []string `json:"hosts"`
+	// RedirectListen is a plain HTTP address that redirects to the HTTPS one, like :9080
+	RedirectListen string `json:"redirect_listen"`
+	// PublicURL is the base of the links sent in the notifications, like https://gmah.lan:9090
+	PublicURL string `json:"public_url"`
+}
+
+// Defaults fills the fields that were not set
+func (c *Config) Defaults() {
+	if c.Listen == "" {
+		c.Listen = ":9090"
+	}
+	if c.TLS == "" {
+		c.TLS = TLSOff
+	}
+}
+
+// Validate checks the addresses and the TLS settings
+func (c Config) Validate() error {
+	if _, _, err := net.SplitHostPort(c.Listen); err != nil {
+		return fmt.Errorf("server listen %q: %w", c.Listen, err)
+	}
+	switch c.TLS {
+	case TLSOff:
+		if c.RedirectListen != "" {
+			return fmt.Errorf("server redirect_listen needs tls")
+		}
+	case TLSFiles:
+		if c.CertFile == "" || c.KeyFile == "" {
+			return fmt.Errorf("server tls %q needs cert_file and key_file", TLSFiles)
+		}
+	case TLSSelfSigned:
+	default:
+		return fmt.Errorf("unknown server tls %q (use %s, %s or %s)", c.TLS, TLSOff, TLSFiles, TLSSelfSigned)
+	}
+	if c.RedirectListen != "" {
+		if _, _, err := net.SplitHostPort(c.RedirectListen); err != nil {
+			return fmt.Errorf("server redirect_listen %q: %w", c.RedirectListen, err)
+		}
+	}
+	if c.PublicURL != "" && !strings.HasPrefix(c.PublicURL, "http://") && !strings.HasPrefix(c.PublicURL, "https://") {
+		return fmt.Errorf("server public_url %q must start with http:// or https://", c.PublicURL)
+	}
+	return nil
+}
+
+// Secure reports whether the server talks HTTPS
+func (c Config) Secure() bool {
+	return c.TLS != TLSOff
+}
+
+// LocalHost returns the first IPv4 address of the network interfaces that is not a loopback
+// it falls back to localhost when the machine has no network
+func LocalHost() string {
+	addrs, _ := net.InterfaceAddrs()
+	for _, a := range addrs {
+		if ipnet, ok := a.(*net.IPNet); ok && !ipnet.IP.IsLoopback() && ipnet.IP.To4() != nil {
+			return ipnet.IP.String()
+		}
+	}
+	return "localhost"
+}
+
+// BaseURL returns the public URL of the server, host is used when PublicURL is not set
+func (c Config) BaseURL(host string) string {
+	if c.PublicURL != "" {
+		return strings.TrimSuffix(c.PublicURL, "/")
+	}
+	_, port, _ := net.SplitHostPort(c.Listen)
+	scheme := "http"
+	if c.Secure() {
+		scheme = "https"
+	}
+	return scheme + "://" + net.JoinHostPort(host, port)
+}
+
+// Server is the web server and, with TLS, the server that redirects plain HTTP to it
+type Server struct {
+	cfg       Config
+	servers   []*http.Server
+	listeners []net.Listener
+}
+
+// Function that returns an http.Server with the timeouts
+func newHTTPServer(h http.Handler) *http.Server {
+	return &http.Server{
+		Handler:           h,
+		ReadHeaderTimeout: ReadHeaderTimeout,
+		ReadTimeout:       ReadTimeout,
+		WriteTimeout:      WriteTimeout,
+		IdleTimeout:       IdleTimeout,
+		ErrorLog:          slog.NewLogLogger(slog.Default().Handler(), slog.LevelWarn),
+	}
+}
+
+// Listen binds the addresses of the config and loads or creates the certificate
+// dataDir is where the self-signed certificate goes when CertDir is not set
+// nothing is served until Serve, so a bind error stops gmah before it starts
+func Listen(cfg Config, h http.Handler, dataDir string) (*Server, error) {
+	s := &Server{cfg: cfg}
+
+	srv := newHTTPServer(h)
+	if cfg.Secure() {
+		var cert tls.Certificate
+		var err error
+		switch cfg.TLS {
+		case TLSFiles:
+			cert, err = tls.LoadX509KeyPair(cfg.CertFile, cfg.KeyFile)
+		case TLSSelfSigned:
+			dir := cfg.CertDir
+			if dir == "" {
+				dir = filepath.Join(dataDir, "tls")
+			}
+			cert, err = SelfSigned(dir, cfg.Hosts, time.Now())
+		}
+		if err != nil {
+			return nil, fmt.Errorf("Error while loading the certificate: %w", err)
+		}
+		srv.TLSConfig = &tls.Config{Certificates: []tls.Certificate{cert}, MinVersion: tls.VersionTLS12}
+	}
+
+	ln, err := net.Listen("tcp", cfg.Listen)
+	if err != nil {
+		return nil, err
+	}
+	if srv.TLSConfig != nil {
+		ln = tls.NewListener(ln, srv.TLSConfig)
+	}
+	s.servers = append(s.servers, srv)
+	s.listeners = append(s.listeners, ln)
+
+	if cfg.RedirectListen != "" {
+		rln, err := net.Listen("tcp", cfg.RedirectListen)
+		if err != nil {
+			ln.Close()
+			return nil, err
+		}
+		s.servers = append(s.servers, newHTTPServer(RedirectHandler(cfg.Listen)))
+		s.listeners = append(s.listeners, rln)
+	}
+
+	return s, nil
+}
+
+// RedirectHandler sends every request to the same path on the HTTPS port of listen
+func RedirectHandler(listen string) http.HandlerFunc {
+	_, port, _ := net.SplitHostPort(listen)
+	return func(w http.ResponseWriter, r *http.Request) {
+		host, _, err := net.SplitHostPort(r.Host)
+		if err != nil {
+			host = r.Host
+		}
+		target := "https://" + net.JoinHostPort(host, port) + r.URL.RequestURI()
+		http.Redirect(w, r, target, http.StatusMovedPermanently)
+	}
+}
+
+// Addrs returns the addresses the server listens on
+func (s *Server) Addrs() []string {
+	var addrs []string
+	for _, ln := range s.listeners {
+		addrs = append(addrs, ln.Addr().String())
+	}
+	return addrs
+}
+
+// Serve answers the requests until Shutdown, errors other than the shutdown are sent to errc
+func (s *Server) Serve(errc chan<- error) {
+	for i := range s.servers {
+		srv, ln := s.servers[i], s.listeners[i]
+		go func() {
+			if err := srv.Serve(ln); err != nil && !errors.Is(err, http.ErrServerClosed) {
+				errc <- err
+			}
+		}()
+	}
+}
+
+// Shutdown stops accepting requests and waits for the ones in flight until ctx is done
+func (s *Server) Shutdown(ctx context.Context) error {
+	var errs []error
+	for _, srv := range s.servers {
+		if err := srv.Shutdown(ctx); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,93 @@
+package server
+
+import (
+	"crypto/x509"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// Test the SelfSigned function
+func TestSelfSigned(t *testing.T) {
+	dir := t.TempDir()
+	now := time.Now()
+	hosts := []string{"gmah.lan", "192.168.30.12"}
+
+	first, err := SelfSigned(dir, hosts, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	leaf, err := x509.ParseCertificate(first.Certificate[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, h := range hosts {
+		if err := leaf.VerifyHostname(h); err != nil {
+			t.Errorf("expected the certificate to cover %s: %v", h, err)
+		}
+	}
+
+	// The kept certificate is reused
+	again, err := SelfSigned(dir, hosts, now.Add(24*time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(again.Certificate[0]) != string(first.Certificate[0]) {
+		t.Error("expected the same certificate")
+	}
+
+	// A new host or a close expiry creates a new one
+	renewed, err := SelfSigned(dir, append(hosts, "gmah.home"), now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(renewed.Certificate[0]) == string(first.Certificate[0]) {
+		t.Error("expected a new certificate for the new host")
+	}
+	expiring, err := SelfSigned(dir, hosts, now.Add(CertValidity-RenewBefore/2))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(expiring.Certificate[0]) == string(renewed.Certificate[0]) {
+		t.Error("expected a new certificate before expiring")
+	}
+}
+
+// Test the Validate function
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		cfg     Config
+		wantErr bool
+	}{
+		{"defaults", Config{}, false},
+		{"self-signed with redirect", Config{TLS: TLSSelfSigned, RedirectListen: ":9080"}, false},
+		{"files without key", Config{TLS: TLSFiles, CertFile: "cert.pem"}, true},
+		{"redirect without tls", Config{RedirectListen: ":9080"}, true},
+		{"bad listen", Config{Listen: "9090"}, true},
+		{"unknown tls", Config{TLS: "acme"}, true},
+		{"public url without scheme", Config{PublicURL: "gmah.lan"}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.cfg.Defaults()
+			if err := tt.cfg.Validate(); (err != nil) != tt.wantErr {
+				t.Errorf("expected error %v, got %v", tt.wantErr, err)
+			}
+		})
+	}
+}
+
+// Test the RedirectHandler function
+func TestRedirectHandler(t *testing.T) {
+	w := httptest.NewRecorder()
+	RedirectHandler(":9090")(w, httptest.NewRequest("GET", "http://192.168.30.12:9080/runs?x=1", nil))
+	if w.Code != http.StatusMovedPermanently {
+		t.Fatalf("expected status 301, got %d", w.Code)
+	}
+	if got := w.Header().Get("Location"); got != "https://192.168.30.12:9090/runs?x=1" {
+		t.Errorf("unexpected location %s", got)
+	}
+}
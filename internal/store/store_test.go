@@ -0,0 +1,102 @@
+package store
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// Test the Save and Load functions
+func TestSaveLoad(t *testing.T) {
+	s, err := Open(filepath.Join(t.TempDir(), "data"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A document that was never saved leaves the value untouched
+	got := map[string]int{"kept": 1}
+	if err := s.Load("missing", &got); err != nil || got["kept"] != 1 {
+		t.Fatalf("expected the value to be kept, got %v (%v)", got, err)
+	}
+
+	want := map[string]int{"a": 1, "b": 2}
+	if err := s.Save("doc", want); err != nil {
+		t.Fatal(err)
+	}
+	got = nil
+	if err := s.Load("doc", &got); err != nil || len(got) != 2 || got["b"] != 2 {
+		t.Errorf("expected %v, got %v (%v)", want, got, err)
+	}
+
+	// A corrupt document is an error, not an empty one
+	if err := os.WriteFile(filepath.Join(s.Dir(), "broken.json"), []byte("{"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Load("broken", &got); err == nil {
+		t.Errorf("expected an error for a corrupt document")
+	}
+}
+
+// Test the WriteFile function
+func TestWriteFile(t *testing.T) {
+	s, err := Open(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+		file string
+		data string
+	}{
+		{"new file", "a.txt", "first"},
+		{"replaced file", "a.txt", "second"},
+		{"sub directory", "thumbnails/ab/c.jpg", "jpeg"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := s.WriteFile(tt.file, []byte(tt.data)); err != nil {
+				t.Fatal(err)
+			}
+			b, err := os.ReadFile(filepath.Join(s.Dir(), tt.file))
+			if err != nil || string(b) != tt.data {
+				t.Errorf("expected %q, got %q (%v)", tt.data, b, err)
+			}
+			// The temporary file is renamed, nothing is left next to it
+			tmp, _ := filepath.Glob(filepath.Join(s.Dir(), filepath.Dir(tt.file), "*.tmp"))
+			if len(tmp) != 0 {
+				t.Errorf("expected no temporary files, got %v", tmp)
+			}
+		})
+	}
+}
+
+// Test the Close function
+func TestClose(t *testing.T) {
+	s, err := Open(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Check(); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Save("doc", []int{1}); err != nil {
+		t.Fatal(err)
+	}
+
+	s.Close()
+	if err := s.Save("doc", []int{2}); !errors.Is(err, ErrClosed) {
+		t.Errorf("expected ErrClosed, got %v", err)
+	}
+	if err := s.Check(); !errors.Is(err, ErrClosed) {
+		t.Errorf("expected ErrClosed, got %v", err)
+	}
+
+	// Reads still work and see the last write before Close
+	var got []int
+	if err := s.Load("doc", &got); err != nil || len(got) != 1 || got[0] != 1 {
+		t.Errorf("expected [1], got %v (%v)", got, err)
+	}
+}